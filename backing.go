@@ -0,0 +1,413 @@
+package bigmap
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBatchSize is the default amount of writes
+	// passed to a BackingStore at once.
+	DefaultBatchSize = 128
+	// DefaultQueueSize is the default amount of keys
+	// with pending writes of a write-behind BackedMap.
+	DefaultQueueSize = 4096
+	// DefaultFlushInterval is the default time a write-behind
+	// BackedMap collects writes before it writes a batch.
+	DefaultFlushInterval = 100 * time.Millisecond
+	// DefaultRetries is the default amount of retries of failed batches.
+	DefaultRetries = 3
+	// DefaultRetryDelay is the default delay before the first retry,
+	// it is doubled for every following retry.
+	DefaultRetryDelay = 100 * time.Millisecond
+	// backingLocks is the amount of locks ordering the writes of the
+	// keys of a BackedMap.
+	backingLocks = 64
+)
+
+// ErrBackedMapClosed is returned by writes to a closed BackedMap.
+var ErrBackedMapClosed = errors.New("bigmap: backed map closed")
+
+// BackingWrite is a write passed to a BackingStore.
+type BackingWrite struct {
+	Key   []byte
+	Value []byte
+	// TTL is the time to live of the item, 0 if it doesn't expire.
+	TTL time.Duration
+	// Delete is true if the key was deleted.
+	Delete bool
+}
+
+// BackingStore persists the writes of a BackedMap,
+// usually into a slower key-value store or database.
+type BackingStore interface {
+	// Write applies the writes in order.
+	// The batch must not be retained.
+	Write(batch []BackingWrite) error
+}
+
+// WriteMode defines when a BackedMap writes to its BackingStore.
+type WriteMode uint8
+
+const (
+	// WriteThrough writes to the BackingStore before the map
+	// and returns the error of the BackingStore to the caller.
+	WriteThrough WriteMode = iota
+	// WriteBehind writes to the map and queues the write for the
+	// BackingStore. Pending writes of the same key are coalesced.
+	WriteBehind
+)
+
+// BackingConfig configures a BackedMap.
+// Values which are 0 will become the default values.
+type BackingConfig struct {
+	// Mode is WriteThrough or WriteBehind.
+	//
+	// Default: WriteThrough
+	Mode WriteMode
+	// BatchSize is the maximum amount of writes per batch.
+	//
+	// Default: 128
+	BatchSize int
+	// QueueSize is the maximum amount of keys with pending writes.
+	// Writes block while the queue is full.
+	//
+	// Default: 4096
+	QueueSize int
+	// FlushInterval is the time writes are collected
+	// before an incomplete batch is written.
+	//
+	// Default: 100ms
+	FlushInterval time.Duration
+	// Retries is the amount of retries of a failed batch.
+	// A negative value disables retries.
+	//
+	// Default: 3
+	Retries int
+	// RetryDelay is the delay before the first retry,
+	// it is doubled for every following retry.
+	//
+	// Default: 100ms
+	RetryDelay time.Duration
+	// OnRetry is called before a failed batch is retried.
+	OnRetry func(batch []BackingWrite, attempt int, err error)
+	// OnError is called with the batches of a write-behind map
+	// which failed after all retries. The writes are dropped.
+	// The deletes of a write-through map, whose errors can't be
+	// returned by Delete, are reported as well.
+	OnError func(batch []BackingWrite, err error)
+}
+
+// BackedMap is a BigMap in front of a BackingStore.
+// The map serves the reads while all writes are
+// passed on to the BackingStore.
+// The writes of a key are applied to the map and passed on
+// to the BackingStore in the same order.
+type BackedMap struct {
+	bigmap *BigMap
+	store  BackingStore
+	config BackingConfig
+	// keys orders the writes of the keys hashed onto them.
+	keys [backingLocks]sync.Mutex
+
+	lock     sync.Mutex
+	changed  *sync.Cond
+	pending  map[string]int
+	queue    []BackingWrite
+	flushing int
+	flushes  int
+	closed   bool
+	wake     chan struct{}
+	done     chan struct{}
+}
+
+var _ Store = (*BackedMap)(nil)
+
+// NewBackedMap creates a new BackedMap writing to the store.
+// A write-behind map starts a goroutine which writes the
+// queued batches until Close is called.
+func NewBackedMap(bigmap *BigMap, store BackingStore, config ...BackingConfig) *BackedMap {
+	conf := BackingConfig{
+		BatchSize:     DefaultBatchSize,
+		QueueSize:     DefaultQueueSize,
+		FlushInterval: DefaultFlushInterval,
+		Retries:       DefaultRetries,
+		RetryDelay:    DefaultRetryDelay,
+	}
+	if len(config) != 0 {
+		firstConf := config[0]
+		conf.Mode = firstConf.Mode
+		if firstConf.BatchSize > 0 {
+			conf.BatchSize = firstConf.BatchSize
+		}
+		if firstConf.QueueSize > 0 {
+			conf.QueueSize = firstConf.QueueSize
+		}
+		if firstConf.FlushInterval > 0 {
+			conf.FlushInterval = firstConf.FlushInterval
+		}
+		if firstConf.Retries != 0 {
+			conf.Retries = firstConf.Retries
+		}
+		if firstConf.RetryDelay > 0 {
+			conf.RetryDelay = firstConf.RetryDelay
+		}
+		conf.OnRetry = firstConf.OnRetry
+		conf.OnError = firstConf.OnError
+	}
+	M := &BackedMap{
+		bigmap:  bigmap,
+		store:   store,
+		config:  conf,
+		pending: make(map[string]int),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	M.changed = sync.NewCond(&M.lock)
+	if conf.Mode == WriteBehind {
+		go M.flusher()
+	} else {
+		close(M.done)
+	}
+	return M
+}
+
+// Map returns the BigMap of the BackedMap.
+func (M *BackedMap) Map() *BigMap {
+	return M.bigmap
+}
+
+// Put stores the item in the map and the BackingStore.
+func (M *BackedMap) Put(key, val []byte) error {
+	return M.PutTTL(key, val, 0)
+}
+
+// PutTTL stores the item like Put which expires after the ttl.
+// A ttl smaller or equal to 0 never expires.
+func (M *BackedMap) PutTTL(key, val []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	write := BackingWrite{Key: key, Value: val, TTL: ttl}
+	unlock := M.lockKey(key)
+	defer unlock()
+	if M.config.Mode == WriteThrough {
+		if err := M.bigmap.fits(key, val); err != nil {
+			return err
+		}
+		if err := M.writeThrough(write); err != nil {
+			return err
+		}
+		return M.bigmap.PutTTL(key, val, ttl)
+	}
+	if err := M.bigmap.PutTTL(key, val, ttl); err != nil {
+		return err
+	}
+	return M.enqueue(write)
+}
+
+// Delete removes the item from the map and the BackingStore.
+// The error of a write-through BackingStore is reported to
+// BackingConfig.OnError, the item is kept in the map in this case.
+func (M *BackedMap) Delete(key []byte) bool {
+	write := BackingWrite{Key: key, Delete: true}
+	unlock := M.lockKey(key)
+	defer unlock()
+	if M.config.Mode == WriteThrough {
+		if err := M.writeThrough(write); err != nil {
+			if err != ErrBackedMapClosed && M.config.OnError != nil {
+				M.config.OnError([]BackingWrite{write}, err)
+			}
+			return false
+		}
+		return M.bigmap.Delete(key)
+	}
+	deleted := M.bigmap.Delete(key)
+	M.enqueue(write)
+	return deleted
+}
+
+// Expire sets the time to live of an existing item
+// and writes the item with its new ttl to the BackingStore.
+func (M *BackedMap) Expire(key []byte, ttl time.Duration) bool {
+	if ttl < 0 {
+		ttl = 0
+	}
+	unlock := M.lockKey(key)
+	defer unlock()
+	val, ok := M.bigmap.Get(key)
+	if !ok {
+		return false
+	}
+	write := BackingWrite{Key: key, Value: val, TTL: ttl}
+	if M.config.Mode == WriteThrough {
+		if M.writeThrough(write) != nil {
+			return false
+		}
+		return M.bigmap.Expire(key, ttl)
+	}
+	if !M.bigmap.Expire(key, ttl) {
+		return false
+	}
+	return M.enqueue(write) == nil
+}
+
+// Get retrieves the item from the map.
+func (M *BackedMap) Get(key []byte) ([]byte, bool) {
+	return M.bigmap.Get(key)
+}
+
+// GetInto retrieves the item from the map and writes it into buffer.
+func (M *BackedMap) GetInto(key, buffer []byte) (uint64, bool) {
+	return M.bigmap.GetInto(key, buffer)
+}
+
+// TTL returns the remaining time to live of the item in the map.
+func (M *BackedMap) TTL(key []byte) (time.Duration, bool) {
+	return M.bigmap.TTL(key)
+}
+
+// Flush blocks until all queued writes were passed to the BackingStore.
+func (M *BackedMap) Flush() {
+	M.lock.Lock()
+	defer M.lock.Unlock()
+	M.flushes++
+	M.wakeUp()
+	for len(M.queue) != 0 || M.flushing != 0 {
+		M.changed.Wait()
+	}
+	M.flushes--
+}
+
+// Close flushes the queued writes and stops the BackedMap.
+// Writes after Close return ErrBackedMapClosed.
+func (M *BackedMap) Close() error {
+	M.lock.Lock()
+	M.closed = true
+	M.wakeUp()
+	M.lock.Unlock()
+	<-M.done
+	return nil
+}
+
+// lockKey locks the writes of the key and returns the unlock function.
+func (M *BackedMap) lockKey(key []byte) (unlock func()) {
+	lock := &M.keys[M.bigmap.hasher.Hash(key)%backingLocks]
+	lock.Lock()
+	return lock.Unlock
+}
+
+// writeThrough writes to the BackingStore with retries.
+func (M *BackedMap) writeThrough(write BackingWrite) error {
+	M.lock.Lock()
+	closed := M.closed
+	M.lock.Unlock()
+	if closed {
+		return ErrBackedMapClosed
+	}
+	return M.write([]BackingWrite{write})
+}
+
+// write passes the batch to the BackingStore and retries it
+// as configured.
+func (M *BackedMap) write(batch []BackingWrite) error {
+	delay := M.config.RetryDelay
+	err := M.store.Write(batch)
+	for attempt := 1; err != nil && attempt <= M.config.Retries; attempt++ {
+		if M.config.OnRetry != nil {
+			M.config.OnRetry(batch, attempt, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+		err = M.store.Write(batch)
+	}
+	return err
+}
+
+// enqueue queues the write or replaces the pending write of its key.
+// It blocks while the queue is full.
+func (M *BackedMap) enqueue(write BackingWrite) error {
+	write.Key = append([]byte(nil), write.Key...)
+	write.Value = append([]byte(nil), write.Value...)
+	M.lock.Lock()
+	defer M.lock.Unlock()
+	if M.closed {
+		return ErrBackedMapClosed
+	}
+	if i, ok := M.pending[string(write.Key)]; ok {
+		M.queue[i] = write
+		return nil
+	}
+	for len(M.queue) >= M.config.QueueSize && !M.closed {
+		M.changed.Wait()
+	}
+	if M.closed {
+		return ErrBackedMapClosed
+	}
+	M.pending[string(write.Key)] = len(M.queue)
+	M.queue = append(M.queue, write)
+	M.changed.Broadcast()
+	return nil
+}
+
+// wakeUp makes the flusher write the queued writes
+// without waiting for the flush interval.
+// The BackedMap must be locked.
+func (M *BackedMap) wakeUp() {
+	M.changed.Broadcast()
+	select {
+	case M.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flusher writes the queued batches of a write-behind map.
+func (M *BackedMap) flusher() {
+	defer close(M.done)
+	for {
+		M.lock.Lock()
+		for len(M.queue) == 0 && !M.closed {
+			M.changed.Wait()
+		}
+		if len(M.queue) == 0 && M.closed {
+			M.lock.Unlock()
+			return
+		}
+		if len(M.queue) < M.config.BatchSize && !M.closed && M.flushes == 0 {
+			// collect more writes for the batch
+			M.lock.Unlock()
+			timer := time.NewTimer(M.config.FlushInterval)
+			select {
+			case <-timer.C:
+			case <-M.wake:
+				timer.Stop()
+			}
+			M.lock.Lock()
+		}
+		n := len(M.queue)
+		if n > M.config.BatchSize {
+			n = M.config.BatchSize
+		}
+		batch := append([]BackingWrite(nil), M.queue[:n]...)
+		M.queue = append(M.queue[:0], M.queue[n:]...)
+		for key := range M.pending {
+			delete(M.pending, key)
+		}
+		for i, write := range M.queue {
+			M.pending[string(write.Key)] = i
+		}
+		M.flushing++
+		M.changed.Broadcast()
+		M.lock.Unlock()
+
+		if err := M.write(batch); err != nil && M.config.OnError != nil {
+			M.config.OnError(batch, err)
+		}
+
+		M.lock.Lock()
+		M.flushing--
+		M.changed.Broadcast()
+		M.lock.Unlock()
+	}
+}
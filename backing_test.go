@@ -0,0 +1,164 @@
+package bigmap
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryBacking struct {
+	lock    sync.Mutex
+	items   map[string]string
+	batches int
+	fails   int
+}
+
+func (M *memoryBacking) Write(batch []BackingWrite) error {
+	M.lock.Lock()
+	defer M.lock.Unlock()
+	if M.fails > 0 {
+		M.fails--
+		return errors.New("unavailable")
+	}
+	if M.items == nil {
+		M.items = make(map[string]string)
+	}
+	M.batches++
+	for _, write := range batch {
+		if write.Delete {
+			delete(M.items, string(write.Key))
+		} else {
+			M.items[string(write.Key)] = string(write.Value)
+		}
+	}
+	return nil
+}
+
+func (M *memoryBacking) get(key string) (string, bool) {
+	M.lock.Lock()
+	defer M.lock.Unlock()
+	val, ok := M.items[key]
+	return val, ok
+}
+
+func TestBackedMap_WriteThrough(t *testing.T) {
+	bm := New(16, Config{Shards: 4})
+	store := &memoryBacking{fails: 1}
+	retries := 0
+	var failed []BackingWrite
+	backed := NewBackedMap(&bm, store, BackingConfig{
+		RetryDelay: time.Millisecond,
+		OnRetry:    func(batch []BackingWrite, attempt int, err error) { retries++ },
+		OnError:    func(batch []BackingWrite, err error) { failed = append(failed, batch...) },
+	})
+	if err := backed.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if val, ok := store.get("key"); !ok || val != "value" || retries != 1 {
+		t.Fatalf("store got %q, %v after %d retries", val, ok, retries)
+	}
+
+	store.fails = 100
+	if err := backed.Put([]byte("key"), []byte("other")); err == nil {
+		t.Fatalf("expected the error of the store")
+	}
+	if val, _ := backed.Get([]byte("key")); string(val) != "value" {
+		t.Fatalf("failed write changed the map to %q", val)
+	}
+	if err := backed.Put([]byte("large"), make([]byte, 17)); err == nil {
+		t.Fatalf("expected the size error of the map")
+	}
+	if _, ok := store.get("large"); ok {
+		t.Fatalf("value to big for the map was written through")
+	}
+	if backed.Expire([]byte("key"), time.Hour) {
+		t.Fatalf("Expire returned true while the store fails")
+	}
+	if ttl, _ := backed.TTL([]byte("key")); ttl != 0 {
+		t.Fatalf("failed Expire changed the ttl of the map to %v", ttl)
+	}
+	if backed.Delete([]byte("key")) {
+		t.Fatalf("Delete returned true while the store fails")
+	}
+	if len(failed) != 1 || !failed[0].Delete || string(failed[0].Key) != "key" {
+		t.Fatalf("OnError got %v for the failed Delete", failed)
+	}
+	store.fails = 0
+	if !backed.Delete([]byte("key")) {
+		t.Fatalf("Delete returned false")
+	}
+	if _, ok := store.get("key"); ok {
+		t.Fatalf("Delete wasn't written through")
+	}
+	backed.Close()
+	if err := backed.Put([]byte("key"), nil); err != ErrBackedMapClosed {
+		t.Fatalf("Put after Close got %v, want %v", err, ErrBackedMapClosed)
+	}
+}
+
+func TestBackedMap_WriteBehind(t *testing.T) {
+	bm := New(16, Config{Shards: 4})
+	store := &memoryBacking{}
+	backed := NewBackedMap(&bm, store, BackingConfig{
+		Mode:          WriteBehind,
+		FlushInterval: time.Hour,
+	})
+	for i := 0; i < 100; i++ {
+		backed.Put([]byte("key"), []byte{byte(i)})
+	}
+	backed.Put([]byte("deleted"), []byte("value"))
+	backed.Delete([]byte("deleted"))
+	if _, ok := store.get("key"); ok {
+		t.Fatalf("write-behind wrote before the flush interval")
+	}
+	if val, ok := backed.Get([]byte("key")); !ok || val[0] != 99 {
+		t.Fatalf("Get got %v, %v", val, ok)
+	}
+	// the flusher waits for more writes and has to be woken by Close
+	time.Sleep(10 * time.Millisecond)
+	backed.Close()
+	if val, ok := store.get("key"); !ok || val != string([]byte{99}) {
+		t.Fatalf("store got %q, %v after Close", val, ok)
+	}
+	if _, ok := store.get("deleted"); ok {
+		t.Fatalf("Delete wasn't written")
+	}
+	if store.batches != 1 {
+		t.Fatalf("expected the writes to be coalesced into a batch, got %d", store.batches)
+	}
+}
+
+func TestBackedMap_WriteBehindErrors(t *testing.T) {
+	bm := New(16, Config{Shards: 4})
+	store := &memoryBacking{fails: 2}
+	failed := make(chan []BackingWrite, 1)
+	backed := NewBackedMap(&bm, store, BackingConfig{
+		Mode:          WriteBehind,
+		BatchSize:     2,
+		QueueSize:     2,
+		FlushInterval: time.Millisecond,
+		Retries:       1,
+		RetryDelay:    time.Millisecond,
+		OnError:       func(batch []BackingWrite, err error) { failed <- batch },
+	})
+	defer backed.Close()
+	backed.Put([]byte("lost"), []byte("value"))
+	select {
+	case batch := <-failed:
+		if len(batch) != 1 || string(batch[0].Key) != "lost" {
+			t.Fatalf("OnError got %v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("OnError wasn't called")
+	}
+	for i := 0; i < 10; i++ {
+		backed.Put([]byte{byte(i)}, []byte("value"))
+	}
+	backed.Flush()
+	for i := 0; i < 10; i++ {
+		if _, ok := store.get(string([]byte{byte(i)})); !ok {
+			t.Fatalf("item %d wasn't written", i)
+		}
+	}
+}
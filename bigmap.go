@@ -1,5 +1,11 @@
 package bigmap
 
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 const (
 	// DefaultCapacity is the default initial capacity of an shard in bytes
 	DefaultCapacity uint64 = 1024
@@ -7,6 +13,15 @@ const (
 	DefaultShards int = 32
 	// LengthBytes is the amount of bytes required to define the length
 	LengthBytes uint64 = 8
+	// DeadlineBytes is the amount of bytes required to define the
+	// expiration deadline of an item
+	DeadlineBytes uint64 = 8
+	// HeaderBytes is the amount of bytes each item requires
+	// in addition to its entrysize
+	HeaderBytes = LengthBytes + DeadlineBytes
+	// LifetimeBytes is the amount of bytes added to the header
+	// of each item if Config.RefreshAhead is set
+	LifetimeBytes uint64 = 8
 	// Offset64 is the offset for FNV64
 	Offset64 = 14695981039346656037
 	// Prime64 is the prime for FNV64
@@ -26,7 +41,13 @@ const (
 // and will never shrink again. This enables
 // the map to stay fast even with many accesses.
 type BigMap struct {
-	shards []*Shard
+	table     *atomic.Value // *shardTable, shared by copies of the map
+	resharder *sync.Mutex
+	entrysize uint64
+	hasher    Hasher
+	config    Config
+	watch     *watchHub
+	spaces    *namespaces
 }
 
 // Config defines values for a BigMap.
@@ -55,6 +76,99 @@ type Config struct {
 	//
 	// Default: nil
 	ExpirationFactory ExpirationFactory
+	// Hasher is used to hash the keys of the map.
+	// FNV64 is fast for short keys but unseeded.
+	// If untrusted parties control the keys a seeded
+	// Hasher like NewWyHasher() or NewMapHasher()
+	// should be used to resist hash flooding.
+	//
+	// Default: FNVHasher
+	Hasher Hasher
+	// EventBuffer is the amount of events buffered per shard
+	// for watchers before events are dropped.
+	//
+	// Default: 1024
+	EventBuffer int
+	// EventKeys adds the keys of the items to the events of watchers.
+	EventKeys bool
+	// EventValues adds the values of the items to the events of watchers.
+	EventValues bool
+	// LoadErrorTTL is the time the errors of the loaders of
+	// GetOrLoad are cached for their key.
+	// A value of 0 doesn't cache errors.
+	//
+	// Default: 0
+	LoadErrorTTL time.Duration
+	// RefreshLoader reloads items in the background when they are
+	// read after RefreshAhead or within the StaleGrace period.
+	// Only reads with a byte or string key and GetOrLoad reload.
+	// Set LoadErrorTTL to limit the reloads of failing items.
+	//
+	// Default: nil
+	RefreshLoader KeyLoader
+	// RefreshAhead is the fraction of the lifetime of an item after
+	// which reads reload it, e.g. 0.8 reloads an item with a ttl of
+	// a minute when it is read after 48 seconds.
+	// Each slot keeps 8 additional bytes to remember the lifetime.
+	// A value of 0 only reloads stale items.
+	//
+	// Default: 0
+	RefreshAhead float64
+	// StaleGrace is the time items are kept after their ttl passed.
+	// Reads during the grace period return the stale value and
+	// reload it. If the reload fails the stale value is served
+	// until the grace period passed.
+	//
+	// Default: 0
+	StaleGrace time.Duration
+	// OverflowDir enables the disk tier. Items evicted by the
+	// ExpirationService are written to a log per shard in the
+	// directory and moved back into memory when they are read.
+	// The logs are removed by Close and not reloaded by New.
+	// Evictions and reads of evicted items access the log
+	// while their shard is locked.
+	//
+	// Default: "" (evicted items are dropped)
+	OverflowDir string
+	// OverflowGarbage is the fraction of removed and overwritten
+	// items in a log which triggers its compaction in the background.
+	//
+	// Default: 0.5
+	OverflowGarbage float64
+	// Compression is the compress/flate level values are compressed
+	// with, e.g. flate.BestSpeed. Values are only stored compressed
+	// if that saves space. The entrysize limits the compressed size,
+	// so values larger than the entrysize can be stored if they
+	// compress well enough.
+	// A value of 0 or an invalid level doesn't compress.
+	//
+	// Default: 0
+	Compression int
+	// Keyring enables the encryption of values with AES-GCM.
+	// Values are encrypted in memory and in the disk tier,
+	// each value takes EncryptionOverhead additional bytes.
+	// Values which fail authentication are treated as missing
+	// by Get and GetInto and reported by Fetch and View.
+	//
+	// Default: nil
+	Keyring *Keyring
+	// Checksums adds a CRC32C to each item which is verified
+	// by reads, Snapshot, Reshard and when items are read back
+	// from the disk tier. Each slot keeps ChecksumBytes
+	// additional bytes. Corrupt items are treated as missing
+	// by Get and GetInto and reported as ErrCorrupt by Fetch
+	// and View. BigMap.Verify checks all items.
+	//
+	// Default: false
+	Checksums bool
+	// OrderedIndex maintains an ordered index over the keys of the
+	// items which enables BigMap.ScanPrefix and BigMap.ScanRange.
+	// Only items written with their key are indexed, e.g. items
+	// of PutHash, PutUint64 or a replication follower aren't.
+	// The index keeps a copy of every key in memory.
+	//
+	// Default: false
+	OrderedIndex bool
 }
 
 // New creates a new BigMap and populates its shards.
@@ -70,6 +184,8 @@ func New(entrysize uint64, config ...Config) BigMap {
 		Shards:            DefaultShards,
 		Capacity:          DefaultCapacity,
 		ExpirationFactory: nil,
+		Hasher:            FNVHasher{},
+		OverflowGarbage:   DefaultOverflowGarbage,
 	}
 	if len(config) != 0 {
 		firstConf := config[0]
@@ -79,19 +195,74 @@ func New(entrysize uint64, config ...Config) BigMap {
 		if firstConf.Shards != 0 {
 			conf.Shards = firstConf.Shards
 		}
+		if firstConf.Hasher != nil {
+			conf.Hasher = firstConf.Hasher
+		}
 		conf.ExpirationFactory = firstConf.ExpirationFactory
+		conf.EventBuffer = firstConf.EventBuffer
+		conf.EventKeys = firstConf.EventKeys
+		conf.EventValues = firstConf.EventValues
+		conf.LoadErrorTTL = firstConf.LoadErrorTTL
+		conf.RefreshLoader = firstConf.RefreshLoader
+		conf.RefreshAhead = firstConf.RefreshAhead
+		conf.StaleGrace = firstConf.StaleGrace
+		conf.OverflowDir = firstConf.OverflowDir
+		if validCompression(firstConf.Compression) {
+			conf.Compression = firstConf.Compression
+		}
+		conf.Keyring = firstConf.Keyring
+		conf.Checksums = firstConf.Checksums
+		conf.OrderedIndex = firstConf.OrderedIndex
+		if firstConf.OverflowGarbage > 0 {
+			conf.OverflowGarbage = firstConf.OverflowGarbage
+		}
 	}
 
-	bm := BigMap{shards: make([]*Shard, conf.Shards)}
+	bm := BigMap{
+		table:     &atomic.Value{},
+		resharder: &sync.Mutex{},
+		entrysize: entrysize,
+		hasher:    conf.Hasher,
+		config:    conf,
+		watch:     newWatchHub(conf),
+		spaces:    &namespaces{spaces: make(map[string]*Namespace)},
+	}
+	bm.table.Store(&shardTable{shards: bm.newShards(conf.Shards)})
+	return bm
+}
 
-	for i := 0; i < conf.Shards; i++ {
+func (B *BigMap) newShards(n int) []*Shard {
+	shards := make([]*Shard, n)
+	for i := 0; i < n; i++ {
 		var expirationService ExpirationService = nil
-		if conf.ExpirationFactory != nil {
-			expirationService = conf.ExpirationFactory(i)
+		if B.config.ExpirationFactory != nil {
+			expirationService = B.config.ExpirationFactory(i)
+		}
+		entrysize := B.entrysize
+		if B.config.Keyring != nil {
+			entrysize += EncryptionOverhead
 		}
-		bm.shards[i] = NewShard(conf.Capacity, entrysize, expirationService)
+		shards[i] = NewShard(B.config.Capacity, entrysize, expirationService)
+		if B.config.RefreshAhead > 0 {
+			shards[i].header += LifetimeBytes
+			shards[i].lifetimes = true
+		}
+		if B.config.Checksums {
+			shards[i].header += ChecksumBytes
+			shards[i].checksums = true
+		}
+		shards[i].grace = int64(B.config.StaleGrace)
+		shards[i].compression = B.config.Compression
+		shards[i].keyring = B.config.Keyring
+		if B.config.OrderedIndex {
+			shards[i].index = newKeyIndex()
+		}
+		if B.config.OverflowDir != "" {
+			shards[i].tier = newDiskTier(B.config.OverflowDir, B.config.OverflowGarbage, &shards[i].metrics)
+		}
+		B.installRing(shards[i])
 	}
-	return bm
+	return shards
 }
 
 // FNV64 hashes the byte-array with the FNV64 algorithm.
@@ -108,6 +279,31 @@ func FNV64(key []byte) uint64 {
 	return hash
 }
 
+// FNV64String hashes the string with the FNV64 algorithm.
+// It returns the same hash as FNV64([]byte(key))
+// without converting the string.
+func FNV64String(key string) uint64 {
+	var hash uint64 = Offset64
+	l := len(key)
+	for i := 0; i < l; i++ {
+		hash ^= uint64(key[i])
+		hash *= Prime64
+	}
+	return hash
+}
+
+// Mix64 scrambles the integer to spread it over the shards.
+// The function is a bijection so distinct integers never
+// result in the same hash.
+func Mix64(key uint64) uint64 {
+	key ^= key >> 33
+	key *= 0xff51afd7ed558ccd
+	key ^= key >> 33
+	key *= 0xc4ceb9fe1a85ec53
+	key ^= key >> 33
+	return key
+}
+
 // Put puts an item into the map by putting
 // it into corresponding shard of the key.
 //
@@ -115,8 +311,7 @@ func FNV64(key []byte) uint64 {
 // to the key returns an error. This happens if
 // the item is to big.
 func (B *BigMap) Put(key []byte, val []byte) error {
-	s, h := B.SelectShard(key)
-	return s.Put(h, val)
+	return B.put(B.hasher.Hash(key), key, val, 0)
 }
 
 // Get retrieves an item for the key.
@@ -124,27 +319,366 @@ func (B *BigMap) Put(key []byte, val []byte) error {
 // and a boolean if the item was contained. If the boolean
 // is false the slice will be nil.
 func (B *BigMap) Get(key []byte) ([]byte, bool) {
-	s, h := B.SelectShard(key)
-	return s.Get(h)
+	h := B.hasher.Hash(key)
+	val, ok := B.get(h)
+	if ok && B.config.RefreshLoader != nil && B.refreshDue(h) {
+		B.refresh(h, key)
+	}
+	return val, ok
 }
 
 // GetInto retrieves an item for the key and writes it into buffer.
 // Returns the size, true if the item was contained and 0, false otherwise.
+// If the size is larger than the buffer only len(buffer) bytes are
+// written, e.g. the decompressed value didn't fit, and the caller can
+// retry with a buffer of the returned size.
 func (B *BigMap) GetInto(key []byte, buffer []byte) (uint64, bool) {
-	s, h := B.SelectShard(key)
-	return s.GetInto(h, buffer)
+	h := B.hasher.Hash(key)
+	size, ok := B.getInto(h, buffer)
+	if ok && B.config.RefreshLoader != nil && B.refreshDue(h) {
+		B.refresh(h, key)
+	}
+	return size, ok
 }
 
 // Delete removes an item from the map.
 // Delete doesnt shrink the memory size of the map.
 // It only enables the space to be reused.
 func (B *BigMap) Delete(key []byte) bool {
-	s, h := B.SelectShard(key)
-	return s.Delete(h)
+	return B.delete(B.hasher.Hash(key), key)
+}
+
+// PutTTL puts an item into the map like Put
+// which expires after the ttl.
+// A ttl smaller or equal to 0 never expires.
+//
+// The expiration of single items is independent
+// from the ExpirationFactory of the map.
+func (B *BigMap) PutTTL(key []byte, val []byte, ttl time.Duration) error {
+	return B.put(B.hasher.Hash(key), key, val, deadlineOf(ttl))
+}
+
+// TTL returns the remaining time to live of an item and
+// true if the item is contained.
+// A TTL of 0 means the item doesn't expire.
+func (B *BigMap) TTL(key []byte) (ttl time.Duration, ok bool) {
+	return B.ttl(B.hasher.Hash(key))
+}
+
+// Expire sets the time to live of an existing item.
+// A ttl smaller or equal to 0 removes the expiration of the item.
+// It returns false if the item isn't contained.
+func (B *BigMap) Expire(key []byte, ttl time.Duration) (ok bool) {
+	return B.expireAt(B.hasher.Hash(key), key, deadlineOf(ttl))
+}
+
+// Update atomically reads and modifies the item of the key.
+// The shard of the item is locked while fn is called
+// therefore fn must not access the map.
+// See Shard.Update
+func (B *BigMap) Update(key []byte, fn UpdateFunc) (err error) {
+	h := B.hasher.Hash(key)
+	B.write(h, func(shard *Shard) (ok bool) {
+		ok, err = shard.update(h, key, fn, false)
+		return ok
+	})
+	return err
+}
+
+// Range calls fn for every item in the map until fn returns false.
+// The shards are locked one after another while ranging over them
+// therefore fn must not access the map.
+// The value must not be retained.
+func (B *BigMap) Range(fn func(key uint64, val []byte) bool) {
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	for _, s := range B.loadTable().shards {
+		if !s.rangeItems(fn) {
+			return
+		}
+	}
+}
+
+// Scan calls fn for every item of the shard at the cursor and
+// returns the cursor of the next shard or 0 if all shards were scanned.
+// A scan starts with the cursor 0.
+//
+// Items which are added or removed while scanning may or may not be
+// returned. If the map is resharded while scanning items may be
+// returned multiple times.
+func (B *BigMap) Scan(cursor uint64, fn func(key uint64, val []byte)) uint64 {
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	shards := B.loadTable().shards
+	if cursor >= uint64(len(shards)) {
+		return 0
+	}
+	shards[cursor].rangeItems(func(key uint64, val []byte) bool {
+		fn(key, val)
+		return true
+	})
+	cursor++
+	if cursor == uint64(len(shards)) {
+		return 0
+	}
+	return cursor
+}
+
+// Len returns the amount of items in the map.
+// Expired items which weren't removed yet are included.
+func (B *BigMap) Len() int {
+	table := B.loadTable()
+	size := 0
+	for _, s := range table.shards {
+		size += s.Len()
+	}
+	for _, s := range table.prev {
+		if !s.isRetired() {
+			size += s.Len()
+		}
+	}
+	return size
+}
+
+// Clear removes all items from the map.
+// The shards keep their allocated memory to be reused.
+func (B *BigMap) Clear() {
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	for _, s := range B.loadTable().shards {
+		s.Clear()
+	}
+}
+
+// Reset removes all items from the map and
+// shrinks every shard back to the configured capacity.
+func (B *BigMap) Reset() {
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	for _, s := range B.loadTable().shards {
+		s.Reset()
+	}
+}
+
+// Close removes the logs of the disk tier.
+// Items evicted afterwards are dropped.
+// Close does nothing without Config.OverflowDir.
+func (B *BigMap) Close() error {
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	var err error
+	for _, s := range B.loadTable().shards {
+		if s.tier == nil {
+			continue
+		}
+		if closeErr := s.tier.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// View calls fn with the item of the key and returns true
+// or returns false if the item isn't contained.
+// The value is read into a pooled buffer instead of a new slice
+// and must not be retained after fn returned.
+// An error is returned if the value can't be decoded,
+// fn isn't called in this case.
+func (B *BigMap) View(key []byte, fn func(val []byte)) (bool, error) {
+	return B.view(B.hasher.Hash(key), func(val []byte, _ uint64) { fn(val) })
+}
+
+// view calls fn with the item of the hash and the flags of its slot like View.
+func (B *BigMap) view(h uint64, fn func(val []byte, flags uint64)) (bool, error) {
+	return view(func(dst []byte) (found *Shard, val []byte, flags uint64, ok bool) {
+		B.read(h, func(shard *Shard) bool {
+			val, flags, ok = shard.copySlot(h, dst)
+			found = shard
+			return ok
+		})
+		return found, val, flags, ok
+	}, h, fn)
+}
+
+// Fetch retrieves an item for the key like Get.
+// Get treats values which can't be decoded as missing,
+// Fetch returns the error, e.g. ErrAuthentication for an
+// encrypted value which was modified.
+func (B *BigMap) Fetch(key []byte) (val []byte, ok bool, err error) {
+	h := B.hasher.Hash(key)
+	B.read(h, func(shard *Shard) bool {
+		val, ok, err = shard.fetch(h)
+		return ok
+	})
+	return val, ok, err
+}
+
+// EntrySize returns the maximum size of the items in this map.
+func (B *BigMap) EntrySize() uint64 {
+	return B.entrysize
+}
+
+// PutString puts an item into the map like Put
+// without converting the key to a byte-slice.
+func (B *BigMap) PutString(key string, val []byte) error {
+	return B.put(B.hasher.HashString(key), B.origin(key), val, 0)
+}
+
+// GetString retrieves an item for the key like Get
+// without converting the key to a byte-slice.
+func (B *BigMap) GetString(key string) ([]byte, bool) {
+	h := B.hasher.HashString(key)
+	val, ok := B.get(h)
+	if ok && B.config.RefreshLoader != nil && B.refreshDue(h) {
+		B.refresh(h, []byte(key))
+	}
+	return val, ok
+}
+
+// GetIntoString retrieves an item for the key like GetInto
+// without converting the key to a byte-slice.
+// A size larger than the buffer means the value was truncated.
+func (B *BigMap) GetIntoString(key string, buffer []byte) (uint64, bool) {
+	h := B.hasher.HashString(key)
+	size, ok := B.getInto(h, buffer)
+	if ok && B.config.RefreshLoader != nil && B.refreshDue(h) {
+		B.refresh(h, []byte(key))
+	}
+	return size, ok
+}
+
+// DeleteString removes an item from the map like Delete
+// without converting the key to a byte-slice.
+func (B *BigMap) DeleteString(key string) bool {
+	return B.delete(B.hasher.HashString(key), B.origin(key))
+}
+
+// PutUint64 puts an item into the map.
+// The key isn't hashed but scrambled with Mix64
+// which makes integer keys cheaper than byte keys.
+// The configured Hasher is not used for integer keys.
+//
+// Integer keys share the key space with the hashes of
+// byte and string keys.
+func (B *BigMap) PutUint64(key uint64, val []byte) error {
+	return B.put(Mix64(key), nil, val, 0)
+}
+
+// GetUint64 retrieves an item for the integer key.
+// See BigMap.PutUint64
+func (B *BigMap) GetUint64(key uint64) ([]byte, bool) {
+	return B.get(Mix64(key))
+}
+
+// GetIntoUint64 retrieves an item for the integer key
+// and writes it into buffer like GetInto,
+// a size larger than the buffer means the value was truncated.
+// See BigMap.PutUint64
+func (B *BigMap) GetIntoUint64(key uint64, buffer []byte) (uint64, bool) {
+	return B.getInto(Mix64(key), buffer)
+}
+
+// DeleteUint64 removes the item for the integer key.
+// See BigMap.PutUint64
+func (B *BigMap) DeleteUint64(key uint64) bool {
+	return B.delete(Mix64(key), nil)
+}
+
+// PutHash puts an item into the map under the hash of its key
+// as computed by the Hasher of the map.
+// A ttl smaller or equal to 0 never expires.
+func (B *BigMap) PutHash(hash uint64, val []byte, ttl time.Duration) error {
+	return B.put(hash, nil, val, deadlineOf(ttl))
+}
+
+// GetHash retrieves an item for the hash of its key.
+// See BigMap.PutHash
+func (B *BigMap) GetHash(hash uint64) ([]byte, bool) {
+	return B.get(hash)
+}
+
+// DeleteHash removes the item for the hash of its key.
+// See BigMap.PutHash
+func (B *BigMap) DeleteHash(hash uint64) bool {
+	return B.delete(hash, nil)
+}
+
+// Snapshot calls fn for every item in the map with the hash of its
+// key and its remaining time to live until fn returns false.
+//
+// The items of a shard are copied while the shard is locked and fn is
+// called afterwards, so slow callbacks neither block writers of the
+// map nor Reshard, Clear and Reset. The snapshot is restarted if the
+// map is resharded meanwhile, in which case items can be passed
+// more than once.
+func (B *BigMap) Snapshot(fn func(hash uint64, val []byte, ttl time.Duration) bool) {
+	B.SnapshotItems(func(item SnapshotItem) bool {
+		return fn(item.Hash, item.Value, item.TTL)
+	})
+}
+
+// SnapshotItem is an item of the map passed on by SnapshotItems.
+// The Value must not be retained after the callback returns.
+type SnapshotItem struct {
+	Hash  uint64
+	Value []byte
+	// TTL is the remaining time to live, 0 if the item doesn't expire.
+	TTL time.Duration
+	// Structure is set for hashes, lists and sets.
+	// Their value is restored by BigMap.PutStructureHash.
+	Structure bool
+}
+
+// SnapshotItems calls fn for every item in the map like Snapshot.
+func (B *BigMap) SnapshotItems(fn func(item SnapshotItem) bool) {
+	var items []snapshotSpan
+	var buffer []byte
+	var table *shardTable
+	for i := 0; ; i++ {
+		// a reshard between two shards replaces the table,
+		// the snapshot restarts with the new shards then
+		B.resharder.Lock()
+		if current := B.loadTable(); current != table {
+			table, i = current, 0
+		}
+		if i == len(table.shards) {
+			B.resharder.Unlock()
+			return
+		}
+		items, buffer = items[:0], buffer[:0]
+		table.shards[i].rangeSlots(func(key uint64, val []byte, deadline int64, flags uint64) bool {
+			start := len(buffer)
+			buffer = append(buffer, val...)
+			items = append(items, snapshotSpan{key, start, len(buffer), deadline, flags&structureFlag != 0})
+			return true
+		})
+		B.resharder.Unlock()
+		for _, item := range items {
+			if !fn(SnapshotItem{item.key, buffer[item.start:item.end], ttlOf(item.deadline), item.structure}) {
+				return
+			}
+		}
+	}
+}
+
+// snapshotSpan is an item of a shard copied into the buffer of a snapshot.
+type snapshotSpan struct {
+	key        uint64
+	start, end int
+	deadline   int64
+	structure  bool
+}
+
+// Config returns the configuration of the map
+// with the defaults filled in.
+func (B *BigMap) Config() Config {
+	return B.config
 }
 
 // SelectShard return the corresponding shard to the given key.
+// While the map is resharded the returned shard might not
+// hold the item yet.
 func (B *BigMap) SelectShard(key []byte) (*Shard, uint64) {
-	h := FNV64(key)
-	return B.shards[h%uint64(len(B.shards))], h
+	h := B.hasher.Hash(key)
+	return B.loadTable().shardOf(h), h
 }
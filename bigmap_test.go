@@ -348,7 +348,7 @@ func PopulateMapParallel(b *testing.B, bm *BigMap) [][][]byte {
 		go func(num int, worker string) {
 			for i := 0; i < n; i++ {
 				keys[num][i] = GenSafeKey(worker, i)
-				bm.Put(keys[num][i], make([]byte, bm.shards[0].entrysize))
+				bm.Put(keys[num][i], make([]byte, bm.entrysize))
 			}
 			wg.Done()
 		}(i, w)
@@ -407,13 +407,195 @@ func TestBigMap_New_config(t *testing.T) {
 		Capacity:          128,
 		ExpirationFactory: Expires(time.Hour, ExpirationPolicyPassive),
 	})
-	if len(bigmap.shards) != 3 {
-		t.Fatalf("Failed to configure shards got %d, want %d", len(bigmap.shards), 3)
+	if len(bigmap.loadTable().shards) != 3 {
+		t.Fatalf("Failed to configure shards got %d, want %d", len(bigmap.loadTable().shards), 3)
 	}
-	if len(bigmap.shards[0].array) != 128 {
-		t.Fatalf("Failed to configure capacity got %d, want %d", len(bigmap.shards[0].array), 128)
+	if len(bigmap.loadTable().shards[0].array) != 128 {
+		t.Fatalf("Failed to configure capacity got %d, want %d", len(bigmap.loadTable().shards[0].array), 128)
 	}
-	if bigmap.shards[0].expSrv == nil {
+	if bigmap.loadTable().shards[0].expSrv == nil {
 		t.Fatalf("Failed to configure expiration got nil, want !nil")
 	}
 }
+
+func TestBigMap_Clear(t *testing.T) {
+	bigmap := New(100)
+	keys := PopulateMap(1024, &bigmap)
+	bigmap.Clear()
+	for _, key := range keys {
+		if _, ok := bigmap.Get(key); ok {
+			t.Fatalf("Get after Clear got ok, want !ok")
+		}
+	}
+	bigmap.Reset()
+	for _, s := range bigmap.loadTable().shards {
+		if len(s.array) != int(DefaultCapacity) {
+			t.Fatalf("Reset capacity got %d, want %d", len(s.array), DefaultCapacity)
+		}
+	}
+}
+
+func BenchmarkBigMap_PutString(b *testing.B) {
+	bigmap := New(100)
+	val := GenVal()
+	keys := make([]string, b.N)
+	for i := 0; i < b.N; i++ {
+		keys[i] = string(GenKey(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bigmap.PutString(keys[i], val)
+	}
+	b.SetBytes(1)
+}
+
+func BenchmarkBigMap_PutUint64(b *testing.B) {
+	bigmap := New(100)
+	val := GenVal()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bigmap.PutUint64(uint64(i), val)
+	}
+	b.SetBytes(1)
+}
+
+func TestBigMap_String(t *testing.T) {
+	bigmap := New(100)
+	keys := PopulateMap(1024, &bigmap)
+	buff := make([]byte, 100)
+	for _, key := range keys {
+		if _, ok := bigmap.GetString(string(key)); !ok {
+			t.Fatalf("GetString(%q) got !ok, want ok", key)
+		}
+	}
+	bigmap.PutString("string", []byte("value"))
+	val, ok := bigmap.Get([]byte("string"))
+	if !ok || string(val) != "value" {
+		t.Fatalf("Get after PutString got %q,%v, want %q,true", val, ok, "value")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		bigmap.GetIntoString("string", buff)
+	})
+	if allocs != 0 {
+		t.Fatalf("GetIntoString allocated %v times, want 0", allocs)
+	}
+	if !bigmap.DeleteString("string") {
+		t.Fatalf("delete expected")
+	}
+}
+
+func TestBigMap_Uint64(t *testing.T) {
+	bigmap := New(8)
+	for i := uint64(0); i < 4096; i++ {
+		bigmap.PutUint64(i, []byte(strconv.FormatUint(i, 10)))
+	}
+	for i := uint64(0); i < 4096; i++ {
+		val, ok := bigmap.GetUint64(i)
+		if !ok || string(val) != strconv.FormatUint(i, 10) {
+			t.Fatalf("GetUint64(%d) got %q,%v, want %q,true", i, val, ok, strconv.FormatUint(i, 10))
+		}
+	}
+	for i := uint64(0); i < 4096; i++ {
+		if !bigmap.DeleteUint64(i) {
+			t.Fatalf("delete expected")
+		}
+	}
+}
+
+func TestBigMap_Range(t *testing.T) {
+	bigmap := New(100, Config{Shards: 4})
+	PopulateMap(1024, &bigmap)
+	if bigmap.Len() != 1024 {
+		t.Fatalf("Len got %d, want %d", bigmap.Len(), 1024)
+	}
+	seen := 0
+	bigmap.Range(func(key uint64, val []byte) bool {
+		seen++
+		return true
+	})
+	if seen != 1024 {
+		t.Fatalf("Range got %d items, want %d", seen, 1024)
+	}
+	seen = 0
+	cursor := bigmap.Scan(0, func(key uint64, val []byte) { seen++ })
+	for cursor != 0 {
+		cursor = bigmap.Scan(cursor, func(key uint64, val []byte) { seen++ })
+	}
+	if seen != 1024 {
+		t.Fatalf("Scan got %d items, want %d", seen, 1024)
+	}
+}
+
+func TestBigMap_Snapshot(t *testing.T) {
+	bigmap := New(16, Config{Shards: 4})
+	for i := uint64(0); i < 100; i++ {
+		ttl := time.Duration(0)
+		if i%2 == 0 {
+			ttl = time.Hour
+		}
+		if err := bigmap.PutHash(i, []byte(fmt.Sprint(i)), ttl); err != nil {
+			t.Fatalf("PutHash: %v", err)
+		}
+	}
+	bigmap.DeleteHash(99)
+	if val, ok := bigmap.GetHash(42); !ok || string(val) != "42" {
+		t.Fatalf("GetHash got %q, %v", val, ok)
+	}
+	seen := 0
+	bigmap.Snapshot(func(hash uint64, val []byte, ttl time.Duration) bool {
+		seen++
+		if string(val) != fmt.Sprint(hash) {
+			t.Fatalf("Snapshot got %q for %d", val, hash)
+		}
+		if (hash%2 == 0) != (ttl > 0) {
+			t.Fatalf("Snapshot got ttl %v for %d", ttl, hash)
+		}
+		bigmap.PutHash(hash+1000, val, 0)
+		return true
+	})
+	if seen != 99 {
+		t.Fatalf("Snapshot got %d items, want %d", seen, 99)
+	}
+}
+
+func TestBigMap_Snapshot_reshard(t *testing.T) {
+	bigmap := New(16, Config{Shards: 4})
+	for i := uint64(0); i < 100; i++ {
+		bigmap.PutHash(i, []byte(fmt.Sprint(i)), 0)
+	}
+	done := make(chan struct{})
+	seen := map[uint64]bool{}
+	go func() {
+		defer close(done)
+		bigmap.Snapshot(func(hash uint64, val []byte, ttl time.Duration) bool {
+			if len(seen) == 0 {
+				bigmap.Reshard(8)
+			}
+			seen[hash] = true
+			return true
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Snapshot blocked Reshard")
+	}
+	if len(seen) != 100 {
+		t.Fatalf("Snapshot got %d items, want %d", len(seen), 100)
+	}
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		bigmap.Snapshot(func(hash uint64, val []byte, ttl time.Duration) bool {
+			bigmap.Clear()
+			return true
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Snapshot blocked Clear")
+	}
+}
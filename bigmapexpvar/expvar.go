@@ -0,0 +1,62 @@
+// Package bigmapexpvar publishes the metrics of a BigMap
+// through the expvar package, e.g. at /debug/vars.
+package bigmapexpvar
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+
+	"github.com/worldOneo/bigmap"
+)
+
+// Vars is the value published for a map.
+type Vars struct {
+	// Shards is the amount of shards of the map.
+	Shards int `json:"shards"`
+	// Total is the sum of the metrics of all shards.
+	Total bigmap.Metrics `json:"total"`
+	// PerShard are the metrics of every shard.
+	PerShard []bigmap.Metrics `json:"per_shard"`
+}
+
+// publishLock serializes the checks and publishes of Publish.
+var publishLock sync.Mutex
+
+// Publish publishes the metrics of the map under the name.
+// The metrics are collected lazily whenever the variable is read.
+// An error is returned if the name is already published.
+func Publish(name string, bm *bigmap.BigMap) (err error) {
+	publishLock.Lock()
+	defer publishLock.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("bigmapexpvar: %q is already published", name)
+	}
+	// names published concurrently outside of this package
+	// make expvar.Publish panic.
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("bigmapexpvar: %q is already published", name)
+		}
+	}()
+	expvar.Publish(name, Func(bm))
+	return nil
+}
+
+// Func returns an expvar.Func collecting the metrics of the map
+// to be published manually.
+func Func(bm *bigmap.BigMap) expvar.Func {
+	return func() interface{} {
+		return Collect(bm)
+	}
+}
+
+// Collect returns the current Vars of the map.
+func Collect(bm *bigmap.BigMap) Vars {
+	shards := bm.Metrics()
+	vars := Vars{Shards: len(shards), PerShard: shards}
+	for _, m := range shards {
+		vars.Total.Add(m)
+	}
+	return vars
+}
@@ -0,0 +1,48 @@
+package bigmapexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/worldOneo/bigmap"
+)
+
+func TestPublish(t *testing.T) {
+	bm := bigmap.New(8, bigmap.Config{Shards: 2})
+	if err := Publish("bigmap_test", &bm); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := Publish("bigmap_test", &bm); err == nil {
+		t.Fatalf("expected an error publishing twice")
+	}
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- Publish("bigmap_concurrent", &bm) }()
+	}
+	published := 0
+	for i := 0; i < cap(errs); i++ {
+		if <-errs == nil {
+			published++
+		}
+	}
+	if published != 1 {
+		t.Fatalf("expected a single concurrent publish to succeed, got %d", published)
+	}
+
+	// the variable is updated on read
+	bm.PutUint64(1, []byte("value"))
+	bm.GetUint64(1)
+	bm.GetUint64(2)
+	var vars Vars
+	if err := json.Unmarshal([]byte(expvar.Get("bigmap_test").String()), &vars); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	total := vars.Total
+	if vars.Shards != 2 || len(vars.PerShard) != 2 || total.Items != 1 || total.Hits != 1 || total.Misses != 1 {
+		t.Fatalf("unexpected vars %+v", vars)
+	}
+	if total.UsedBytes != 8+bigmap.HeaderBytes || total.Bytes != 2*bigmap.DefaultCapacity {
+		t.Fatalf("unexpected bytes %+v", total)
+	}
+}
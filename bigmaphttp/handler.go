@@ -0,0 +1,339 @@
+// Package bigmaphttp exposes a BigMap over HTTP
+// for admin tooling and simple integrations.
+//
+// The handler serves the following endpoints:
+//
+//	GET    /keys/{key}    returns the value of the key
+//	HEAD   /keys/{key}    reports if the key is contained
+//	PUT    /keys/{key}    stores the request body as value
+//	DELETE /keys/{key}    removes the key
+//	POST   /batch/get     returns the values of multiple keys
+//	POST   /batch/put     stores multiple items
+//	POST   /batch/delete  removes multiple keys
+//	GET    /stats         returns statistics of the map
+//
+// Keys are path-unescaped, e.g. /keys/a%2Fb is the key "a/b", and
+// hashed the same way as by BigMap.Put, so the handler can be
+// mounted next to an application using the map.
+// The remaining time to live of an item is reported and accepted
+// in the TTLHeader as non-negative Go duration, e.g. "1m30s".
+package bigmaphttp
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+const (
+	// TTLHeader carries the time to live of an item.
+	TTLHeader = "X-Bigmap-Ttl"
+	// DefaultMaxKeySize is used if Config.MaxKeySize is 0.
+	DefaultMaxKeySize = 1024
+	// DefaultMaxBatch is used if Config.MaxBatch is 0.
+	DefaultMaxBatch = 1000
+)
+
+// Config configures a Handler.
+type Config struct {
+	// MaxKeySize is the maximum size of a key in bytes.
+	MaxKeySize int
+	// MaxValueSize is the maximum size of a value in bytes.
+	// It defaults to the entrysize of the map.
+	MaxValueSize int64
+	// MaxBatch is the maximum amount of keys of a batch request.
+	MaxBatch int
+	// ReadOnly rejects all modifying requests.
+	ReadOnly bool
+}
+
+// Handler serves a BigMap over HTTP.
+type Handler struct {
+	bigmap *bigmap.BigMap
+	config Config
+	mux    *http.ServeMux
+}
+
+// Item is an entry of batch requests and responses.
+type Item struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	// TTL is a Go duration, empty if the item doesn't expire.
+	TTL   string `json:"ttl,omitempty"`
+	Found bool   `json:"found,omitempty"`
+}
+
+// BatchRequest is the body of batch requests.
+// Keys is used by get and delete, Items by put.
+type BatchRequest struct {
+	Keys  []string `json:"keys,omitempty"`
+	Items []Item   `json:"items,omitempty"`
+}
+
+// BatchResponse is the body of batch responses.
+type BatchResponse struct {
+	Items   []Item `json:"items,omitempty"`
+	Stored  int    `json:"stored,omitempty"`
+	Deleted int    `json:"deleted,omitempty"`
+}
+
+// Stats is the body of the /stats endpoint.
+type Stats struct {
+	Items     int    `json:"items"`
+	Shards    int    `json:"shards"`
+	EntrySize uint64 `json:"entry_size"`
+	ReadOnly  bool   `json:"read_only"`
+}
+
+var errTooLarge = errors.New("request body too large")
+
+// NewHandler creates a new Handler serving the bigmap.
+func NewHandler(bm *bigmap.BigMap, config ...Config) *Handler {
+	H := &Handler{bigmap: bm, mux: http.NewServeMux()}
+	if len(config) > 0 {
+		H.config = config[0]
+	}
+	if H.config.MaxKeySize <= 0 {
+		H.config.MaxKeySize = DefaultMaxKeySize
+	}
+	if H.config.MaxValueSize <= 0 {
+		H.config.MaxValueSize = int64(bm.EntrySize())
+	}
+	if H.config.MaxBatch <= 0 {
+		H.config.MaxBatch = DefaultMaxBatch
+	}
+	H.mux.HandleFunc("/keys/", H.serveKey)
+	H.mux.HandleFunc("/batch/get", H.batch(false, H.batchGet))
+	H.mux.HandleFunc("/batch/put", H.batch(true, H.batchPut))
+	H.mux.HandleFunc("/batch/delete", H.batch(true, H.batchDelete))
+	H.mux.HandleFunc("/stats", H.serveStats)
+	return H
+}
+
+// ServeHTTP implements http.Handler.
+func (H *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	H.mux.ServeHTTP(w, r)
+}
+
+func (H *Handler) serveKey(w http.ResponseWriter, r *http.Request) {
+	// the escaped path keeps keys containing an encoded slash intact
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/keys/"))
+	if err != nil || key == "" || len(key) > H.config.MaxKeySize {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		val, ok := H.bigmap.Get([]byte(key))
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if ttl, ok := H.bigmap.TTL([]byte(key)); ok && ttl > 0 {
+			w.Header().Set(TTLHeader, ttl.String())
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(val)))
+		if r.Method == http.MethodGet {
+			w.Write(val)
+		}
+	case http.MethodPut:
+		if !H.writable(w) {
+			return
+		}
+		ttl, err := parseTTL(r.Header.Get(TTLHeader))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		val, err := H.readBody(r, H.config.MaxValueSize)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := H.bigmap.PutTTL([]byte(key), val, ttl); err != nil {
+			http.Error(w, err.Error(), putStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if !H.writable(w) {
+			return
+		}
+		if !H.bigmap.Delete([]byte(key)) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// batch wraps a batch endpoint which decodes the request
+// and encodes the response as JSON.
+func (H *Handler) batch(modifies bool, fn func(BatchRequest) (BatchResponse, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if modifies && !H.writable(w) {
+			return
+		}
+		limit := int64(H.config.MaxBatch) * (int64(H.config.MaxKeySize) + H.config.MaxValueSize*2 + 64)
+		body, err := H.readBody(r, limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		var req BatchRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(req.Keys) > H.config.MaxBatch || len(req.Items) > H.config.MaxBatch {
+			http.Error(w, "too many keys", http.StatusRequestEntityTooLarge)
+			return
+		}
+		res, status, err := fn(req)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, res)
+	}
+}
+
+func (H *Handler) batchGet(req BatchRequest) (BatchResponse, int, error) {
+	res := BatchResponse{Items: make([]Item, len(req.Keys))}
+	for i, key := range req.Keys {
+		item := Item{Key: key}
+		item.Value, item.Found = H.bigmap.Get([]byte(key))
+		if ttl, ok := H.bigmap.TTL([]byte(key)); ok && ttl > 0 {
+			item.TTL = ttl.String()
+		}
+		res.Items[i] = item
+	}
+	return res, http.StatusOK, nil
+}
+
+// batchPut validates all items before storing any of them.
+func (H *Handler) batchPut(req BatchRequest) (BatchResponse, int, error) {
+	ttls := make([]time.Duration, len(req.Items))
+	for i, item := range req.Items {
+		if item.Key == "" || len(item.Key) > H.config.MaxKeySize {
+			return BatchResponse{}, http.StatusBadRequest, errors.New("invalid key")
+		}
+		if int64(len(item.Value)) > H.config.MaxValueSize {
+			return BatchResponse{}, http.StatusRequestEntityTooLarge, errTooLarge
+		}
+		ttl, err := parseTTL(item.TTL)
+		if err != nil {
+			return BatchResponse{}, http.StatusBadRequest, err
+		}
+		ttls[i] = ttl
+	}
+	var res BatchResponse
+	for i, item := range req.Items {
+		if err := H.bigmap.PutTTL([]byte(item.Key), item.Value, ttls[i]); err != nil {
+			return res, putStatus(err), err
+		}
+		res.Stored++
+	}
+	return res, http.StatusOK, nil
+}
+
+func (H *Handler) batchDelete(req BatchRequest) (BatchResponse, int, error) {
+	var res BatchResponse
+	for _, key := range req.Keys {
+		if H.bigmap.Delete([]byte(key)) {
+			res.Deleted++
+		}
+	}
+	return res, http.StatusOK, nil
+}
+
+func (H *Handler) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, Stats{
+		Items:     H.bigmap.Len(),
+		Shards:    H.bigmap.ShardCount(),
+		EntrySize: H.bigmap.EntrySize(),
+		ReadOnly:  H.config.ReadOnly,
+	})
+}
+
+func (H *Handler) writable(w http.ResponseWriter) bool {
+	if H.config.ReadOnly {
+		http.Error(w, "read-only", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// readBody reads the request body up to limit bytes.
+func (H *Handler) readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.ContentLength > limit {
+		return nil, errTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errTooLarge
+	}
+	return body, nil
+}
+
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, errors.New("invalid ttl: " + s)
+	}
+	return ttl, nil
+}
+
+// putStatus returns the status of a failed put.
+// Only values which don't fit are the client's fault,
+// e.g. a failing encryption is an error of the server.
+func putStatus(err error) int {
+	switch {
+	case errors.Is(err, bigmap.ErrValueSize):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, bigmap.ErrNamespaceLimit):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if err == errTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,171 @@
+package bigmaphttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+func request(t *testing.T, handler http.Handler, method, path string, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandler_Keys(t *testing.T) {
+	bm := bigmap.New(64, bigmap.Config{Shards: 4})
+	handler := NewHandler(&bm)
+
+	if w := request(t, handler, http.MethodPut, "/keys/hello", "world"); w.Code != http.StatusNoContent {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+	w := request(t, handler, http.MethodGet, "/keys/hello", "")
+	if w.Code != http.StatusOK || w.Body.String() != "world" || w.Header().Get(TTLHeader) != "" {
+		t.Fatalf("get: %d %q %v", w.Code, w.Body, w.Header())
+	}
+	if val, _ := bm.Get([]byte("hello")); string(val) != "world" {
+		t.Fatalf("map contains %q", val)
+	}
+	w = request(t, handler, http.MethodHead, "/keys/hello", "")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "5" {
+		t.Fatalf("head: %d %v", w.Code, w.Header())
+	}
+	if w := request(t, handler, http.MethodHead, "/keys/missing", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("head missing: %d", w.Code)
+	}
+
+	request(t, handler, http.MethodPut, "/keys/ttl", "value", TTLHeader, "1h")
+	w = request(t, handler, http.MethodGet, "/keys/ttl", "")
+	ttl, err := time.ParseDuration(w.Header().Get(TTLHeader))
+	if err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("ttl: %q %v", w.Header().Get(TTLHeader), err)
+	}
+	if w := request(t, handler, http.MethodPut, "/keys/ttl", "value", TTLHeader, "soon"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid ttl: %d", w.Code)
+	}
+	if w := request(t, handler, http.MethodPut, "/keys/ttl", "value", TTLHeader, "-1m"); w.Code != http.StatusBadRequest {
+		t.Fatalf("negative ttl: %d", w.Code)
+	}
+
+	if w := request(t, handler, http.MethodPut, "/keys/a%2Fb%25", "escaped"); w.Code != http.StatusNoContent {
+		t.Fatalf("put escaped: %d %s", w.Code, w.Body)
+	}
+	if val, _ := bm.Get([]byte("a/b%")); string(val) != "escaped" {
+		t.Fatalf("map contains %q for the escaped key", val)
+	}
+	if w := request(t, handler, http.MethodGet, "/keys/a%2Fb%25", ""); w.Body.String() != "escaped" {
+		t.Fatalf("get escaped: %d %q", w.Code, w.Body)
+	}
+
+	if w := request(t, handler, http.MethodPut, "/keys/large", strings.Repeat("x", 65)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large: %d", w.Code)
+	}
+	if w := request(t, handler, http.MethodDelete, "/keys/hello", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	if w := request(t, handler, http.MethodDelete, "/keys/hello", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("delete missing: %d", w.Code)
+	}
+	if w := request(t, handler, http.MethodPost, "/keys/hello", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("post: %d", w.Code)
+	}
+}
+
+func TestHandler_Batch(t *testing.T) {
+	bm := bigmap.New(64, bigmap.Config{Shards: 4})
+	handler := NewHandler(&bm, Config{MaxBatch: 3})
+
+	put, _ := json.Marshal(BatchRequest{Items: []Item{
+		{Key: "a", Value: []byte("1")},
+		{Key: "b", Value: []byte("2"), TTL: "1m"},
+	}})
+	w := request(t, handler, http.MethodPost, "/batch/put", string(put))
+	var res BatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Stored != 2 {
+		t.Fatalf("put: %d %s", w.Code, w.Body)
+	}
+
+	w = request(t, handler, http.MethodPost, "/batch/get", `{"keys":["a","b","c"]}`)
+	res = BatchResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res.Items) != 3 {
+		t.Fatalf("get: %d %s", w.Code, w.Body)
+	}
+	a, b, c := res.Items[0], res.Items[1], res.Items[2]
+	if !a.Found || !bytes.Equal(a.Value, []byte("1")) || a.TTL != "" || !b.Found || b.TTL == "" || c.Found {
+		t.Fatalf("get: %+v", res.Items)
+	}
+
+	if w := request(t, handler, http.MethodPost, "/batch/get", `{"keys":["a","b","c","d"]}`); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("too many keys: %d", w.Code)
+	}
+	w = request(t, handler, http.MethodPost, "/batch/delete", `{"keys":["a","c"]}`)
+	res = BatchResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Deleted != 1 {
+		t.Fatalf("delete: %d %s", w.Code, w.Body)
+	}
+	if bm.Len() != 1 {
+		t.Fatalf("expected 1 item, got %d", bm.Len())
+	}
+}
+
+func TestHandler_ReadOnly(t *testing.T) {
+	bm := bigmap.New(64)
+	bm.Put([]byte("key"), []byte("value"))
+	handler := NewHandler(&bm, Config{ReadOnly: true})
+
+	if w := request(t, handler, http.MethodPut, "/keys/key", "other"); w.Code != http.StatusForbidden {
+		t.Fatalf("put: %d", w.Code)
+	}
+	if w := request(t, handler, http.MethodDelete, "/keys/key", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	if w := request(t, handler, http.MethodPost, "/batch/delete", `{"keys":["key"]}`); w.Code != http.StatusForbidden {
+		t.Fatalf("batch delete: %d", w.Code)
+	}
+	if w := request(t, handler, http.MethodGet, "/keys/key", ""); w.Body.String() != "value" {
+		t.Fatalf("get: %d %s", w.Code, w.Body)
+	}
+
+	w := request(t, handler, http.MethodGet, "/stats", "")
+	var stats Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Items != 1 || stats.Shards != bigmap.DefaultShards || !stats.ReadOnly {
+		t.Fatalf("stats: %+v", stats)
+	}
+}
+
+func TestHandler_PutStatus(t *testing.T) {
+	bm := bigmap.New(64, bigmap.Config{Shards: 4})
+	handler := NewHandler(&bm, Config{MaxValueSize: 128})
+	if w := request(t, handler, http.MethodPut, "/keys/large", strings.Repeat("x", 65)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("value larger than the entrysize: %d", w.Code)
+	}
+	for err, want := range map[error]int{
+		fmt.Errorf("namespace put: %w", bigmap.ErrNamespaceLimit):   http.StatusInsufficientStorage,
+		fmt.Errorf("keyring seal: %w", errors.New("cipher failed")): http.StatusInternalServerError,
+	} {
+		if status := putStatus(err); status != want {
+			t.Fatalf("putStatus(%v) = %d, want %d", err, status, want)
+		}
+	}
+}
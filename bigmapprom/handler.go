@@ -0,0 +1,176 @@
+// Package bigmapprom exposes the metrics of BigMaps
+// in the Prometheus text exposition format.
+package bigmapprom
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/worldOneo/bigmap"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(m *bigmap.Metrics) uint64
+}
+
+var metrics = []metric{
+	{"bigmap_hits_total", "Lookups which found an item.", "counter", func(m *bigmap.Metrics) uint64 { return m.Hits }},
+	{"bigmap_misses_total", "Lookups which didn't find an item.", "counter", func(m *bigmap.Metrics) uint64 { return m.Misses }},
+	{"bigmap_puts_total", "Stored items.", "counter", func(m *bigmap.Metrics) uint64 { return m.Puts }},
+	{"bigmap_deletes_total", "Deleted items.", "counter", func(m *bigmap.Metrics) uint64 { return m.Deletes }},
+	{"bigmap_evictions_total", "Items removed by the expiration service.", "counter", func(m *bigmap.Metrics) uint64 { return m.Evictions }},
+	{"bigmap_expirations_total", "Items removed because their ttl passed.", "counter", func(m *bigmap.Metrics) uint64 { return m.Expirations }},
+	{"bigmap_sweeps_total", "Sweeps of the sweep expiration service.", "counter", func(m *bigmap.Metrics) uint64 { return m.Sweeps }},
+	{"bigmap_lock_spin_retries_total", "Times readers waited for a writer.", "counter", func(m *bigmap.Metrics) uint64 { return m.SpinRetries }},
+	{"bigmap_verify_retries_total", "Optimistic reads which had to be repeated.", "counter", func(m *bigmap.Metrics) uint64 { return m.VerifyRetries }},
+	{"bigmap_grows_total", "Times the byte-array of a shard was grown.", "counter", func(m *bigmap.Metrics) uint64 { return m.Grows }},
+	{"bigmap_dropped_events_total", "Events dropped because watchers were too slow.", "counter", func(m *bigmap.Metrics) uint64 { return m.DroppedEvents }},
+	{"bigmap_spills_total", "Evicted items written to the disk tier.", "counter", func(m *bigmap.Metrics) uint64 { return m.Spills }},
+	{"bigmap_promotions_total", "Items read back from the disk tier.", "counter", func(m *bigmap.Metrics) uint64 { return m.Promotions }},
+	{"bigmap_compactions_total", "Compactions of the disk tier.", "counter", func(m *bigmap.Metrics) uint64 { return m.Compactions }},
+	{"bigmap_disk_errors_total", "Failed reads and writes of the disk tier.", "counter", func(m *bigmap.Metrics) uint64 { return m.DiskErrors }},
+	{"bigmap_compressed_total", "Values stored compressed.", "counter", func(m *bigmap.Metrics) uint64 { return m.Compressed }},
+	{"bigmap_uncompressed_bytes_total", "Size of the compressed values before compression.", "counter", func(m *bigmap.Metrics) uint64 { return m.UncompressedBytes }},
+	{"bigmap_compressed_bytes_total", "Size of the compressed values after compression.", "counter", func(m *bigmap.Metrics) uint64 { return m.CompressedBytes }},
+	{"bigmap_decode_errors_total", "Values which couldn't be decrypted or decompressed.", "counter", func(m *bigmap.Metrics) uint64 { return m.DecodeErrors }},
+	{"bigmap_corruptions_total", "Items which failed their checksum or length check.", "counter", func(m *bigmap.Metrics) uint64 { return m.Corruptions }},
+	{"bigmap_items", "Items in the shard.", "gauge", func(m *bigmap.Metrics) uint64 { return m.Items }},
+	{"bigmap_bytes", "Size of the byte-array of the shard.", "gauge", func(m *bigmap.Metrics) uint64 { return m.Bytes }},
+	{"bigmap_used_bytes", "Part of the byte-array taken by slots.", "gauge", func(m *bigmap.Metrics) uint64 { return m.UsedBytes }},
+	{"bigmap_free_slots", "Slots queued for reuse.", "gauge", func(m *bigmap.Metrics) uint64 { return m.FreeSlots }},
+	{"bigmap_disk_items", "Items in the disk tier of the shard.", "gauge", func(m *bigmap.Metrics) uint64 { return m.DiskItems }},
+	{"bigmap_disk_bytes", "Size of the log of the disk tier of the shard.", "gauge", func(m *bigmap.Metrics) uint64 { return m.DiskBytes }},
+}
+
+// Handler renders the metrics of the registered maps.
+// Every sample is labeled with the name of the map and the shard.
+type Handler struct {
+	lock  sync.Mutex
+	names []string
+	maps  []*bigmap.BigMap
+}
+
+// NewHandler creates a new Handler without any maps.
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// Register adds the map under the name.
+// Registering a name twice replaces the previous map.
+func (H *Handler) Register(name string, bm *bigmap.BigMap) {
+	H.lock.Lock()
+	defer H.lock.Unlock()
+	for i, registered := range H.names {
+		if registered == name {
+			H.maps[i] = bm
+			return
+		}
+	}
+	H.names = append(H.names, name)
+	H.maps = append(H.maps, bm)
+}
+
+// Unregister removes the map of the name.
+func (H *Handler) Unregister(name string) {
+	H.lock.Lock()
+	defer H.lock.Unlock()
+	for i, registered := range H.names {
+		if registered == name {
+			H.names = append(H.names[:i], H.names[i+1:]...)
+			H.maps = append(H.maps[:i], H.maps[i+1:]...)
+			return
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (H *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	H.WriteTo(w)
+}
+
+// WriteTo writes the metrics of all registered maps to w.
+func (H *Handler) WriteTo(w io.Writer) (int64, error) {
+	H.lock.Lock()
+	names := append([]string(nil), H.names...)
+	maps := append([]*bigmap.BigMap(nil), H.maps...)
+	H.lock.Unlock()
+
+	shards := make([][]bigmap.Metrics, len(maps))
+	for i, bm := range maps {
+		shards[i] = bm.Metrics()
+	}
+
+	writer := &countingWriter{writer: bufio.NewWriter(w)}
+	var line []byte
+	for _, m := range metrics {
+		writer.WriteString("# HELP " + m.name + " " + m.help + "\n")
+		writer.WriteString("# TYPE " + m.name + " " + m.kind + "\n")
+		for i, name := range names {
+			for shard := range shards[i] {
+				line = append(line[:0], m.name...)
+				line = append(line, `{map="`...)
+				line = appendEscaped(line, name)
+				line = append(line, `",shard="`...)
+				line = strconv.AppendInt(line, int64(shard), 10)
+				line = append(line, `"} `...)
+				line = strconv.AppendUint(line, m.value(&shards[i][shard]), 10)
+				line = append(line, '\n')
+				writer.Write(line)
+			}
+		}
+	}
+	writer.WriteString("# HELP bigmap_shards Shards of the map.\n")
+	writer.WriteString("# TYPE bigmap_shards gauge\n")
+	for i, name := range names {
+		line = append(line[:0], `bigmap_shards{map="`...)
+		line = appendEscaped(line, name)
+		line = append(line, `"} `...)
+		line = strconv.AppendInt(line, int64(len(shards[i])), 10)
+		line = append(line, '\n')
+		writer.Write(line)
+	}
+	err := writer.writer.Flush()
+	if writer.err != nil {
+		err = writer.err
+	}
+	return writer.n, err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func appendEscaped(dst []byte, label string) []byte {
+	return append(dst, labelEscaper.Replace(label)...)
+}
+
+type countingWriter struct {
+	writer *bufio.Writer
+	n      int64
+	err    error
+}
+
+func (C *countingWriter) Write(b []byte) {
+	n, err := C.writer.Write(b)
+	C.n += int64(n)
+	if C.err == nil {
+		C.err = err
+	}
+}
+
+func (C *countingWriter) WriteString(s string) {
+	n, err := C.writer.WriteString(s)
+	C.n += int64(n)
+	if C.err == nil {
+		C.err = err
+	}
+}
@@ -0,0 +1,53 @@
+package bigmapprom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/worldOneo/bigmap"
+)
+
+func TestHandler(t *testing.T) {
+	bm := bigmap.New(8, bigmap.Config{Shards: 2})
+	bm.PutUint64(1, []byte("value"))
+	bm.GetUint64(1)
+	handler := NewHandler()
+	handler.Register(`cache "a"`, &bm)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("content type %q", w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE bigmap_hits_total counter\n",
+		"# TYPE bigmap_items gauge\n",
+		`bigmap_shards{map="cache \"a\""} 2` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("missing %q in\n%s", want, body)
+		}
+	}
+	var hits, items int
+	for _, line := range strings.Split(body, "\n") {
+		switch {
+		case strings.HasPrefix(line, "bigmap_hits_total{") && strings.HasSuffix(line, " 1"):
+			hits++
+		case strings.HasPrefix(line, "bigmap_items{") && strings.HasSuffix(line, " 1"):
+			items++
+		}
+	}
+	if hits != 1 || items != 1 {
+		t.Fatalf("expected one hit and one item\n%s", body)
+	}
+
+	handler.Unregister(`cache "a"`)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(w.Body.String(), "shard=") {
+		t.Fatalf("unregistered map is rendered\n%s", w.Body)
+	}
+}
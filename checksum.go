@@ -0,0 +1,123 @@
+package bigmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	// ChecksumBytes is the amount of bytes added to the header
+	// of each item if Config.Checksums is set.
+	ChecksumBytes uint64 = 4
+	// corruptFlag marks reads of corrupt slots.
+	// It is never stored in a slot.
+	corruptFlag uint64 = 1 << 61
+)
+
+// ErrCorrupt is returned if the stored data of an item
+// doesn't match its checksum or its length is invalid.
+var ErrCorrupt = errors.New("bigmap: corrupt slot")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum returns the CRC32C of an item.
+// The checksum covers the hash of the key, the length word with
+// the flags, the deadline and the data of the item as stored.
+func checksum(key, word uint64, deadline int64, val []byte) uint32 {
+	var header [24]byte
+	binary.LittleEndian.PutUint64(header[:], key)
+	binary.LittleEndian.PutUint64(header[8:], word)
+	binary.LittleEndian.PutUint64(header[16:], uint64(deadline))
+	crc := crc32.Update(0, castagnoli, header[:])
+	return crc32.Update(crc, castagnoli, val)
+}
+
+// stamp stores the checksum of the item at ptr.
+// The shard must be locked.
+func (S *Shard) stamp(key, ptr uint64) {
+	if !S.checksums {
+		return
+	}
+	val, deadline := S.slot(ptr)
+	sum := checksum(key, binary.LittleEndian.Uint64(S.array[ptr:]), deadline, val)
+	binary.LittleEndian.PutUint32(S.array[ptr+S.header-ChecksumBytes:], sum)
+}
+
+// storedChecksum returns the checksum stored for the item at ptr.
+func (S *Shard) storedChecksum(ptr uint64) uint32 {
+	if !S.checksums {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(S.array[ptr+S.header-ChecksumBytes:])
+}
+
+// check returns ErrCorrupt if the length of the item at ptr
+// exceeds the entrysize or its data doesn't match its checksum.
+// Without checksums only the length is checked.
+// The shard must be locked.
+func (S *Shard) check(key, ptr uint64) error {
+	word := binary.LittleEndian.Uint64(S.array[ptr:])
+	if word&^slotFlags > S.entrysize {
+		count(&S.metrics.corruptions)
+		return ErrCorrupt
+	}
+	if !S.checksums {
+		return nil
+	}
+	val, deadline := S.slot(ptr)
+	if checksum(key, word, deadline, val) != S.storedChecksum(ptr) {
+		count(&S.metrics.corruptions)
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// checkRecord verifies a value of the disk tier like check.
+func (S *Shard) checkRecord(key uint64, val []byte, record diskRecord) error {
+	if uint64(len(val)) > S.entrysize ||
+		S.checksums && checksum(key, uint64(len(val))|record.flags, record.deadline, val) != record.checksum {
+		count(&S.metrics.corruptions)
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// Verify checks every item of the map, including the items of the
+// disk tier, and returns the hashes of the keys of the corrupt items.
+// Items are corrupt if their length exceeds the entrysize or, with
+// Config.Checksums, their data doesn't match their checksum.
+// Corrupt items are reported but not removed, see BigMap.DeleteHash.
+// Reshard, Clear and Reset wait until Verify returns.
+func (B *BigMap) Verify() []uint64 {
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	var corrupt []uint64
+	for _, s := range B.loadTable().shards {
+		corrupt = append(corrupt, s.Verify()...)
+	}
+	return corrupt
+}
+
+// Verify checks every item of the shard like BigMap.Verify
+// and returns the corrupt keys.
+func (S *Shard) Verify() []uint64 {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	var corrupt []uint64
+	S.ptrs.Range(func(key, ptr uint64) bool {
+		if S.check(key, ptr) != nil {
+			corrupt = append(corrupt, key)
+		}
+		return true
+	})
+	if S.tier != nil {
+		S.tier.rangeRecords(func(key uint64, val []byte, record diskRecord) bool {
+			if S.checkRecord(key, val, record) != nil {
+				corrupt = append(corrupt, key)
+			}
+			return true
+		})
+	}
+	return corrupt
+}
@@ -0,0 +1,102 @@
+package bigmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBigMap_Checksums(t *testing.T) {
+	bm := New(16, Config{Shards: 1, Checksums: true, RefreshAhead: 0.5})
+	for i := 0; i < 10; i++ {
+		bm.Put([]byte(fmt.Sprint(i)), []byte("value"))
+	}
+	bm.Expire([]byte("1"), time.Minute)
+	if corrupt := bm.Verify(); len(corrupt) != 0 {
+		t.Fatalf("Verify of an intact map reported %d items", len(corrupt))
+	}
+
+	shard := bm.loadTable().shards[0]
+	hash := bm.hasher.Hash([]byte("1"))
+	ptr, _ := shard.ptrs.Get(hash)
+	shard.array[ptr+shard.header] ^= 1
+	if _, ok := bm.Get([]byte("1")); ok {
+		t.Fatalf("Get of a corrupt item returned true")
+	}
+	if _, ok := bm.GetInto([]byte("1"), make([]byte, 16)); ok {
+		t.Fatalf("GetInto of a corrupt item returned true")
+	}
+	if _, ok, err := bm.Fetch([]byte("1")); !ok || err != ErrCorrupt {
+		t.Fatalf("Fetch of a corrupt item got %v, %v", ok, err)
+	}
+	if _, err := bm.View([]byte("1"), func([]byte) { t.Fatalf("View of a corrupt item called fn") }); err != ErrCorrupt {
+		t.Fatalf("View of a corrupt item got %v", err)
+	}
+	if corrupt := bm.Verify(); len(corrupt) != 1 || corrupt[0] != hash {
+		t.Fatalf("Verify got %v, want [%d]", corrupt, hash)
+	}
+	if metrics := bm.TotalMetrics(); metrics.Corruptions != 5 || metrics.DecodeErrors != 0 {
+		t.Fatalf("expected 5 corruptions, got %+v", metrics)
+	}
+	snapshot := 0
+	bm.Snapshot(func(hash uint64, val []byte, ttl time.Duration) bool {
+		snapshot++
+		return true
+	})
+	if snapshot != 9 {
+		t.Fatalf("Snapshot got %d items, want 9", snapshot)
+	}
+	if err := bm.Reshard(2); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	if bm.Len() != 9 || len(bm.Verify()) != 0 {
+		t.Fatalf("expected the corrupt item to be dropped by Reshard, got %d items", bm.Len())
+	}
+}
+
+func TestBigMap_CorruptLength(t *testing.T) {
+	bm := New(16, Config{Shards: 1})
+	bm.Put([]byte("key"), []byte("value"))
+	shard := bm.loadTable().shards[0]
+	ptr, _ := shard.ptrs.Get(bm.hasher.Hash([]byte("key")))
+	binary.LittleEndian.PutUint64(shard.array[ptr:], 1<<40)
+	if _, ok := bm.Get([]byte("key")); ok {
+		t.Fatalf("Get of an item with a corrupt length returned true")
+	}
+	if _, _, err := bm.Fetch([]byte("key")); err != ErrCorrupt {
+		t.Fatalf("Fetch of an item with a corrupt length got %v", err)
+	}
+	if corrupt := bm.Verify(); len(corrupt) != 1 {
+		t.Fatalf("Verify got %v", corrupt)
+	}
+}
+
+func TestBigMap_ChecksumsOverflow(t *testing.T) {
+	bm := New(1024, Config{
+		Shards:            1,
+		Checksums:         true,
+		ExpirationFactory: Expires(10*time.Millisecond, ExpirationPolicySweep),
+		OverflowDir:       t.TempDir(),
+	})
+	defer bm.Close()
+	bm.Put([]byte("intact"), tierValue(1))
+	bm.Put([]byte("corrupt"), tierValue(2))
+	time.Sleep(20 * time.Millisecond)
+	tier := bm.loadTable().shards[0].tier
+	bm.loadTable().shards[0].Get(0)
+	if items, _ := tier.stats(); items != 2 {
+		t.Fatalf("expected the items to be spilled, got %d items on disk", items)
+	}
+	record := tier.index[bm.hasher.Hash([]byte("corrupt"))]
+	tier.file.WriteAt([]byte{0}, record.offset)
+	if corrupt := bm.Verify(); len(corrupt) != 1 {
+		t.Fatalf("Verify got %v", corrupt)
+	}
+	if _, ok := bm.Get([]byte("corrupt")); ok {
+		t.Fatalf("corrupt item was promoted")
+	}
+	if _, ok := bm.Get([]byte("intact")); !ok {
+		t.Fatalf("Get of the intact item returned false")
+	}
+}
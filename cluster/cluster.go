@@ -0,0 +1,226 @@
+// Package cluster distributes keys over multiple nodes
+// using a consistent-hash ring.
+//
+// Nodes are usually resp.Clients of bigmapd servers but any
+// bigmap.Store, including a local BigMap, can be used.
+// The Cluster implements bigmap.Store itself, so code can
+// switch between local and remote maps transparently.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+// DefaultVirtualNodes is the default amount of points
+// per weight a member places on the ring.
+const DefaultVirtualNodes = 160
+
+// ErrNoNodes is returned by writes to a Cluster without members.
+var ErrNoNodes = errors.New("cluster: no nodes")
+
+// Config configures a Cluster.
+// Values which are 0 will become the default values.
+type Config struct {
+	// VirtualNodes is the amount of points a member of weight 1
+	// places on the ring. More points spread the keys more evenly.
+	//
+	// Default: 160
+	VirtualNodes int
+	// Replicas is the amount of members each item is stored on.
+	//
+	// Default: 1
+	Replicas int
+	// Hasher is used to place the keys on the ring.
+	//
+	// Default: bigmap.FNVHasher
+	Hasher bigmap.Hasher
+}
+
+// Cluster distributes items over its members.
+//
+// Each key is owned by the members of the first points
+// clockwise of its hash on the ring. Adding or removing a
+// member only moves the keys of the points it takes or frees.
+// Items aren't migrated between members, keys which changed
+// their owner are missed until they are stored again.
+type Cluster struct {
+	config Config
+	ring   atomic.Value // *ring
+	lock   sync.Mutex
+}
+
+// New creates a new Cluster without members.
+func New(config ...Config) *Cluster {
+	conf := Config{
+		VirtualNodes: DefaultVirtualNodes,
+		Replicas:     1,
+		Hasher:       bigmap.FNVHasher{},
+	}
+	if len(config) != 0 {
+		firstConf := config[0]
+		if firstConf.VirtualNodes > 0 {
+			conf.VirtualNodes = firstConf.VirtualNodes
+		}
+		if firstConf.Replicas > 0 {
+			conf.Replicas = firstConf.Replicas
+		}
+		if firstConf.Hasher != nil {
+			conf.Hasher = firstConf.Hasher
+		}
+	}
+	C := &Cluster{config: conf}
+	C.ring.Store(&ring{})
+	return C
+}
+
+func (C *Cluster) loadRing() *ring {
+	return C.ring.Load().(*ring)
+}
+
+// Add adds the node under the name with the weight.
+// A member with twice the weight owns about twice the keys.
+// Adding a name twice replaces the previous node.
+func (C *Cluster) Add(name string, node bigmap.Store, weight int) error {
+	if weight <= 0 {
+		return fmt.Errorf("cluster add: invalid weight (%d)", weight)
+	}
+	C.lock.Lock()
+	defer C.lock.Unlock()
+	C.ring.Store(C.loadRing().with(name, node, weight, C.config.VirtualNodes))
+	return nil
+}
+
+// Remove removes the member of the name.
+func (C *Cluster) Remove(name string) {
+	C.lock.Lock()
+	defer C.lock.Unlock()
+	next := C.loadRing().without(name)
+	next.build(C.config.VirtualNodes)
+	C.ring.Store(next)
+}
+
+// Members returns the names of all members.
+func (C *Cluster) Members() []string {
+	return append([]string(nil), C.loadRing().names...)
+}
+
+// Owners returns the names of the members the key is stored on,
+// the primary owner first.
+func (C *Cluster) Owners(key []byte) []string {
+	R := C.loadRing()
+	var owners []string
+	for _, member := range R.owners(nil, C.config.Hasher.Hash(key), C.config.Replicas) {
+		owners = append(owners, R.names[member])
+	}
+	return owners
+}
+
+// nodes returns the owners of the key.
+func (C *Cluster) nodes(key []byte) []bigmap.Store {
+	R := C.loadRing()
+	var members [8]int
+	owners := R.owners(members[:0], C.config.Hasher.Hash(key), C.config.Replicas)
+	nodes := make([]bigmap.Store, len(owners))
+	for i, member := range owners {
+		nodes[i] = R.nodes[member]
+	}
+	return nodes
+}
+
+// Put stores the item on all owners of the key.
+// An error is returned if any owner failed.
+func (C *Cluster) Put(key, val []byte) error {
+	return C.write(key, func(node bigmap.Store) error {
+		return node.Put(key, val)
+	})
+}
+
+// PutTTL stores the item on all owners of the key
+// which expires after the ttl.
+// A ttl smaller or equal to 0 never expires.
+func (C *Cluster) PutTTL(key, val []byte, ttl time.Duration) error {
+	return C.write(key, func(node bigmap.Store) error {
+		return node.PutTTL(key, val, ttl)
+	})
+}
+
+func (C *Cluster) write(key []byte, op func(node bigmap.Store) error) error {
+	nodes := C.nodes(key)
+	if len(nodes) == 0 {
+		return ErrNoNodes
+	}
+	var first error
+	for _, node := range nodes {
+		if err := op(node); err != nil && first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("cluster put: %w", first)
+	}
+	return nil
+}
+
+// Get retrieves the item from the first owner which contains it.
+// Misses are retried with the next owner, so the item is found
+// as long as one of its owners is reachable.
+func (C *Cluster) Get(key []byte) ([]byte, bool) {
+	for _, node := range C.nodes(key) {
+		if val, ok := node.Get(key); ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// GetInto retrieves the item like Get and writes it into buffer.
+// Returns the size, true if the item was contained and 0, false otherwise.
+func (C *Cluster) GetInto(key, buffer []byte) (uint64, bool) {
+	for _, node := range C.nodes(key) {
+		if size, ok := node.GetInto(key, buffer); ok {
+			return size, true
+		}
+	}
+	return 0, false
+}
+
+// TTL returns the remaining time to live of the item
+// from the first owner which contains it.
+func (C *Cluster) TTL(key []byte) (time.Duration, bool) {
+	for _, node := range C.nodes(key) {
+		if ttl, ok := node.TTL(key); ok {
+			return ttl, true
+		}
+	}
+	return 0, false
+}
+
+// Expire sets the time to live of the item on all owners
+// and returns true if any of them contained it.
+func (C *Cluster) Expire(key []byte, ttl time.Duration) bool {
+	ok := false
+	for _, node := range C.nodes(key) {
+		if node.Expire(key, ttl) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// Delete removes the item from all owners and
+// returns true if any of them contained it.
+func (C *Cluster) Delete(key []byte) bool {
+	deleted := false
+	for _, node := range C.nodes(key) {
+		if node.Delete(key) {
+			deleted = true
+		}
+	}
+	return deleted
+}
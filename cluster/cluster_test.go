@@ -0,0 +1,164 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+	"github.com/worldOneo/bigmap/resp"
+	"github.com/worldOneo/bigmap/storetest"
+)
+
+var (
+	_ bigmap.Store = (*bigmap.BigMap)(nil)
+	_ bigmap.Store = (*resp.Client)(nil)
+	_ bigmap.Store = (*Cluster)(nil)
+)
+
+func localNode() *bigmap.BigMap {
+	bm := bigmap.New(64, bigmap.Config{Shards: 4})
+	return &bm
+}
+
+func owners(c *Cluster, n int) map[string]string {
+	owned := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owned[key] = c.Owners([]byte(key))[0]
+	}
+	return owned
+}
+
+func TestCluster_distribution(t *testing.T) {
+	c := New()
+	for i := 0; i < 4; i++ {
+		c.Add(fmt.Sprintf("node-%d", i), localNode(), 1)
+	}
+	c.Add("heavy", localNode(), 2)
+	counts := map[string]int{}
+	for _, owner := range owners(c, 60000) {
+		counts[owner]++
+	}
+	for i := 0; i < 4; i++ {
+		if n := counts[fmt.Sprintf("node-%d", i)]; n < 7000 || n > 13000 {
+			t.Fatalf("node-%d owns %d of 60000 keys, want about 10000", i, n)
+		}
+	}
+	if n := counts["heavy"]; n < 15000 || n > 25000 {
+		t.Fatalf("heavy owns %d of 60000 keys, want about 20000", n)
+	}
+}
+
+func TestCluster_movement(t *testing.T) {
+	c := New()
+	for i := 0; i < 4; i++ {
+		c.Add(fmt.Sprintf("node-%d", i), localNode(), 1)
+	}
+	before := owners(c, 10000)
+	c.Add("node-4", localNode(), 1)
+	after := owners(c, 10000)
+	moved := 0
+	for key, owner := range after {
+		if owner != before[key] {
+			moved++
+			if owner != "node-4" {
+				t.Fatalf("%s moved from %s to %s", key, before[key], owner)
+			}
+		}
+	}
+	if moved < 1000 || moved > 3000 {
+		t.Fatalf("%d of 10000 keys moved, want about 2000", moved)
+	}
+
+	c.Remove("node-4")
+	for key, owner := range owners(c, 10000) {
+		if owner != before[key] {
+			t.Fatalf("%s is owned by %s after removal, want %s", key, owner, before[key])
+		}
+	}
+}
+
+func TestCluster_replicas(t *testing.T) {
+	c := New(Config{Replicas: 2})
+	if err := c.Put([]byte("key"), []byte("value")); err != ErrNoNodes {
+		t.Fatalf("Put without nodes got %v, want %v", err, ErrNoNodes)
+	}
+	nodes := map[string]*bigmap.BigMap{}
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("node-%d", i)
+		nodes[name] = localNode()
+		c.Add(name, nodes[name], 1)
+	}
+	if err := c.PutTTL([]byte("key"), []byte("value"), time.Hour); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	owned := c.Owners([]byte("key"))
+	if len(owned) != 2 || owned[0] == owned[1] {
+		t.Fatalf("expected two distinct owners, got %v", owned)
+	}
+	for _, name := range owned {
+		if _, ok := nodes[name].Get([]byte("key")); !ok {
+			t.Fatalf("expected %s to hold a replica", name)
+		}
+	}
+
+	// the replica serves reads when the primary lost the item
+	nodes[owned[0]].Delete([]byte("key"))
+	if val, ok := c.Get([]byte("key")); !ok || string(val) != "value" {
+		t.Fatalf("Get got %q, %v", val, ok)
+	}
+	buffer := make([]byte, 64)
+	if size, ok := c.GetInto([]byte("key"), buffer); !ok || string(buffer[:size]) != "value" {
+		t.Fatalf("GetInto got %q, %v", buffer[:size], ok)
+	}
+	if !c.Delete([]byte("key")) {
+		t.Fatalf("Delete expected to delete the replica")
+	}
+	if _, ok := c.Get([]byte("key")); ok {
+		t.Fatalf("expected the item to be deleted")
+	}
+}
+
+func TestCluster_remote(t *testing.T) {
+	c := New()
+	for i := 0; i < 3; i++ {
+		bm := bigmap.New(resp.EntrySize(64, 64), bigmap.Config{Shards: 4})
+		server := resp.NewServer(&bm)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		go server.Serve(listener)
+		defer server.Close()
+		client := resp.NewClient(listener.Addr().String())
+		defer client.Close()
+		c.Add(client.Addr(), client, 1)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if err := c.Put(key, key); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if val, ok := c.Get(key); !ok || string(val) != string(key) {
+			t.Fatalf("Get(%s) got %q, %v", key, val, ok)
+		}
+	}
+	if !c.Delete([]byte("key-1")) || c.Delete([]byte("key-1")) {
+		t.Fatalf("expected exactly one delete")
+	}
+}
+
+func TestCluster_conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) bigmap.Store {
+		c := New(Config{Replicas: 2})
+		for i := 0; i < 3; i++ {
+			c.Add(fmt.Sprintf("node-%d", i), localNode(), 1)
+		}
+		return c
+	})
+}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/worldOneo/bigmap"
+)
+
+// point is a virtual node on the ring.
+type point struct {
+	hash   uint64
+	member int
+}
+
+// ring is an immutable consistent-hash ring.
+// Changes create a new ring so lookups don't need locking.
+type ring struct {
+	points  []point
+	names   []string
+	nodes   []bigmap.Store
+	weights []int
+}
+
+// with returns a copy of the ring with the member added or replaced.
+func (R *ring) with(name string, node bigmap.Store, weight, virtualNodes int) *ring {
+	next := R.without(name)
+	next.names = append(next.names, name)
+	next.nodes = append(next.nodes, node)
+	next.weights = append(next.weights, weight)
+	next.build(virtualNodes)
+	return next
+}
+
+// without returns a copy of the ring without the member.
+func (R *ring) without(name string) *ring {
+	next := &ring{}
+	for i, registered := range R.names {
+		if registered != name {
+			next.names = append(next.names, registered)
+			next.nodes = append(next.nodes, R.nodes[i])
+			next.weights = append(next.weights, R.weights[i])
+		}
+	}
+	return next
+}
+
+// build places weight*virtualNodes points per member on the ring.
+// The points only depend on the name of the member, so every
+// member keeps its points when others are added or removed.
+func (R *ring) build(virtualNodes int) {
+	R.points = R.points[:0]
+	var label []byte
+	for member, name := range R.names {
+		for i := 0; i < R.weights[member]*virtualNodes; i++ {
+			label = append(label[:0], name...)
+			label = append(label, '#')
+			label = strconv.AppendInt(label, int64(i), 10)
+			R.points = append(R.points, point{bigmap.Mix64(bigmap.FNV64(label)), member})
+		}
+	}
+	sort.Slice(R.points, func(i, j int) bool {
+		return R.points[i].hash < R.points[j].hash
+	})
+}
+
+// owners appends up to n distinct members responsible for the hash,
+// the first point clockwise of the hash and its successors.
+func (R *ring) owners(dst []int, hash uint64, n int) []int {
+	if len(R.points) == 0 {
+		return dst
+	}
+	if n > len(R.names) {
+		n = len(R.names)
+	}
+	start := sort.Search(len(R.points), func(i int) bool {
+		return R.points[i].hash >= hash
+	})
+	for i := 0; i < len(R.points) && len(dst) < n; i++ {
+		member := R.points[(start+i)%len(R.points)].member
+		if !contains(dst, member) {
+			dst = append(dst, member)
+		}
+	}
+	return dst
+}
+
+func contains(members []int, member int) bool {
+	for _, m := range members {
+		if m == member {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+// Command bigmapd serves a BigMap over the RESP2 protocol
+// so it can be used by redis clients.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/worldOneo/bigmap"
+	"github.com/worldOneo/bigmap/resp"
+)
+
+func main() {
+	addr := flag.String("addr", ":6379", "address to listen on")
+	maxKey := flag.Int("max-key", 256, "maximum size of a key in bytes")
+	maxValue := flag.Int("max-value", 4096, "maximum size of a value in bytes")
+	shards := flag.Int("shards", bigmap.DefaultShards, "amount of shards")
+	capacity := flag.Uint64("capacity", 1<<20, "initial capacity of each shard in bytes")
+	flag.Parse()
+
+	bm := bigmap.New(resp.EntrySize(*maxKey, *maxValue), bigmap.Config{
+		Shards:   *shards,
+		Capacity: *capacity,
+		Hasher:   bigmap.NewWyHasher(),
+	})
+	server := resp.NewServer(&bm)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	log.Printf("bigmapd listening on %s", *addr)
+	if err := server.ListenAndServe(*addr); err != nil && err != resp.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
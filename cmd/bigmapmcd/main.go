@@ -0,0 +1,41 @@
+// Command bigmapmcd serves a BigMap over the memcached text
+// and binary protocols so it can be used by memcached clients.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/worldOneo/bigmap"
+	"github.com/worldOneo/bigmap/memcache"
+)
+
+func main() {
+	addr := flag.String("addr", ":11211", "address to listen on")
+	maxValue := flag.Int("max-value", 4096, "maximum size of a value in bytes")
+	shards := flag.Int("shards", bigmap.DefaultShards, "amount of shards")
+	capacity := flag.Uint64("capacity", 1<<20, "initial capacity of each shard in bytes")
+	flag.Parse()
+
+	bm := bigmap.New(memcache.EntrySize(*maxValue), bigmap.Config{
+		Shards:   *shards,
+		Capacity: *capacity,
+		Hasher:   bigmap.NewWyHasher(),
+	})
+	server := memcache.NewServer(&bm)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	log.Printf("bigmapmcd listening on %s", *addr)
+	if err := server.ListenAndServe(*addr); err != nil && err != memcache.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
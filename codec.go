@@ -0,0 +1,168 @@
+package bigmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Integer is the set of integer types supported
+// by IntegerKeys and IntegerCodec.
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// IntegerBytes is the amount of bytes an encoded integer takes.
+const IntegerBytes = 8
+
+// StringKeys encodes string keys by their bytes.
+type StringKeys struct{}
+
+// AppendKey implements KeyEncoder.
+func (StringKeys) AppendKey(dst []byte, key string) []byte {
+	return append(dst, key...)
+}
+
+// BytesKeys uses byte slice keys as they are.
+type BytesKeys struct{}
+
+// AppendKey implements KeyEncoder.
+func (BytesKeys) AppendKey(dst []byte, key []byte) []byte {
+	return append(dst, key...)
+}
+
+// IntegerKeys encodes integer keys as 8 little endian bytes.
+type IntegerKeys[T Integer] struct{}
+
+// AppendKey implements KeyEncoder.
+func (IntegerKeys[T]) AppendKey(dst []byte, key T) []byte {
+	return appendUint64(dst, uint64(key))
+}
+
+// StringCodec stores string values by their bytes.
+type StringCodec struct{}
+
+// Append implements Codec.
+func (StringCodec) Append(dst []byte, val string) ([]byte, error) {
+	return append(dst, val...), nil
+}
+
+// Decode implements Codec.
+func (StringCodec) Decode(src []byte, val *string) error {
+	*val = string(src)
+	return nil
+}
+
+// BytesCodec stores byte slice values as they are.
+// Decode reuses the capacity of the target slice.
+type BytesCodec struct{}
+
+// Append implements Codec.
+func (BytesCodec) Append(dst []byte, val []byte) ([]byte, error) {
+	return append(dst, val...), nil
+}
+
+// Decode implements Codec.
+func (BytesCodec) Decode(src []byte, val *[]byte) error {
+	*val = append((*val)[:0], src...)
+	return nil
+}
+
+// IntegerCodec stores integer values as 8 little endian bytes.
+type IntegerCodec[T Integer] struct{}
+
+// Append implements Codec.
+func (IntegerCodec[T]) Append(dst []byte, val T) ([]byte, error) {
+	return appendUint64(dst, uint64(val)), nil
+}
+
+// Decode implements Codec.
+func (IntegerCodec[T]) Decode(src []byte, val *T) error {
+	if len(src) != IntegerBytes {
+		return fmt.Errorf("integer codec: invalid length (%d != %d)", len(src), IntegerBytes)
+	}
+	*val = T(binary.LittleEndian.Uint64(src))
+	return nil
+}
+
+// BinaryCodec stores fixed-layout values with encoding/binary.
+// T must be a fixed-size value or a struct of fixed-size values.
+type BinaryCodec[T any] struct {
+	// ByteOrder used to encode the values.
+	// A nil ByteOrder uses little endian.
+	ByteOrder binary.ByteOrder
+}
+
+func (B BinaryCodec[T]) order() binary.ByteOrder {
+	if B.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return B.ByteOrder
+}
+
+// Append implements Codec.
+func (B BinaryCodec[T]) Append(dst []byte, val T) ([]byte, error) {
+	buffer := bytes.NewBuffer(dst)
+	if err := binary.Write(buffer, B.order(), val); err != nil {
+		return dst, fmt.Errorf("binary codec: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decode implements Codec.
+func (B BinaryCodec[T]) Decode(src []byte, val *T) error {
+	if err := binary.Read(bytes.NewReader(src), B.order(), val); err != nil {
+		return fmt.Errorf("binary codec: %v", err)
+	}
+	return nil
+}
+
+// GobCodec stores values with encoding/gob.
+// Each value carries its own type information.
+type GobCodec[T any] struct{}
+
+// Append implements Codec.
+func (GobCodec[T]) Append(dst []byte, val T) ([]byte, error) {
+	buffer := bytes.NewBuffer(dst)
+	if err := gob.NewEncoder(buffer).Encode(val); err != nil {
+		return dst, fmt.Errorf("gob codec: %v", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decode implements Codec.
+func (GobCodec[T]) Decode(src []byte, val *T) error {
+	if err := gob.NewDecoder(bytes.NewReader(src)).Decode(val); err != nil {
+		return fmt.Errorf("gob codec: %v", err)
+	}
+	return nil
+}
+
+// JSONCodec stores values with encoding/json.
+type JSONCodec[T any] struct{}
+
+// Append implements Codec.
+func (JSONCodec[T]) Append(dst []byte, val T) ([]byte, error) {
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		return dst, fmt.Errorf("json codec: %v", err)
+	}
+	return append(dst, encoded...), nil
+}
+
+// Decode implements Codec.
+func (JSONCodec[T]) Decode(src []byte, val *T) error {
+	if err := json.Unmarshal(src, val); err != nil {
+		return fmt.Errorf("json codec: %v", err)
+	}
+	return nil
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	return append(dst,
+		byte(v), byte(v>>8), byte(v>>16), byte(v>>24),
+		byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
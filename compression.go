@@ -0,0 +1,80 @@
+package bigmap
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// compressedFlag marks compressed values in the length word of a slot.
+	compressedFlag uint64 = 1 << 63
+	// minCompression is the size of the smallest values which are compressed.
+	minCompression = 64
+)
+
+var (
+	flateWriters [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+	flateReaders sync.Pool
+)
+
+// validCompression reports whether the level is a flate level
+// which compresses.
+func validCompression(level int) bool {
+	return level >= flate.HuffmanOnly && level <= flate.BestCompression && level != flate.NoCompression
+}
+
+// compress returns the value as it is stored and the flags of its slot.
+// The value is only compressed if the shard compresses and it saves space.
+func (S *Shard) compress(val []byte) ([]byte, uint64) {
+	if S.compression == 0 || len(val) < minCompression {
+		return val, 0
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, len(val)))
+	pool := &flateWriters[S.compression-flate.HuffmanOnly]
+	writer, _ := pool.Get().(*flate.Writer)
+	if writer == nil {
+		var err error
+		if writer, err = flate.NewWriter(buffer, S.compression); err != nil {
+			return val, 0
+		}
+	} else {
+		writer.Reset(buffer)
+	}
+	writer.Write(val)
+	writer.Close()
+	pool.Put(writer)
+	if buffer.Len() >= len(val) {
+		return val, 0
+	}
+	count(&S.metrics.compressed)
+	atomic.AddUint64(&S.metrics.uncompressedBytes, uint64(len(val)))
+	atomic.AddUint64(&S.metrics.compressedBytes, uint64(buffer.Len()))
+	return buffer.Bytes(), compressedFlag
+}
+
+// inflate appends the decompressed src to dst.
+func inflate(dst, src []byte) ([]byte, error) {
+	reader, _ := flateReaders.Get().(io.ReadCloser)
+	if reader == nil {
+		reader = flate.NewReader(bytes.NewReader(src))
+	} else {
+		reader.(flate.Resetter).Reset(bytes.NewReader(src), nil)
+	}
+	buffer := bytes.NewBuffer(dst)
+	_, err := buffer.ReadFrom(reader)
+	flateReaders.Put(reader)
+	return buffer.Bytes(), err
+}
+
+// CompressionRatio returns the size of the compressed values
+// before compression divided by their compressed size
+// or 0 if no value was compressed.
+func (M Metrics) CompressionRatio() float64 {
+	if M.CompressedBytes == 0 {
+		return 0
+	}
+	return float64(M.UncompressedBytes) / float64(M.CompressedBytes)
+}
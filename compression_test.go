@@ -0,0 +1,92 @@
+package bigmap
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestBigMap_Compression(t *testing.T) {
+	bm := New(128, Config{Shards: 2, Compression: flate.BestSpeed})
+	json := bytes.Repeat([]byte(`{"name":"value","count":1},`), 20)
+	if err := bm.Put([]byte("json"), json); err != nil {
+		t.Fatalf("Put of a compressible value larger than the entrysize: %v", err)
+	}
+	random := make([]byte, 128)
+	rand.Read(random)
+	if err := bm.Put([]byte("random"), random); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := bm.Put([]byte("large"), make([]byte, 129)); err != nil {
+		t.Fatalf("Put of zeros: %v", err)
+	}
+	tooLarge := make([]byte, 129)
+	rand.Read(tooLarge)
+	if err := bm.Put([]byte("too large"), tooLarge); err == nil {
+		t.Fatalf("expected an error for an incompressible value larger than the entrysize")
+	}
+
+	metrics := bm.TotalMetrics()
+	if metrics.Compressed != 2 || metrics.CompressionRatio() <= 4 {
+		t.Fatalf("expected two compressed values, got %d with ratio %.2f", metrics.Compressed, metrics.CompressionRatio())
+	}
+	for key, want := range map[string][]byte{"json": json, "random": random} {
+		if val, ok := bm.Get([]byte(key)); !ok || !bytes.Equal(val, want) {
+			t.Fatalf("Get(%q) got %d bytes, %v", key, len(val), ok)
+		}
+		buffer := make([]byte, len(want))
+		if size, ok := bm.GetInto([]byte(key), buffer); !ok || !bytes.Equal(buffer[:size], want) {
+			t.Fatalf("GetInto(%q) got %d bytes, %v", key, size, ok)
+		}
+		viewed, err := bm.View([]byte(key), func(val []byte) {
+			if !bytes.Equal(val, want) {
+				t.Fatalf("View(%q) got %d bytes", key, len(val))
+			}
+		})
+		if !viewed || err != nil {
+			t.Fatalf("View(%q) returned %v, %v", key, viewed, err)
+		}
+	}
+	small := make([]byte, 10)
+	if size, ok := bm.GetInto([]byte("json"), small); !ok || size != uint64(len(json)) || !bytes.Equal(small, json[:10]) {
+		t.Fatalf("GetInto with a small buffer got %d, %v", size, ok)
+	}
+
+	bm.Update([]byte("json"), func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, UpdateOp) {
+		if !bytes.Equal(val, json) {
+			t.Fatalf("Update got %d bytes", len(val))
+		}
+		return append(val, json...), 0, UpdatePut
+	})
+	if err := bm.Reshard(3); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	ranged := 0
+	bm.Range(func(key uint64, val []byte) bool {
+		if bytes.HasPrefix(val, json) {
+			ranged++
+			if len(val) != 2*len(json) {
+				t.Fatalf("Range got %d bytes", len(val))
+			}
+		}
+		return true
+	})
+	if ranged != 1 {
+		t.Fatalf("expected the updated value in Range")
+	}
+}
+
+func TestBigMap_View(t *testing.T) {
+	bm := New(16, Config{Shards: 2})
+	if ok, _ := bm.View([]byte("key"), func(val []byte) { t.Fatalf("View of a missing key called fn") }); ok {
+		t.Fatalf("View of a missing key returned true")
+	}
+	bm.Put([]byte("key"), []byte("value"))
+	var viewed string
+	bm.View([]byte("key"), func(val []byte) { viewed = string(val) })
+	if viewed != "value" {
+		t.Fatalf("View got %q", viewed)
+	}
+}
@@ -0,0 +1,101 @@
+package bigmap
+
+import "sync"
+
+// slotFlags are the flags kept in the length word of a slot.
+const slotFlags = compressedFlag | encryptedFlag | structureFlag
+
+var scratches = sync.Pool{New: func() interface{} { return new(scratch) }}
+
+// scratch holds the buffers of a read of an encoded value.
+type scratch struct {
+	raw, val, plain []byte
+}
+
+// encode returns the value as it is stored and the flags of its slot.
+// The value is compressed first and encrypted afterwards.
+func (S *Shard) encode(key uint64, val []byte) ([]byte, uint64, error) {
+	stored, flags := S.compress(val)
+	if S.keyring == nil {
+		return stored, flags, nil
+	}
+	sealed, err := S.keyring.seal(make([]byte, 0, len(stored)+EncryptionOverhead), key, stored)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sealed, flags | encryptedFlag, nil
+}
+
+// decode appends the decoded value to dst and returns it.
+// Values which are neither encrypted nor compressed
+// are returned as they are.
+func decode(dst []byte, keyring *Keyring, key uint64, val []byte, flags uint64) ([]byte, error) {
+	if flags&encryptedFlag != 0 {
+		if keyring == nil {
+			return dst, ErrUnknownKey
+		}
+		if flags&compressedFlag == 0 {
+			return keyring.open(dst, key, val)
+		}
+		buffers := scratches.Get().(*scratch)
+		defer scratches.Put(buffers)
+		plain, err := keyring.open(buffers.plain[:0], key, val)
+		buffers.plain = plain[:0]
+		if err != nil {
+			return dst, err
+		}
+		val = plain
+	}
+	if flags&compressedFlag != 0 {
+		return inflate(dst, val)
+	}
+	return val, nil
+}
+
+// decode decodes a value of the shard like decode
+// and counts the values which can't be decoded.
+// Values read with the corruptFlag return ErrCorrupt.
+func (S *Shard) decode(dst []byte, key uint64, val []byte, flags uint64) ([]byte, error) {
+	if flags&corruptFlag != 0 {
+		return dst, ErrCorrupt
+	}
+	val, err := decode(dst, S.keyring, key, val, flags)
+	if err != nil {
+		count(&S.metrics.decodeErrors)
+	}
+	return val, err
+}
+
+// View calls fn with the item of the key and returns true
+// or returns false if the item isn't contained.
+// The value is read into a pooled buffer and
+// must not be retained after fn returned.
+// An error is returned if the value can't be decoded.
+func (S *Shard) View(key uint64, fn func(val []byte)) (bool, error) {
+	return view(func(dst []byte) (*Shard, []byte, uint64, bool) {
+		val, flags, ok := S.copySlot(key, dst)
+		return S, val, flags, ok
+	}, key, func(val []byte, _ uint64) { fn(val) })
+}
+
+// view reads a value with read into pooled buffers
+// and calls fn with the decoded value and the flags of its slot.
+func view(read func(dst []byte) (*Shard, []byte, uint64, bool), key uint64, fn func(val []byte, flags uint64)) (bool, error) {
+	buffers := scratches.Get().(*scratch)
+	defer scratches.Put(buffers)
+	shard, val, flags, ok := read(buffers.raw[:0])
+	buffers.raw = val[:0]
+	if !ok {
+		return false, nil
+	}
+	if flags != 0 {
+		var err error
+		val, err = shard.decode(buffers.val[:0], key, val, flags)
+		buffers.val = val[:0]
+		if err != nil {
+			return false, err
+		}
+	}
+	fn(val, flags)
+	return true, nil
+}
@@ -0,0 +1,229 @@
+package bigmap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	keyIDBytes = 4
+	nonceBytes = 12
+	tagBytes   = 16
+	// EncryptionOverhead is the amount of bytes added to each value
+	// by encryption: the key id, the nonce and the authentication tag.
+	EncryptionOverhead = keyIDBytes + nonceBytes + tagBytes
+	// encryptedFlag marks encrypted values in the length word of a slot.
+	encryptedFlag uint64 = 1 << 62
+)
+
+var (
+	// ErrUnknownKey is returned if a value was encrypted
+	// with a key which isn't in the Keyring.
+	ErrUnknownKey = errors.New("bigmap: unknown encryption key")
+	// ErrAuthentication is returned if an encrypted value
+	// was modified or doesn't belong to its key.
+	ErrAuthentication = errors.New("bigmap: value failed authentication")
+)
+
+// Keyring holds the AES keys values are encrypted with.
+// New values are encrypted with the current key, the other keys
+// are kept to decrypt the values encrypted before a rotation.
+// Every value stores the id of its key.
+type Keyring struct {
+	lock    sync.RWMutex
+	keys    map[uint32]cipher.AEAD
+	current uint32
+}
+
+// NewKeyring creates a Keyring with the key as current key.
+// The key must be 16, 24 or 32 bytes long to select
+// AES-128, AES-192 or AES-256.
+func NewKeyring(id uint32, key []byte) (*Keyring, error) {
+	K := &Keyring{keys: make(map[uint32]cipher.AEAD)}
+	if err := K.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return K, nil
+}
+
+// Add adds a key to decrypt values with.
+func (K *Keyring) Add(id uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("keyring add: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("keyring add: %w", err)
+	}
+	K.lock.Lock()
+	defer K.lock.Unlock()
+	K.keys[id] = aead
+	return nil
+}
+
+// Rotate adds the key and makes it the current key.
+// Values encrypted with previous keys stay readable
+// until their key is removed, BigMap.Reencrypt
+// encrypts them with the current key.
+func (K *Keyring) Rotate(id uint32, key []byte) error {
+	if err := K.Add(id, key); err != nil {
+		return err
+	}
+	K.lock.Lock()
+	defer K.lock.Unlock()
+	K.current = id
+	return nil
+}
+
+// Remove removes the key.
+// The current key can't be removed.
+func (K *Keyring) Remove(id uint32) error {
+	K.lock.Lock()
+	defer K.lock.Unlock()
+	if id == K.current {
+		return fmt.Errorf("keyring remove: key %d is the current key", id)
+	}
+	delete(K.keys, id)
+	return nil
+}
+
+// Current returns the id of the current key.
+func (K *Keyring) Current() uint32 {
+	K.lock.RLock()
+	defer K.lock.RUnlock()
+	return K.current
+}
+
+// seal appends the value encrypted with the current key to dst.
+// The hash of the key of the item is authenticated with the value,
+// so values can't be swapped between items.
+func (K *Keyring) seal(dst []byte, hash uint64, val []byte) ([]byte, error) {
+	K.lock.RLock()
+	id, aead := K.current, K.keys[K.current]
+	K.lock.RUnlock()
+	start := len(dst)
+	dst = append(dst, make([]byte, keyIDBytes+nonceBytes)...)
+	binary.LittleEndian.PutUint32(dst[start:], id)
+	nonce := dst[start+keyIDBytes:]
+	if _, err := rand.Read(nonce); err != nil {
+		return dst[:start], fmt.Errorf("keyring seal: %w", err)
+	}
+	var aad [8]byte
+	binary.LittleEndian.PutUint64(aad[:], hash)
+	return aead.Seal(dst, nonce, val, aad[:]), nil
+}
+
+// open appends the decrypted value to dst.
+func (K *Keyring) open(dst []byte, hash uint64, sealed []byte) ([]byte, error) {
+	if len(sealed) < EncryptionOverhead {
+		return dst, ErrAuthentication
+	}
+	id := binary.LittleEndian.Uint32(sealed)
+	K.lock.RLock()
+	aead, ok := K.keys[id]
+	K.lock.RUnlock()
+	if !ok {
+		return dst, fmt.Errorf("%w (%d)", ErrUnknownKey, id)
+	}
+	var aad [8]byte
+	binary.LittleEndian.PutUint64(aad[:], hash)
+	nonce := sealed[keyIDBytes : keyIDBytes+nonceBytes]
+	out, err := aead.Open(dst, nonce, sealed[keyIDBytes+nonceBytes:], aad[:])
+	if err != nil {
+		return dst, ErrAuthentication
+	}
+	return out, nil
+}
+
+// stale reports whether the value was encrypted with an old key.
+func (K *Keyring) stale(sealed []byte) bool {
+	return len(sealed) >= keyIDBytes && binary.LittleEndian.Uint32(sealed) != K.Current()
+}
+
+// Reencrypt encrypts the values which were encrypted with
+// previous keys of the Keyring with its current key, so the
+// previous keys can be removed afterwards.
+// It returns the amount of reencrypted values and the first
+// error of a value which couldn't be decrypted.
+// Reencrypt does nothing without Config.Keyring.
+func (B *BigMap) Reencrypt() (int, error) {
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	n := 0
+	var err error
+	for _, s := range B.loadTable().shards {
+		reencrypted, shardErr := s.reencrypt()
+		n += reencrypted
+		if err == nil {
+			err = shardErr
+		}
+	}
+	return n, err
+}
+
+// reencrypt encrypts the values of the shard like BigMap.Reencrypt.
+func (S *Shard) reencrypt() (int, error) {
+	if S.keyring == nil {
+		return 0, nil
+	}
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	n := 0
+	var err error
+	reseal := func(key uint64, val []byte) ([]byte, bool) {
+		plain, openErr := S.keyring.open(nil, key, val)
+		if openErr == nil {
+			val, openErr = S.keyring.seal(nil, key, plain)
+		}
+		if openErr != nil {
+			count(&S.metrics.decodeErrors)
+			if err == nil {
+				err = openErr
+			}
+			return nil, false
+		}
+		n++
+		return val, true
+	}
+	var stale []uint64
+	S.ptrs.Range(func(key, ptr uint64) bool {
+		if val, _ := S.slot(ptr); S.flags(ptr)&encryptedFlag != 0 && S.keyring.stale(val) && S.check(key, ptr) == nil {
+			stale = append(stale, key)
+		}
+		return true
+	})
+	for _, key := range stale {
+		ptr, _ := S.ptrs.Get(key)
+		val, deadline := S.slot(ptr)
+		if val, ok := reseal(key, val); ok {
+			S.write(key, val, S.flags(ptr), deadline, S.lifetime(ptr))
+		}
+	}
+	if S.tier == nil {
+		return n, err
+	}
+	stale = stale[:0]
+	S.tier.rangeRecords(func(key uint64, val []byte, record diskRecord) bool {
+		if record.flags&encryptedFlag != 0 && S.keyring.stale(val) && S.checkRecord(key, val, record) == nil {
+			stale = append(stale, key)
+		}
+		return true
+	})
+	for _, key := range stale {
+		// the old value is kept until the resealed one replaces it
+		val, record, ok := S.tier.read(key)
+		if !ok {
+			continue
+		}
+		if val, ok = reseal(key, val); ok {
+			S.spill(key, itemMeta{}, val, record)
+		}
+	}
+	return n, err
+}
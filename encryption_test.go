@@ -0,0 +1,144 @@
+package bigmap
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestKeyring(t *testing.T) {
+	if _, err := NewKeyring(1, []byte("short")); err == nil {
+		t.Fatalf("expected an error for an invalid key size")
+	}
+	keyring, err := NewKeyring(1, testKey(1))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if err := keyring.Remove(1); err == nil {
+		t.Fatalf("expected an error removing the current key")
+	}
+	sealed, _ := keyring.seal(nil, 7, []byte("secret"))
+	if len(sealed) != len("secret")+EncryptionOverhead {
+		t.Fatalf("sealed value has %d bytes", len(sealed))
+	}
+	if plain, err := keyring.open(nil, 7, sealed); err != nil || string(plain) != "secret" {
+		t.Fatalf("open got %q, %v", plain, err)
+	}
+	if _, err := keyring.open(nil, 8, sealed); err != ErrAuthentication {
+		t.Fatalf("open of a value of another key got %v", err)
+	}
+}
+
+func TestBigMap_Encryption(t *testing.T) {
+	keyring, _ := NewKeyring(1, testKey(1))
+	bm := New(16, Config{Shards: 1, Keyring: keyring})
+	secret := []byte("0123456789abcdef")
+	if err := bm.Put([]byte("key"), secret); err != nil {
+		t.Fatalf("Put of a value of entrysize: %v", err)
+	}
+	if val, ok := bm.Get([]byte("key")); !ok || !bytes.Equal(val, secret) {
+		t.Fatalf("Get got %q, %v", val, ok)
+	}
+	shard := bm.loadTable().shards[0]
+	if bytes.Contains(shard.array, secret) {
+		t.Fatalf("plaintext found in the byte-array")
+	}
+
+	ptr, _ := shard.ptrs.Get(bm.hasher.Hash([]byte("key")))
+	shard.array[ptr+shard.header+EncryptionOverhead] ^= 1
+	if _, ok := bm.Get([]byte("key")); ok {
+		t.Fatalf("Get of a modified value returned true")
+	}
+	if _, ok, err := bm.Fetch([]byte("key")); !ok || err != ErrAuthentication {
+		t.Fatalf("Fetch of a modified value got %v, %v", ok, err)
+	}
+	if _, err := bm.View([]byte("key"), func([]byte) {}); err != ErrAuthentication {
+		t.Fatalf("View of a modified value got %v", err)
+	}
+	if metrics := bm.TotalMetrics(); metrics.DecodeErrors != 3 {
+		t.Fatalf("expected 3 decode errors, got %d", metrics.DecodeErrors)
+	}
+}
+
+func TestBigMap_KeyRotation(t *testing.T) {
+	keyring, _ := NewKeyring(1, testKey(1))
+	bm := New(16, Config{Shards: 2, Keyring: keyring})
+	bm.Put([]byte("old"), []byte("old value"))
+	keyring.Rotate(2, testKey(2))
+	bm.Put([]byte("new"), []byte("new value"))
+	for _, key := range []string{"old", "new"} {
+		if val, ok, err := bm.Fetch([]byte(key)); !ok || err != nil || string(val) != key+" value" {
+			t.Fatalf("Fetch(%q) got %q, %v, %v", key, val, ok, err)
+		}
+	}
+	keyring.Remove(1)
+	if _, _, err := bm.Fetch([]byte("old")); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Fetch of a value of a removed key got %v", err)
+	}
+
+	keyring.Add(1, testKey(1))
+	if n, err := bm.Reencrypt(); n != 1 || err != nil {
+		t.Fatalf("Reencrypt got %d, %v", n, err)
+	}
+	keyring.Remove(1)
+	if val, _, err := bm.Fetch([]byte("old")); err != nil || string(val) != "old value" {
+		t.Fatalf("Fetch after Reencrypt got %q, %v", val, err)
+	}
+	if n, _ := bm.Reencrypt(); n != 0 {
+		t.Fatalf("second Reencrypt reencrypted %d values", n)
+	}
+}
+
+func TestBigMap_EncryptionOverflow(t *testing.T) {
+	keyring, _ := NewKeyring(1, testKey(1))
+	dir := t.TempDir()
+	bm := New(64, Config{
+		Shards:            1,
+		Keyring:           keyring,
+		Compression:       flate.BestSpeed,
+		ExpirationFactory: Expires(10*time.Millisecond, ExpirationPolicySweep),
+		OverflowDir:       dir,
+	})
+	defer bm.Close()
+	secret := bytes.Repeat([]byte("secret "), 20)
+	if err := bm.Put([]byte("key"), secret); err != nil {
+		t.Fatalf("Put of a compressible value: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	bm.loadTable().shards[0].Get(0)
+	if metrics := bm.TotalMetrics(); metrics.DiskItems != 1 {
+		t.Fatalf("expected the item to be spilled, got %d items on disk", metrics.DiskItems)
+	}
+	logs, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, log := range logs {
+		data, _ := os.ReadFile(log)
+		if bytes.Contains(data, []byte("secret")) {
+			t.Fatalf("plaintext found in the log")
+		}
+	}
+	// a failed reencryption keeps the spilled item
+	keyring.Rotate(2, testKey(2))
+	keyring.Remove(1)
+	if n, err := bm.Reencrypt(); n != 0 || !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Reencrypt without the old key got %d, %v", n, err)
+	}
+	if metrics := bm.TotalMetrics(); metrics.DiskItems != 1 {
+		t.Fatalf("failed Reencrypt dropped the spilled item")
+	}
+	keyring.Add(1, testKey(1))
+	if n, err := bm.Reencrypt(); n != 1 || err != nil {
+		t.Fatalf("Reencrypt got %d, %v", n, err)
+	}
+	keyring.Remove(1)
+	if val, ok := bm.Get([]byte("key")); !ok || !bytes.Equal(val, secret) {
+		t.Fatalf("Get of the spilled item got %d bytes, %v", len(val), ok)
+	}
+}
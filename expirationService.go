@@ -1,6 +1,10 @@
 package bigmap
 
 // ExpirationService is the interface used for expiring items within a shard
+//
+// An ExpirationService which keeps state for the items of a shard can
+// implement Clear(shard *Shard), which is called after Shard.Clear or
+// Shard.Reset removed all items and before the shard is unlocked.
 type ExpirationService interface {
 	// BeforeLock is called before the shard was accessed (put or get
 	// and before the shard is locked with the key which is about to
@@ -33,3 +37,19 @@ type ExpirationService interface {
 	// Accessing the shard from this method might cause a deadlock.
 	Remove(key uint64, shard *Shard)
 }
+
+// expirationClearer is implemented by ExpirationServices which keep
+// state for the items of a shard.
+// Clear is called after all items were removed from the shard
+// by Shard.Clear or Shard.Reset and before it is unlocked.
+// Any state kept for the items of the shard should be dropped.
+//
+// Accessing the shard from this method might cause a deadlock.
+type expirationClearer interface {
+	Clear(shard *Shard)
+}
+
+var (
+	_ expirationClearer = (*passiveExpirationService)(nil)
+	_ expirationClearer = (*sweepExpirationService)(nil)
+)
@@ -1,6 +1,6 @@
 module github.com/worldOneo/bigmap
 
-go 1.16
+go 1.18
 
 require github.com/worldOneo/CommonCollections v0.1.2
 
@@ -0,0 +1,156 @@
+package bigmap
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+// Hasher hashes the keys of a BigMap.
+// Hash and HashString must return the same hash
+// for the same sequence of bytes.
+//
+// An implementation must be safe for concurrent use.
+type Hasher interface {
+	// Hash hashes a byte-slice key.
+	Hash(key []byte) uint64
+	// HashString hashes a string key without converting it.
+	HashString(key string) uint64
+}
+
+// FNVHasher hashes keys with FNV64.
+// It is unseeded and therefore keys can be crafted to
+// collide or to be put into the same shard.
+// Use a seeded Hasher if the keys are controlled by
+// untrusted parties.
+type FNVHasher struct{}
+
+// Hash implements Hasher.
+func (FNVHasher) Hash(key []byte) uint64 {
+	return FNV64(key)
+}
+
+// HashString implements Hasher.
+func (FNVHasher) HashString(key string) uint64 {
+	return FNV64String(key)
+}
+
+// WyHasher hashes keys with a seeded wyhash.
+// It is faster than FNV64 for long keys and
+// resists hash flooding as long as the seed is secret.
+type WyHasher struct {
+	Seed uint64
+}
+
+// NewWyHasher creates a new WyHasher with a random seed.
+func NewWyHasher() WyHasher {
+	return WyHasher{Seed: RandomSeed()}
+}
+
+// Hash implements Hasher.
+func (W WyHasher) Hash(key []byte) uint64 {
+	return wyhash(key, W.Seed)
+}
+
+// HashString implements Hasher.
+func (W WyHasher) HashString(key string) uint64 {
+	return wyhash(key, W.Seed)
+}
+
+// MapHasher hashes keys with hash/maphash
+// using a random seed.
+type MapHasher struct {
+	seed maphash.Seed
+}
+
+// NewMapHasher creates a new MapHasher with a random seed.
+func NewMapHasher() *MapHasher {
+	return &MapHasher{seed: maphash.MakeSeed()}
+}
+
+// Hash implements Hasher.
+func (M *MapHasher) Hash(key []byte) uint64 {
+	var h maphash.Hash
+	h.SetSeed(M.seed)
+	h.Write(key)
+	return h.Sum64()
+}
+
+// HashString implements Hasher.
+func (M *MapHasher) HashString(key string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(M.seed)
+	h.WriteString(key)
+	return h.Sum64()
+}
+
+// RandomSeed returns a random seed for a Hasher.
+func RandomSeed() uint64 {
+	var h maphash.Hash
+	return h.Sum64()
+}
+
+const (
+	wyp0 = 0xa0761d6478bd642f
+	wyp1 = 0xe7037ed1a0b428db
+	wyp2 = 0x8ebc6af09c88c6e3
+	wyp3 = 0x589965cc75374cc3
+)
+
+type byteString interface {
+	~[]byte | ~string
+}
+
+func wymix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+func wyr8[T byteString](p T) uint64 {
+	return uint64(p[0]) | uint64(p[1])<<8 | uint64(p[2])<<16 | uint64(p[3])<<24 |
+		uint64(p[4])<<32 | uint64(p[5])<<40 | uint64(p[6])<<48 | uint64(p[7])<<56
+}
+
+func wyr4[T byteString](p T) uint64 {
+	return uint64(p[0]) | uint64(p[1])<<8 | uint64(p[2])<<16 | uint64(p[3])<<24
+}
+
+func wyr3[T byteString](p T, k int) uint64 {
+	return uint64(p[0])<<16 | uint64(p[k>>1])<<8 | uint64(p[k-1])
+}
+
+func wyhash[T byteString](key T, seed uint64) uint64 {
+	seed ^= wymix(seed^wyp0, wyp1)
+	l := len(key)
+	var a, b uint64
+	if l <= 16 {
+		if l >= 4 {
+			a = wyr4(key)<<32 | wyr4(key[(l>>3)<<2:])
+			b = wyr4(key[l-4:])<<32 | wyr4(key[l-4-((l>>3)<<2):])
+		} else if l > 0 {
+			a = wyr3(key, l)
+		}
+	} else {
+		p := key
+		i := l
+		if i > 48 {
+			see1, see2 := seed, seed
+			for i > 48 {
+				seed = wymix(wyr8(p)^wyp1, wyr8(p[8:])^seed)
+				see1 = wymix(wyr8(p[16:])^wyp2, wyr8(p[24:])^see1)
+				see2 = wymix(wyr8(p[32:])^wyp3, wyr8(p[40:])^see2)
+				p = p[48:]
+				i -= 48
+			}
+			seed ^= see1 ^ see2
+		}
+		for i > 16 {
+			seed = wymix(wyr8(p)^wyp1, wyr8(p[8:])^seed)
+			p = p[16:]
+			i -= 16
+		}
+		a = wyr8(key[l-16:])
+		b = wyr8(key[l-8:])
+	}
+	hi, lo := bits.Mul64(a^wyp1, b^seed)
+	return wymix(lo^wyp0^uint64(l), hi^wyp1)
+}
@@ -0,0 +1,73 @@
+package bigmap
+
+import (
+	"testing"
+)
+
+func BenchmarkFNVHasher_Long(b *testing.B) {
+	benchmarkHasher(b, FNVHasher{})
+}
+
+func BenchmarkWyHasher_Long(b *testing.B) {
+	benchmarkHasher(b, NewWyHasher())
+}
+
+func BenchmarkMapHasher_Long(b *testing.B) {
+	benchmarkHasher(b, NewMapHasher())
+}
+
+func benchmarkHasher(b *testing.B, hasher Hasher) {
+	key := RandomString(256)
+	b.SetBytes(int64(len(key)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hasher.Hash(key)
+	}
+}
+
+func TestHasher_HashString(t *testing.T) {
+	hashers := []Hasher{FNVHasher{}, NewWyHasher(), NewMapHasher()}
+	for _, hasher := range hashers {
+		for n := 0; n < 130; n++ {
+			key := RandomString(n)
+			if hasher.Hash(key) != hasher.HashString(string(key)) {
+				t.Fatalf("%T.Hash(%q) != HashString(%q)", hasher, key, key)
+			}
+		}
+	}
+}
+
+func TestWyHasher_seed(t *testing.T) {
+	key := []byte("some-key")
+	if (WyHasher{Seed: 1}).Hash(key) != (WyHasher{Seed: 1}).Hash(key) {
+		t.Fatalf("WyHasher with same seed hashed differently")
+	}
+	if (WyHasher{Seed: 1}).Hash(key) == (WyHasher{Seed: 2}).Hash(key) {
+		t.Fatalf("WyHasher with different seeds hashed equally")
+	}
+}
+
+func TestWyHasher_distribution(t *testing.T) {
+	hasher := NewWyHasher()
+	buckets := make([]int, 16)
+	n := 16 * 4096
+	for i := 0; i < n; i++ {
+		buckets[hasher.Hash(GenKey(i))%16]++
+	}
+	for i, cnt := range buckets {
+		if cnt < n/16*9/10 || cnt > n/16*11/10 {
+			t.Fatalf("bucket %d got %d keys, want about %d", i, cnt, n/16)
+		}
+	}
+}
+
+func TestBigMap_Hasher(t *testing.T) {
+	bigmap := New(100, Config{Hasher: NewMapHasher()})
+	keys := PopulateMap(1024, &bigmap)
+	for _, key := range keys {
+		if _, ok := bigmap.GetString(string(key)); !ok {
+			t.Fatalf("GetString(%q) got !ok, want ok", key)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package bigmap
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+const (
+	// maxIndexLevel is the maximum height of the skip list of an index.
+	maxIndexLevel = 24
+	// indexBranching is the inverse probability of a node
+	// to be promoted to the next level.
+	indexBranching = 4
+)
+
+// ErrNoIndex is returned by ordered scans of a map without Config.OrderedIndex.
+var ErrNoIndex = errors.New("bigmap: ordered index not enabled")
+
+// keyIndex is the ordered index of the keys of a shard.
+// The keys are kept in a skip list and located by their hash
+// so they can be removed when only the hash is known.
+// The index is protected by the lock of its shard.
+type keyIndex struct {
+	head  indexNode
+	level int
+	nodes map[uint64]*indexNode
+	seed  uint64
+}
+
+type indexNode struct {
+	key  []byte
+	hash uint64
+	next []*indexNode
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		head:  indexNode{next: make([]*indexNode, maxIndexLevel)},
+		level: 1,
+		nodes: make(map[uint64]*indexNode),
+		seed:  Offset64,
+	}
+}
+
+// randomLevel returns the height of a new node.
+func (I *keyIndex) randomLevel() int {
+	level := 1
+	for level < maxIndexLevel {
+		I.seed ^= I.seed << 13
+		I.seed ^= I.seed >> 7
+		I.seed ^= I.seed << 17
+		if I.seed%indexBranching != 0 {
+			break
+		}
+		level++
+	}
+	return level
+}
+
+// path fills update with the last node before the key on every level.
+func (I *keyIndex) path(key []byte, update *[maxIndexLevel]*indexNode) {
+	node := &I.head
+	for level := I.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && bytes.Compare(node.next[level].key, key) < 0 {
+			node = node.next[level]
+		}
+		update[level] = node
+	}
+}
+
+// add indexes the key under its hash.
+// A different key of the same hash is replaced.
+func (I *keyIndex) add(hash uint64, key []byte) {
+	if node, ok := I.nodes[hash]; ok {
+		if bytes.Equal(node.key, key) {
+			return
+		}
+		I.remove(hash)
+	}
+	var update [maxIndexLevel]*indexNode
+	I.path(key, &update)
+	level := I.randomLevel()
+	for ; I.level < level; I.level++ {
+		update[I.level] = &I.head
+	}
+	node := &indexNode{
+		key:  append([]byte(nil), key...),
+		hash: hash,
+		next: make([]*indexNode, level),
+	}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	I.nodes[hash] = node
+}
+
+// remove removes the key of the hash from the index.
+func (I *keyIndex) remove(hash uint64) {
+	node, ok := I.nodes[hash]
+	if !ok {
+		return
+	}
+	delete(I.nodes, hash)
+	var update [maxIndexLevel]*indexNode
+	I.path(node.key, &update)
+	for i := 0; i < len(node.next); i++ {
+		if update[i].next[i] == node {
+			update[i].next[i] = node.next[i]
+		}
+	}
+	for I.level > 1 && I.head.next[I.level-1] == nil {
+		I.level--
+	}
+}
+
+// key returns the key indexed under the hash.
+func (I *keyIndex) key(hash uint64) []byte {
+	if node, ok := I.nodes[hash]; ok {
+		return node.key
+	}
+	return nil
+}
+
+// clear removes all keys.
+func (I *keyIndex) clear() {
+	for i := range I.head.next {
+		I.head.next[i] = nil
+	}
+	I.level = 1
+	I.nodes = make(map[uint64]*indexNode)
+}
+
+// seek returns the first node whose key is at least the key.
+func (I *keyIndex) seek(key []byte) *indexNode {
+	var update [maxIndexLevel]*indexNode
+	I.path(key, &update)
+	return update[0].next[0]
+}
+
+// ascend calls fn for the keys from start up to but excluding end
+// in order until fn returns false. A nil end has no upper bound.
+func (I *keyIndex) ascend(start, end []byte, fn func(hash uint64, key []byte) bool) {
+	for node := I.seek(start); node != nil; node = node.next[0] {
+		if end != nil && bytes.Compare(node.key, end) >= 0 {
+			return
+		}
+		if !fn(node.hash, node.key) {
+			return
+		}
+	}
+}
+
+// scanKeys appends up to limit live keys of the shard from start up to
+// but excluding end which are larger than after to keys.
+// A nil after starts at start, a limit of 0 or less has no limit.
+// The scan seeks to the larger of start and after, so a page costs
+// the seek and its keys. The shard is locked exclusively while
+// scanning as its optimistic lock has no shared mode for readers
+// which need a consistent view of the index.
+func (S *Shard) scanKeys(keys [][]byte, start, end, after []byte, limit int) [][]byte {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if S.index == nil || S.isRetired() {
+		return keys
+	}
+	from := start
+	if after != nil && bytes.Compare(after, start) >= 0 {
+		from = after
+	}
+	n := 0
+	S.index.ascend(from, end, func(hash uint64, key []byte) bool {
+		if after != nil && bytes.Equal(key, after) {
+			return true
+		}
+		if !S.alive(hash) {
+			return true
+		}
+		keys = append(keys, append([]byte(nil), key...))
+		n++
+		return limit <= 0 || n < limit
+	})
+	return keys
+}
+
+// alive reports whether the item is contained and not expired
+// without removing or promoting it.
+// The shard must be locked.
+func (S *Shard) alive(key uint64) bool {
+	if ptr, ok := S.ptrs.Get(key); ok {
+		_, deadline := S.slot(ptr)
+		return !S.expired(deadline)
+	}
+	if S.tier != nil {
+		if record, ok := S.tier.lookup(key); ok {
+			return !S.expired(record.deadline)
+		}
+	}
+	return false
+}
+
+// indexKey adds the key of the item to the ordered index if it is known.
+// The shard must be locked.
+func (S *Shard) indexKey(hash uint64, key []byte) {
+	if S.index != nil && key != nil {
+		S.index.add(hash, key)
+	}
+}
+
+// indexed returns the key of the item from the ordered index or nil.
+// The shard must be locked.
+func (S *Shard) indexed(hash uint64) []byte {
+	if S.index == nil {
+		return nil
+	}
+	return S.index.key(hash)
+}
+
+// unindex removes the item from the ordered index.
+// The shard must be locked.
+func (S *Shard) unindex(key uint64) {
+	if S.index != nil {
+		S.index.remove(key)
+	}
+}
+
+// ScanPrefix returns the keys with the prefix in ascending order.
+// See BigMap.ScanRange for the pagination with after and limit.
+func (B *BigMap) ScanPrefix(prefix, after []byte, limit int) ([][]byte, error) {
+	return B.ScanRange(prefix, prefixEnd(prefix), after, limit)
+}
+
+// ScanRange returns the keys from start up to but excluding end in
+// ascending order. A nil end has no upper bound.
+// At most limit keys are returned, a limit of 0 or less returns all.
+// The next page starts after the last returned key which is passed as
+// after, a nil after starts at start.
+//
+// Only the items written with their key, e.g. by Put, PutString,
+// Update or GetOrLoad, are indexed. ErrNoIndex is returned
+// without Config.OrderedIndex.
+// Reshard, Clear and Reset wait until ScanRange returns.
+func (B *BigMap) ScanRange(start, end, after []byte, limit int) ([][]byte, error) {
+	if !B.config.OrderedIndex {
+		return nil, ErrNoIndex
+	}
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	var keys [][]byte
+	for _, s := range B.loadTable().shards {
+		keys = s.scanKeys(keys, start, end, after, limit)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// prefixEnd returns the smallest key larger than all keys with
+// the prefix or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// origin returns the key passed on to the shards for the string key.
+// The string is only converted if the index or a watcher needs it.
+func (B *BigMap) origin(key string) []byte {
+	if B.config.OrderedIndex {
+		return []byte(key)
+	}
+	return B.watch.origin(key)
+}
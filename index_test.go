@@ -0,0 +1,105 @@
+package bigmap
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestKeyIndex(t *testing.T) {
+	index := newKeyIndex()
+	keys := make(map[uint64]string)
+	for i := 0; i < 1000; i++ {
+		hash := uint64(rand.Intn(300))
+		if rand.Intn(3) == 0 {
+			index.remove(hash)
+			delete(keys, hash)
+			continue
+		}
+		key := fmt.Sprintf("key-%d-%d", hash, rand.Intn(2))
+		index.add(hash, []byte(key))
+		keys[hash] = key
+	}
+	want := make([]string, 0, len(keys))
+	for _, key := range keys {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+	var got []string
+	index.ascend(nil, nil, func(hash uint64, key []byte) bool {
+		if keys[hash] != string(key) {
+			t.Fatalf("key %q indexed under the hash of %q", key, keys[hash])
+		}
+		got = append(got, string(key))
+		return true
+	})
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ascend got %d keys, want %d in order", len(got), len(want))
+	}
+	if len(index.nodes) != len(keys) {
+		t.Fatalf("index holds %d nodes, want %d", len(index.nodes), len(keys))
+	}
+}
+
+func TestBigMap_ScanRange(t *testing.T) {
+	bm := New(16, Config{Shards: 4, OrderedIndex: true})
+	for i := 0; i < 100; i++ {
+		bm.Put([]byte(fmt.Sprintf("user:%03d", i)), []byte("value"))
+	}
+	bm.PutString("group:1", []byte("value"))
+	bm.PutHash(1, []byte("not indexed"), 0)
+
+	var page [][]byte
+	var all []string
+	for {
+		var after []byte
+		if len(page) != 0 {
+			after = page[len(page)-1]
+		}
+		var err error
+		if page, err = bm.ScanPrefix([]byte("user:"), after, 30); err != nil {
+			t.Fatalf("ScanPrefix: %v", err)
+		}
+		for _, key := range page {
+			all = append(all, string(key))
+		}
+		if len(page) < 30 {
+			break
+		}
+	}
+	if len(all) != 100 || !sort.StringsAreSorted(all) || all[0] != "user:000" {
+		t.Fatalf("paged ScanPrefix got %d keys: %v", len(all), all)
+	}
+
+	if keys, _ := bm.ScanRange([]byte("user:050"), nil, []byte("user:0"), 2); fmt.Sprintf("%s", keys) != "[user:050 user:051]" {
+		t.Fatalf("ScanRange after a key before start got %s", keys)
+	}
+	if keys, _ := bm.ScanRange([]byte("user:050"), nil, []byte("user:0555"), 2); fmt.Sprintf("%s", keys) != "[user:056 user:057]" {
+		t.Fatalf("ScanRange after a missing key got %s", keys)
+	}
+	bm.Delete([]byte("user:010"))
+	bm.PutTTL([]byte("user:011"), []byte("value"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	keys, _ := bm.ScanRange([]byte("user:009"), []byte("user:013"), nil, 0)
+	if fmt.Sprintf("%s", keys) != "[user:009 user:012]" {
+		t.Fatalf("ScanRange got %s", keys)
+	}
+	if err := bm.Reshard(3); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	keys, _ = bm.ScanRange(nil, nil, nil, 0)
+	if len(keys) != 99 || string(keys[0]) != "group:1" {
+		t.Fatalf("ScanRange after reshard got %d keys", len(keys))
+	}
+	bm.Clear()
+	if keys, _ := bm.ScanRange(nil, nil, nil, 0); len(keys) != 0 {
+		t.Fatalf("ScanRange after Clear got %d keys", len(keys))
+	}
+
+	plain := New(16)
+	if _, err := plain.ScanPrefix(nil, nil, 0); err != ErrNoIndex {
+		t.Fatalf("ScanPrefix without index got %v", err)
+	}
+}
@@ -115,6 +115,78 @@ func (I *IntMap) Delete(key KeyType) (ValType, bool) {
 	}
 }
 
+// Len returns the amount of items in this map.
+func (I *IntMap) Len() int {
+	if I.freeSet {
+		return int(I.size) + 1
+	}
+	return int(I.size)
+}
+
+// Cap returns the amount of slots of this map.
+func (I *IntMap) Cap() int {
+	return int(I.capacity)
+}
+
+// LoadFactor returns the share of slots which are taken.
+func (I *IntMap) LoadFactor() float64 {
+	return float64(I.size) / float64(I.capacity)
+}
+
+// ProbeHistogram counts the keys by their probe length, the distance
+// from the slot they hash to, into histogram.
+// Keys with a probe length of len(histogram)-1 or more are counted in
+// the last bucket.
+func (I *IntMap) ProbeHistogram(histogram []int) {
+	if len(histogram) == 0 {
+		return
+	}
+	last := len(histogram) - 1
+	for i := KeyType(0); i < I.dataSize && i < KeyType(len(I.data)); i += 2 {
+		key := I.data[i]
+		if key == Free {
+			continue
+		}
+		probes := int(((i - I.index(key)) & I.dataMask) >> 1)
+		if probes > last {
+			probes = last
+		}
+		histogram[probes]++
+	}
+}
+
+// Range calls fn for every item in this map
+// until fn returns false.
+// The map must not be modified while ranging over it.
+func (I *IntMap) Range(fn func(key KeyType, val ValType) bool) {
+	if I.freeSet && !fn(Free, I.freeVal) {
+		return
+	}
+	for i := KeyType(0); i < I.dataSize; i += 2 {
+		key := I.data[i]
+		if key != Free && !fn(key, ValType(I.data[i+1])) {
+			return
+		}
+	}
+}
+
+// Clear removes all items from this map but keeps
+// the allocated capacity.
+func (I *IntMap) Clear() {
+	for i := range I.data {
+		I.data[i] = Free
+	}
+	I.freeSet = false
+	I.freeVal = 0
+	I.size = 0
+}
+
+// Reset removes all items from this map and releases
+// the allocated capacity back to the initial size.
+func (I *IntMap) Reset() {
+	*I = New()
+}
+
 func (I *IntMap) unshift(current KeyType) {
 	var key KeyType
 	for {
@@ -50,3 +50,34 @@ func TestIntMap_Delete(t *testing.T) {
 		t.Errorf("IntMap.Delete() got = %v,%v, want %v,%v", v, ok, 0, false)
 	}
 }
+
+func TestIntMap_Clear(t *testing.T) {
+	m := filled(200)
+	m.Put(Free, 1)
+	m.Clear()
+	for i := KeyType(0); i < 200; i++ {
+		if v, ok := m.Get(i); ok {
+			t.Errorf("IntMap.Get() after Clear got = %v,%v, want %v,%v", v, ok, 0, false)
+		}
+	}
+	m.Put(3, 4)
+	if v, ok := m.Get(3); v != 4 || !ok {
+		t.Errorf("IntMap.Get() got = %v,%v, want %v,%v", v, ok, 4, true)
+	}
+}
+
+func TestIntMap_ProbeHistogram(t *testing.T) {
+	m := filled(200) // the Free key 0 isn't stored in the table
+	histogram := make([]int, 4)
+	m.ProbeHistogram(histogram)
+	total := 0
+	for _, n := range histogram {
+		total += n
+	}
+	if total != 199 || histogram[0] == 0 {
+		t.Errorf("IntMap.ProbeHistogram() got = %v, want 199 keys", histogram)
+	}
+	if lf := m.LoadFactor(); lf <= 0 || lf >= 1 || lf != 199/float64(m.Cap()) {
+		t.Errorf("IntMap.LoadFactor() got = %v", lf)
+	}
+}
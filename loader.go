@@ -0,0 +1,184 @@
+package bigmap
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// maxLoadFailures is the amount of failed loads a shard remembers
+// for Config.LoadErrorTTL before it forgets the oldest ones.
+const maxLoadFailures = 1024
+
+// ErrLoaderPanicked is returned to the waiters of a load
+// whose loader panicked.
+var ErrLoaderPanicked = errors.New("bigmap: loader panicked")
+
+// Loader loads the value of a missing item.
+// It returns the value, its time to live and an error if
+// the value couldn't be loaded. A ttl smaller or equal to 0
+// never expires.
+type Loader func() ([]byte, time.Duration, error)
+
+// KeyLoader loads the value of the key like a Loader.
+type KeyLoader func(key []byte) ([]byte, time.Duration, error)
+
+// loadGroup coalesces the loads of the items of a shard.
+type loadGroup struct {
+	lock     sync.Mutex
+	flights  map[uint64]*flight
+	failures map[uint64]failure
+}
+
+// flight is a running load which other callers wait for.
+type flight struct {
+	done chan struct{}
+	val  []byte
+	err  error
+}
+
+// failure is a cached error of a loader.
+type failure struct {
+	err      error
+	deadline int64
+}
+
+// GetOrLoad returns the item of the key or loads it with the loader
+// if it isn't contained and stores it with the returned ttl.
+//
+// Concurrent calls for the same key share a single call of the loader
+// and return its result. If Config.LoadErrorTTL is set the errors of
+// loaders are returned for the following calls of the key until it
+// passed without calling the loader again.
+//
+// If the loaded value can't be stored it is returned along
+// with the error of the put.
+func (B *BigMap) GetOrLoad(key []byte, loader Loader) ([]byte, error) {
+	h := B.hasher.Hash(key)
+	if val, ok := B.get(h); ok {
+		if B.config.RefreshLoader != nil && B.refreshDue(h) {
+			B.refresh(h, key)
+		}
+		return val, nil
+	}
+	group := &B.loadTable().shardOf(h).loads
+	f, leader, err := group.join(h)
+	if err != nil {
+		return nil, err
+	}
+	if !leader {
+		<-f.done
+		return append([]byte(nil), f.val...), f.err
+	}
+	defer B.land(group, h, f)
+	// the item may have been stored by a load which
+	// finished after the first lookup
+	if val, ok := B.get(h); ok {
+		f.val, f.err = val, nil
+		return val, nil
+	}
+	B.load(h, key, f, loader)
+	return f.val, f.err
+}
+
+// refreshDue reports whether the item has to be reloaded.
+func (B *BigMap) refreshDue(h uint64) (due bool) {
+	B.read(h, func(shard *Shard) (ok bool) {
+		due, ok = shard.refreshDue(h, B.config.RefreshAhead)
+		return ok
+	})
+	return due
+}
+
+// refresh reloads the item with the RefreshLoader in the background
+// unless it is already loaded or its last load failed recently.
+// A panicking RefreshLoader fails the load with ErrLoaderPanicked.
+func (B *BigMap) refresh(h uint64, key []byte) {
+	group := &B.loadTable().shardOf(h).loads
+	f, leader, err := group.join(h)
+	if err != nil || !leader {
+		return
+	}
+	key = append([]byte(nil), key...)
+	go func() {
+		defer B.land(group, h, f)
+		// nobody can handle the panic of a background load,
+		// the flight keeps ErrLoaderPanicked.
+		defer func() {
+			recover()
+		}()
+		B.load(h, key, f, func() ([]byte, time.Duration, error) {
+			return B.config.RefreshLoader(key)
+		})
+	}()
+}
+
+// load calls the loader and stores its result.
+func (B *BigMap) load(h uint64, key []byte, f *flight, loader Loader) {
+	val, ttl, err := loader()
+	if err != nil {
+		f.err = err
+		return
+	}
+	f.val, f.err = val, B.put(h, key, val, deadlineOf(ttl))
+}
+
+// land finishes the flight and wakes its waiters.
+func (B *BigMap) land(group *loadGroup, h uint64, f *flight) {
+	group.lock.Lock()
+	delete(group.flights, h)
+	if f.err != nil && f.val == nil && B.config.LoadErrorTTL > 0 {
+		group.fail(h, f.err, deadlineOf(B.config.LoadErrorTTL))
+	}
+	group.lock.Unlock()
+	close(f.done)
+}
+
+// join returns the running flight of the hash or starts a new
+// one, in which case the caller is the leader and has to land it.
+// The cached error is returned if the last load failed recently.
+func (G *loadGroup) join(h uint64) (*flight, bool, error) {
+	G.lock.Lock()
+	defer G.lock.Unlock()
+	if f, ok := G.failures[h]; ok {
+		if !expired(f.deadline) {
+			return nil, false, f.err
+		}
+		delete(G.failures, h)
+	}
+	if f, ok := G.flights[h]; ok {
+		return f, false, nil
+	}
+	if G.flights == nil {
+		G.flights = make(map[uint64]*flight)
+	}
+	f := &flight{done: make(chan struct{}), err: ErrLoaderPanicked}
+	G.flights[h] = f
+	return f, true, nil
+}
+
+// fail caches the error of a loader.
+// The group must be locked.
+func (G *loadGroup) fail(h uint64, err error, deadline int64) {
+	if G.failures == nil {
+		G.failures = make(map[uint64]failure)
+	}
+	if len(G.failures) >= maxLoadFailures {
+		for key, f := range G.failures {
+			if expired(f.deadline) {
+				delete(G.failures, key)
+			}
+		}
+	}
+	if len(G.failures) >= maxLoadFailures {
+		var oldest uint64
+		first := true
+		for key, f := range G.failures {
+			if first || f.deadline < G.failures[oldest].deadline {
+				oldest, first = key, false
+			}
+		}
+		delete(G.failures, oldest)
+	}
+	G.failures[h] = failure{err: err, deadline: deadline}
+}
@@ -0,0 +1,195 @@
+package bigmap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBigMap_GetOrLoad(t *testing.T) {
+	if raceEnabled {
+		t.Skip("optimistic reads of concurrent writes are reported by the race detector")
+	}
+	bm := New(16, Config{Shards: 4})
+	var calls int32
+	release := make(chan struct{})
+	loader := func() ([]byte, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("loaded"), time.Hour, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			val, err := bm.GetOrLoad([]byte("key"), loader)
+			if err != nil {
+				t.Errorf("GetOrLoad: %v", err)
+			}
+			results[i] = val
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected a single load, got %d", calls)
+	}
+	for _, val := range results {
+		if string(val) != "loaded" {
+			t.Fatalf("GetOrLoad got %q, want %q", val, "loaded")
+		}
+	}
+	if ttl, ok := bm.TTL([]byte("key")); !ok || ttl <= 0 {
+		t.Fatalf("expected the loaded item to be stored with its ttl, got %v, %v", ttl, ok)
+	}
+	val, err := bm.GetOrLoad([]byte("key"), func() ([]byte, time.Duration, error) {
+		t.Fatalf("loader of a contained item called")
+		return nil, 0, nil
+	})
+	if err != nil || string(val) != "loaded" {
+		t.Fatalf("GetOrLoad got %q, %v", val, err)
+	}
+
+	tooLarge := make([]byte, 32)
+	val, err = bm.GetOrLoad([]byte("large"), func() ([]byte, time.Duration, error) {
+		return tooLarge, 0, nil
+	})
+	if err == nil || len(val) != 32 {
+		t.Fatalf("expected the value with the error of the put, got %d bytes, %v", len(val), err)
+	}
+}
+
+func TestBigMap_GetOrLoadErrors(t *testing.T) {
+	bm := New(16, Config{Shards: 4, LoadErrorTTL: 20 * time.Millisecond})
+	failed := errors.New("failed")
+	calls := 0
+	loader := func() ([]byte, time.Duration, error) {
+		calls++
+		if calls == 1 {
+			return nil, 0, failed
+		}
+		return []byte("loaded"), 0, nil
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := bm.GetOrLoad([]byte("key"), loader); err != failed {
+			t.Fatalf("GetOrLoad got %v, want %v", err, failed)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected the error to be cached, got %d loads", calls)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if val, err := bm.GetOrLoad([]byte("key"), loader); err != nil || string(val) != "loaded" {
+		t.Fatalf("GetOrLoad got %q, %v after the error expired", val, err)
+	}
+
+	uncached := New(16, Config{Shards: 4})
+	calls = 0
+	uncached.GetOrLoad([]byte("key"), loader)
+	if val, err := uncached.GetOrLoad([]byte("key"), loader); err != nil || string(val) != "loaded" {
+		t.Fatalf("GetOrLoad got %q, %v without LoadErrorTTL", val, err)
+	}
+}
+
+type countingLoader struct {
+	calls int32
+	val   string
+	err   error
+}
+
+func (C *countingLoader) load(key []byte) ([]byte, time.Duration, error) {
+	atomic.AddInt32(&C.calls, 1)
+	return []byte(C.val), time.Hour, C.err
+}
+
+func TestBigMap_RefreshAhead(t *testing.T) {
+	if raceEnabled {
+		t.Skip("optimistic reads of concurrent writes are reported by the race detector")
+	}
+	loader := &countingLoader{val: "fresh"}
+	bm := New(16, Config{Shards: 4, RefreshLoader: loader.load, RefreshAhead: 0.5})
+	bm.PutTTL([]byte("key"), []byte("old"), 100*time.Millisecond)
+	if val, _ := bm.Get([]byte("key")); string(val) != "old" || atomic.LoadInt32(&loader.calls) != 0 {
+		t.Fatalf("Get before the refresh got %q with %d loads", val, loader.calls)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if val, _ := bm.GetString("key"); string(val) != "old" {
+		t.Fatalf("Get due for a refresh got %q, want the current value", val)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if val, _ := bm.Get([]byte("key")); string(val) == "fresh" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("item wasn't refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if calls := atomic.LoadInt32(&loader.calls); calls != 1 {
+		t.Fatalf("expected a single reload, got %d", calls)
+	}
+	if ttl, _ := bm.TTL([]byte("key")); ttl <= time.Minute {
+		t.Fatalf("expected the ttl of the reload, got %v", ttl)
+	}
+	if stats := bm.Stats(); stats.Total.UsedSlots != 1 {
+		t.Fatalf("expected a single slot, got %d", stats.Total.UsedSlots)
+	}
+}
+
+func TestBigMap_RefreshPanic(t *testing.T) {
+	var calls int32
+	bm := New(16, Config{
+		Shards:       4,
+		StaleGrace:   time.Hour,
+		LoadErrorTTL: time.Hour,
+		RefreshLoader: func(key []byte) ([]byte, time.Duration, error) {
+			atomic.AddInt32(&calls, 1)
+			panic("refresh failed")
+		},
+	})
+	bm.PutTTL([]byte("key"), []byte("stale"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	bm.Get([]byte("key"))
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	// the failed refresh is remembered for the LoadErrorTTL
+	if val, ok := bm.Get([]byte("key")); !ok || string(val) != "stale" {
+		t.Fatalf("Get after a panicking refresh got %q, %v", val, ok)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected the panic to be cached, got %d loads", n)
+	}
+}
+
+func TestBigMap_StaleGrace(t *testing.T) {
+	loader := &countingLoader{err: errors.New("failed")}
+	bm := New(16, Config{Shards: 4, RefreshLoader: loader.load, StaleGrace: time.Hour, LoadErrorTTL: time.Hour})
+	bm.PutTTL([]byte("key"), []byte("stale"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if val, ok := bm.Get([]byte("key")); !ok || string(val) != "stale" {
+			t.Fatalf("Get in the grace period got %q, %v", val, ok)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if calls := atomic.LoadInt32(&loader.calls); calls != 1 {
+		t.Fatalf("expected a single failing reload, got %d", calls)
+	}
+
+	plain := New(16, Config{Shards: 4, RefreshLoader: loader.load})
+	plain.PutTTL([]byte("key"), []byte("stale"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := plain.Get([]byte("key")); ok {
+		t.Fatalf("expected the item to expire without grace period")
+	}
+}
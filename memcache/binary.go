@@ -0,0 +1,300 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	magicRequest  byte = 0x80
+	magicResponse byte = 0x81
+	headerLength       = 24
+	// noInitial is the exptime of increments which must not
+	// create missing items.
+	noInitial = 0xffffffff
+)
+
+// Opcodes of the binary protocol
+const (
+	opGet       byte = 0x00
+	opSet       byte = 0x01
+	opAdd       byte = 0x02
+	opReplace   byte = 0x03
+	opDelete    byte = 0x04
+	opIncrement byte = 0x05
+	opDecrement byte = 0x06
+	opQuit      byte = 0x07
+	opFlush     byte = 0x08
+	opGetQ      byte = 0x09
+	opNoop      byte = 0x0a
+	opVersion   byte = 0x0b
+	opGetK      byte = 0x0c
+	opGetKQ     byte = 0x0d
+	opStat      byte = 0x10
+	opSetQ      byte = 0x11
+	opAddQ      byte = 0x12
+	opReplaceQ  byte = 0x13
+	opDeleteQ   byte = 0x14
+	opIncrQ     byte = 0x15
+	opDecrQ     byte = 0x16
+	opQuitQ     byte = 0x17
+	opFlushQ    byte = 0x18
+	opTouch     byte = 0x1c
+)
+
+// Response statuses of the binary protocol
+const (
+	statusOK              uint16 = 0x00
+	statusKeyNotFound     uint16 = 0x01
+	statusKeyExists       uint16 = 0x02
+	statusValueTooLarge   uint16 = 0x03
+	statusInvalidArgs     uint16 = 0x04
+	statusItemNotStored   uint16 = 0x05
+	statusNonNumericValue uint16 = 0x06
+	statusInternalError   uint16 = 0x84
+	statusUnknownCommand  uint16 = 0x81
+)
+
+var binaryStatus = [...]uint16{
+	statusStored:     statusOK,
+	statusNotStored:  statusItemNotStored,
+	statusExists:     statusKeyExists,
+	statusNotFound:   statusKeyNotFound,
+	statusTooLarge:   statusValueTooLarge,
+	statusNonNumeric: statusNonNumericValue,
+	statusError:      statusInternalError,
+}
+
+// request is a decoded request of the binary protocol.
+// extras, key and value point into the body buffer.
+type request struct {
+	opcode byte
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+// serveBinary serves the binary protocol until the connection
+// fails or the client quits.
+func (c *client) serveBinary() {
+	var header [headerLength]byte
+	for !c.quit {
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return
+		}
+		if header[0] != magicRequest {
+			return
+		}
+		keyLength := int(binary.BigEndian.Uint16(header[2:]))
+		extrasLength := int(header[4])
+		bodyLength := int64(binary.BigEndian.Uint32(header[8:]))
+		req := request{
+			opcode: header[1],
+			opaque: binary.BigEndian.Uint32(header[12:]),
+			cas:    binary.BigEndian.Uint64(header[16:]),
+		}
+		if int64(keyLength+extrasLength) > bodyLength {
+			return
+		}
+		if uint64(bodyLength) > c.server.bigmap.EntrySize()+MaxKeyLength+0xff {
+			if _, err := io.CopyN(io.Discard, c.reader, bodyLength); err != nil {
+				return
+			}
+			c.writeResponse(req, statusValueTooLarge, 0, nil, nil, []byte("Too large."))
+		} else {
+			if int64(cap(c.data)) < bodyLength {
+				c.data = make([]byte, bodyLength)
+			}
+			c.data = c.data[:bodyLength]
+			if _, err := io.ReadFull(c.reader, c.data); err != nil {
+				return
+			}
+			req.extras = c.data[:extrasLength]
+			req.key = c.data[extrasLength : extrasLength+keyLength]
+			req.value = c.data[extrasLength+keyLength:]
+			c.binary(req)
+		}
+		if c.reader.Buffered() == 0 {
+			if c.writer.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// binary executes a single request.
+func (c *client) binary(req request) {
+	if len(req.key) > MaxKeyLength {
+		c.writeStatus(req, statusInvalidArgs)
+		return
+	}
+	switch req.opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		c.binaryGet(req)
+	case opSet, opSetQ:
+		c.binaryStore(req, modeSet)
+	case opAdd, opAddQ:
+		c.binaryStore(req, modeAdd)
+	case opReplace, opReplaceQ:
+		c.binaryStore(req, modeReplace)
+	case opDelete, opDeleteQ:
+		if len(req.extras) != 0 || len(req.value) != 0 {
+			c.writeStatus(req, statusInvalidArgs)
+			return
+		}
+		result := binaryStatus[c.delete(req.key, req.cas)]
+		if result != statusOK || req.opcode != opDeleteQ {
+			c.writeStatus(req, result)
+		}
+	case opIncrement, opIncrQ, opDecrement, opDecrQ:
+		c.binaryIncr(req)
+	case opTouch:
+		if len(req.extras) != 4 || len(req.value) != 0 {
+			c.writeStatus(req, statusInvalidArgs)
+			return
+		}
+		exptime := int64(binary.BigEndian.Uint32(req.extras))
+		c.writeStatus(req, binaryStatus[c.touch(req.key, exptime)])
+	case opFlush, opFlushQ:
+		var delay uint32
+		if len(req.extras) == 4 {
+			delay = binary.BigEndian.Uint32(req.extras)
+		}
+		c.server.flush(time.Duration(delay) * time.Second)
+		if req.opcode == opFlush {
+			c.writeStatus(req, statusOK)
+		}
+	case opNoop:
+		c.writeStatus(req, statusOK)
+	case opVersion:
+		c.writeResponse(req, statusOK, 0, nil, nil, []byte(Version))
+	case opStat:
+		for _, stat := range c.server.statistics() {
+			c.writeResponse(req, statusOK, 0, nil, []byte(stat[0]), []byte(stat[1]))
+		}
+		c.writeStatus(req, statusOK)
+	case opQuit:
+		c.writeStatus(req, statusOK)
+		c.quit = true
+	case opQuitQ:
+		c.quit = true
+	default:
+		c.writeResponse(req, statusUnknownCommand, 0, nil, nil, []byte("Unknown command"))
+	}
+}
+
+func (c *client) binaryGet(req request) {
+	atomic.AddUint64(&c.server.stats.cmdGet, 1)
+	quiet := req.opcode == opGetQ || req.opcode == opGetKQ
+	withKey := req.opcode == opGetK || req.opcode == opGetKQ
+	var key []byte
+	if withKey {
+		key = req.key
+	}
+	it, ok := c.get(req.key)
+	if !ok {
+		if !quiet {
+			c.writeResponse(req, statusKeyNotFound, 0, nil, key, []byte("Not found"))
+		}
+		return
+	}
+	var extras [4]byte
+	binary.BigEndian.PutUint32(extras[:], it.flags)
+	c.writeResponse(req, statusOK, it.cas, extras[:], key, it.value)
+}
+
+// binaryStore executes set, add and replace.
+// Set and replace with a cas value behave like the text protocol cas.
+func (c *client) binaryStore(req request, mode storeMode) {
+	if len(req.extras) != 8 {
+		c.writeStatus(req, statusInvalidArgs)
+		return
+	}
+	if req.cas != 0 {
+		if mode == modeAdd {
+			c.writeStatus(req, statusInvalidArgs)
+			return
+		}
+		mode = modeCAS
+	}
+	flags := binary.BigEndian.Uint32(req.extras)
+	exptime := int64(binary.BigEndian.Uint32(req.extras[4:]))
+	result, cas := c.store(mode, req.key, flags, exptime, req.value, req.cas)
+	quiet := req.opcode == opSetQ || req.opcode == opAddQ || req.opcode == opReplaceQ
+	if result == statusStored && quiet {
+		return
+	}
+	if result == statusError {
+		c.writeError(req)
+		return
+	}
+	c.writeResponse(req, binaryStatus[result], cas, nil, nil, nil)
+}
+
+// binaryIncr executes increment and decrement.
+// Missing items are created with the initial value
+// unless the exptime is 0xffffffff.
+func (c *client) binaryIncr(req request) {
+	if len(req.extras) != 20 || len(req.value) != 0 {
+		c.writeStatus(req, statusInvalidArgs)
+		return
+	}
+	delta := binary.BigEndian.Uint64(req.extras)
+	initial := binary.BigEndian.Uint64(req.extras[8:])
+	exptime := binary.BigEndian.Uint32(req.extras[16:])
+	decr := req.opcode == opDecrement || req.opcode == opDecrQ
+	result, value, cas := c.incr(req.key, delta, decr)
+	for result == statusNotFound && exptime != noInitial {
+		c.number = strconv.AppendUint(c.number[:0], initial, 10)
+		if result, cas = c.store(modeAdd, req.key, 0, int64(exptime), c.number, 0); result != statusNotStored {
+			value = initial
+			break
+		}
+		result, value, cas = c.incr(req.key, delta, decr)
+	}
+	quiet := req.opcode == opIncrQ || req.opcode == opDecrQ
+	if result == statusStored && quiet {
+		return
+	}
+	if result == statusError {
+		c.writeError(req)
+		return
+	}
+	if result != statusStored {
+		c.writeStatus(req, binaryStatus[result])
+		return
+	}
+	var body [8]byte
+	binary.BigEndian.PutUint64(body[:], value)
+	c.writeResponse(req, statusOK, cas, nil, nil, body[:])
+}
+
+func (c *client) writeStatus(req request, status uint16) {
+	c.writeResponse(req, status, 0, nil, nil, nil)
+}
+
+// writeError answers with the error of the last failed write.
+func (c *client) writeError(req request) {
+	c.writeResponse(req, statusInternalError, 0, nil, nil, []byte(c.err.Error()))
+}
+
+func (c *client) writeResponse(req request, status uint16, cas uint64, extras, key, value []byte) {
+	var header [headerLength]byte
+	header[0] = magicResponse
+	header[1] = req.opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(header[6:], status)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:], req.opaque)
+	binary.BigEndian.PutUint64(header[16:], cas)
+	c.writer.Write(header[:])
+	c.writer.Write(extras)
+	c.writer.Write(key)
+	c.writer.Write(value)
+}
@@ -0,0 +1,590 @@
+// Package memcache serves a BigMap over the memcached
+// text and binary protocols.
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+const (
+	// MaxKeyLength is the maximum length of a key allowed by memcached.
+	MaxKeyLength = 250
+	// Version is reported by the version and stats commands.
+	Version = "1.6.0-bigmap"
+	// relativeExptime is the largest exptime which is relative to now.
+	// Larger exptimes are absolute unix timestamps.
+	relativeExptime = 60 * 60 * 24 * 30
+	// itemHeader is the size of the flags and the cas of an item.
+	itemHeader = 4 + 8
+)
+
+// ErrServerClosed is returned by Serve after the server was closed.
+var ErrServerClosed = errors.New("memcache: server closed")
+
+// EntrySize returns the entrysize a BigMap requires to store
+// items with values of the given maximum size.
+func EntrySize(maxValue int) uint64 {
+	return uint64(binary.MaxVarintLen64 + MaxKeyLength + itemHeader + maxValue)
+}
+
+// Server serves a BigMap over the memcached text and binary
+// protocols. The protocol is detected per connection.
+//
+// Items are stored alongside their key, flags and cas value
+// therefore the entrysize of the map must cover them.
+// See EntrySize
+type Server struct {
+	bigmap  *bigmap.BigMap
+	started time.Time
+	cas     uint64
+	stats   serverStats
+
+	lock       sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+	flushTimer *time.Timer // the pending delayed flush
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+type serverStats struct {
+	currConnections  int64
+	totalConnections uint64
+	cmdGet           uint64
+	cmdSet           uint64
+	cmdTouch         uint64
+	getHits          uint64
+	getMisses        uint64
+	deleteHits       uint64
+	deleteMisses     uint64
+	incrHits         uint64
+	incrMisses       uint64
+	decrHits         uint64
+	decrMisses       uint64
+	casHits          uint64
+	casMisses        uint64
+	casBadval        uint64
+	touchHits        uint64
+	touchMisses      uint64
+}
+
+// NewServer creates a new Server serving the bigmap.
+func NewServer(bm *bigmap.BigMap) *Server {
+	return &Server{
+		bigmap:    bm,
+		started:   time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves
+// incoming connections.
+func (S *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return S.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves each
+// of them in a new goroutine.
+// It returns ErrServerClosed after Close was called.
+func (S *Server) Serve(listener net.Listener) error {
+	if !S.track(listener, nil) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	defer S.untrack(listener, nil)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if S.isClosed() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go S.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection until it is closed
+// or the client quits.
+func (S *Server) ServeConn(conn net.Conn) {
+	if !S.track(nil, conn) {
+		conn.Close()
+		return
+	}
+	defer S.untrack(nil, conn)
+	defer conn.Close()
+	atomic.AddInt64(&S.stats.currConnections, 1)
+	defer atomic.AddInt64(&S.stats.currConnections, -1)
+	atomic.AddUint64(&S.stats.totalConnections, 1)
+
+	c := &client{
+		server: S,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+		buffer: make([]byte, S.bigmap.EntrySize()),
+	}
+	magic, err := c.reader.Peek(1)
+	if err != nil {
+		return
+	}
+	if magic[0] == magicRequest {
+		c.serveBinary()
+	} else {
+		c.serveText()
+	}
+	c.writer.Flush()
+}
+
+// Close stops all listeners, closes all connections
+// and cancels a pending delayed flush_all.
+func (S *Server) Close() error {
+	S.lock.Lock()
+	S.closed = true
+	S.cancelFlush()
+	for listener := range S.listeners {
+		listener.Close()
+	}
+	for conn := range S.conns {
+		conn.Close()
+	}
+	S.lock.Unlock()
+	S.wg.Wait()
+	return nil
+}
+
+func (S *Server) track(listener net.Listener, conn net.Conn) bool {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if S.closed {
+		return false
+	}
+	if listener != nil {
+		S.listeners[listener] = struct{}{}
+	}
+	if conn != nil {
+		S.conns[conn] = struct{}{}
+	}
+	S.wg.Add(1)
+	return true
+}
+
+func (S *Server) untrack(listener net.Listener, conn net.Conn) {
+	S.lock.Lock()
+	delete(S.listeners, listener)
+	delete(S.conns, conn)
+	S.lock.Unlock()
+	S.wg.Done()
+}
+
+func (S *Server) isClosed() bool {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	return S.closed
+}
+
+// flush removes all items after the delay.
+// A pending delayed flush is replaced.
+func (S *Server) flush(delay time.Duration) {
+	S.lock.Lock()
+	S.cancelFlush()
+	if delay <= 0 {
+		S.lock.Unlock()
+		S.bigmap.Clear()
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		S.lock.Lock()
+		pending := S.flushTimer == timer
+		if pending {
+			S.flushTimer = nil
+		}
+		S.lock.Unlock()
+		if pending {
+			S.bigmap.Clear()
+		}
+	})
+	S.flushTimer = timer
+	S.lock.Unlock()
+}
+
+// cancelFlush stops the pending delayed flush.
+// The server must be locked.
+func (S *Server) cancelFlush() {
+	if S.flushTimer != nil {
+		S.flushTimer.Stop()
+		S.flushTimer = nil
+	}
+}
+
+func (S *Server) nextCAS() uint64 {
+	return atomic.AddUint64(&S.cas, 1)
+}
+
+// status is the result of an operation on an item
+// shared by the text and the binary protocol.
+type status uint8
+
+const (
+	statusStored status = iota
+	statusNotStored
+	statusExists
+	statusNotFound
+	statusTooLarge
+	statusNonNumeric
+	// statusError is a failed write, client.err holds its error.
+	statusError
+)
+
+// storeMode is the kind of a storage command.
+type storeMode uint8
+
+const (
+	modeSet storeMode = iota
+	modeAdd
+	modeReplace
+	modeCAS
+)
+
+// item is a decoded memcached item.
+// The value points into the buffer it was decoded from.
+type item struct {
+	flags uint32
+	cas   uint64
+	value []byte
+}
+
+// Items are stored as [uvarint key length][key][flags][cas][value]
+// so the keys can be compared on lookups.
+
+func appendItem(dst, key []byte, it item) []byte {
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(key)))
+	dst = append(dst, length[:n]...)
+	dst = append(dst, key...)
+	var header [itemHeader]byte
+	binary.LittleEndian.PutUint32(header[:], it.flags)
+	binary.LittleEndian.PutUint64(header[4:], it.cas)
+	dst = append(dst, header[:]...)
+	return append(dst, it.value...)
+}
+
+func decodeItem(key, entry []byte) (item, bool) {
+	length, n := binary.Uvarint(entry)
+	if n <= 0 || uint64(len(entry)-n) < length+itemHeader {
+		return item{}, false
+	}
+	entry = entry[n:]
+	if !bytes.Equal(entry[:length], key) {
+		return item{}, false
+	}
+	entry = entry[length:]
+	return item{
+		flags: binary.LittleEndian.Uint32(entry),
+		cas:   binary.LittleEndian.Uint64(entry[4:]),
+		value: entry[itemHeader:],
+	}, true
+}
+
+// ttlOf converts a memcached exptime into a ttl.
+// It returns false if the item is expired already.
+func ttlOf(exptime int64) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return 0, true
+	case exptime < 0:
+		return 0, false
+	case exptime <= relativeExptime:
+		return time.Duration(exptime) * time.Second, true
+	}
+	ttl := time.Until(time.Unix(exptime, 0))
+	return ttl, ttl > 0
+}
+
+type client struct {
+	server *Server
+	reader *bufio.Reader
+	writer *bufio.Writer
+	buffer []byte
+	entry  []byte
+	line   []byte
+	data   []byte
+	number []byte
+	err    error
+	quit   bool
+}
+
+// get returns the item of the key.
+// The value is only valid until the next get.
+func (c *client) get(key []byte) (item, bool) {
+	S := c.server
+	size, ok := S.bigmap.GetInto(key, c.buffer)
+	for ok && size > uint64(len(c.buffer)) {
+		// compressed items can be larger than the entrysize
+		c.buffer = make([]byte, size)
+		size, ok = S.bigmap.GetInto(key, c.buffer)
+	}
+	if ok {
+		var it item
+		if it, ok = decodeItem(key, c.buffer[:size]); ok {
+			atomic.AddUint64(&S.stats.getHits, 1)
+			return it, true
+		}
+	}
+	atomic.AddUint64(&S.stats.getMisses, 1)
+	return item{}, false
+}
+
+// update runs fn on the item of the key while it is locked.
+// Items of colliding keys are treated as missing.
+// If fn returns UpdatePut the item is stored with a new cas.
+func (c *client) update(key []byte, fn func(it item, ttl time.Duration, ok bool) (item, time.Duration, bigmap.UpdateOp)) (uint64, error) {
+	var cas uint64
+	err := c.server.bigmap.Update(key, func(entry []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, bigmap.UpdateOp) {
+		var it item
+		if ok {
+			it, ok = decodeItem(key, entry)
+		}
+		if !ok {
+			ttl = 0
+		}
+		it, ttl, op := fn(it, ttl, ok)
+		if op == bigmap.UpdatePut {
+			cas = c.server.nextCAS()
+			it.cas = cas
+			c.entry = appendItem(c.entry[:0], key, it)
+			return c.entry, ttl, op
+		}
+		return entry, ttl, op
+	})
+	return cas, err
+}
+
+// failed returns the status of a failed write.
+// Only values which don't fit are too large, other errors,
+// e.g. of the encryption, are reported with their message.
+func (c *client) failed(err error) status {
+	if errors.Is(err, bigmap.ErrValueSize) {
+		return statusTooLarge
+	}
+	c.err = err
+	return statusError
+}
+
+func (c *client) store(mode storeMode, key []byte, flags uint32, exptime int64, value []byte, cas uint64) (status, uint64) {
+	S := c.server
+	atomic.AddUint64(&S.stats.cmdSet, 1)
+	ttl, live := ttlOf(exptime)
+	result := statusStored
+	newCAS, err := c.update(key, func(it item, _ time.Duration, ok bool) (item, time.Duration, bigmap.UpdateOp) {
+		switch {
+		case mode == modeAdd && ok, mode == modeReplace && !ok:
+			result = statusNotStored
+			return it, 0, bigmap.UpdateKeep
+		case mode == modeCAS && !ok:
+			result = statusNotFound
+			return it, 0, bigmap.UpdateKeep
+		case mode == modeCAS && it.cas != cas:
+			result = statusExists
+			return it, 0, bigmap.UpdateKeep
+		case !live:
+			return it, 0, bigmap.UpdateDelete
+		}
+		return item{flags: flags, value: value}, ttl, bigmap.UpdatePut
+	})
+	if err != nil {
+		return c.failed(err), 0
+	}
+	if mode == modeCAS {
+		switch result {
+		case statusStored:
+			atomic.AddUint64(&S.stats.casHits, 1)
+		case statusExists:
+			atomic.AddUint64(&S.stats.casBadval, 1)
+		case statusNotFound:
+			atomic.AddUint64(&S.stats.casMisses, 1)
+		}
+	}
+	return result, newCAS
+}
+
+func (c *client) delete(key []byte, cas uint64) status {
+	S := c.server
+	result := statusNotFound
+	c.update(key, func(it item, _ time.Duration, ok bool) (item, time.Duration, bigmap.UpdateOp) {
+		if !ok {
+			return it, 0, bigmap.UpdateKeep
+		}
+		if cas != 0 && it.cas != cas {
+			result = statusExists
+			return it, 0, bigmap.UpdateKeep
+		}
+		result = statusStored
+		return it, 0, bigmap.UpdateDelete
+	})
+	if result == statusNotFound {
+		atomic.AddUint64(&S.stats.deleteMisses, 1)
+	} else {
+		atomic.AddUint64(&S.stats.deleteHits, 1)
+	}
+	return result
+}
+
+// incr increments or decrements the decimal value of an item.
+// Increments wrap around at 2^64, decrements stop at 0.
+func (c *client) incr(key []byte, delta uint64, decr bool) (status, uint64, uint64) {
+	S := c.server
+	result := statusStored
+	var value uint64
+	cas, err := c.update(key, func(it item, ttl time.Duration, ok bool) (item, time.Duration, bigmap.UpdateOp) {
+		if !ok {
+			result = statusNotFound
+			return it, 0, bigmap.UpdateKeep
+		}
+		current, valid := parseUint(bytes.TrimRight(it.value, " "))
+		if !valid {
+			result = statusNonNumeric
+			return it, 0, bigmap.UpdateKeep
+		}
+		switch {
+		case !decr:
+			value = current + delta
+		case delta > current:
+			value = 0
+		default:
+			value = current - delta
+		}
+		c.number = strconv.AppendUint(c.number[:0], value, 10)
+		it.value = c.number
+		return it, ttl, bigmap.UpdatePut
+	})
+	if err != nil {
+		return c.failed(err), 0, 0
+	}
+	hits, misses := &S.stats.incrHits, &S.stats.incrMisses
+	if decr {
+		hits, misses = &S.stats.decrHits, &S.stats.decrMisses
+	}
+	if result == statusNotFound {
+		atomic.AddUint64(misses, 1)
+	} else if result == statusStored {
+		atomic.AddUint64(hits, 1)
+	}
+	return result, value, cas
+}
+
+func (c *client) touch(key []byte, exptime int64) status {
+	S := c.server
+	atomic.AddUint64(&S.stats.cmdTouch, 1)
+	ttl, live := ttlOf(exptime)
+	result := statusStored
+	c.update(key, func(it item, _ time.Duration, ok bool) (item, time.Duration, bigmap.UpdateOp) {
+		if !ok {
+			result = statusNotFound
+			return it, 0, bigmap.UpdateKeep
+		}
+		if !live {
+			return it, 0, bigmap.UpdateDelete
+		}
+		return it, ttl, bigmap.UpdatePut
+	})
+	if result == statusNotFound {
+		atomic.AddUint64(&S.stats.touchMisses, 1)
+	} else {
+		atomic.AddUint64(&S.stats.touchHits, 1)
+	}
+	return result
+}
+
+// capacity returns the bytes the slots of all shards can hold.
+// The shards grow, so it is the current and not a fixed limit.
+func (S *Server) capacity() uint64 {
+	total := S.bigmap.Stats().Total
+	return (total.UsedSlots + total.FreeSlots) * S.bigmap.EntrySize()
+}
+
+// statistics returns the stats reported by the stats command.
+func (S *Server) statistics() [][2]string {
+	stat := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	load := func(v *uint64) string { return stat(atomic.LoadUint64(v)) }
+	now := time.Now()
+	return [][2]string{
+		{"uptime", stat(uint64(now.Sub(S.started) / time.Second))},
+		{"time", stat(uint64(now.Unix()))},
+		{"version", Version},
+		{"curr_connections", stat(uint64(atomic.LoadInt64(&S.stats.currConnections)))},
+		{"total_connections", load(&S.stats.totalConnections)},
+		{"cmd_get", load(&S.stats.cmdGet)},
+		{"cmd_set", load(&S.stats.cmdSet)},
+		{"cmd_touch", load(&S.stats.cmdTouch)},
+		{"get_hits", load(&S.stats.getHits)},
+		{"get_misses", load(&S.stats.getMisses)},
+		{"delete_hits", load(&S.stats.deleteHits)},
+		{"delete_misses", load(&S.stats.deleteMisses)},
+		{"incr_hits", load(&S.stats.incrHits)},
+		{"incr_misses", load(&S.stats.incrMisses)},
+		{"decr_hits", load(&S.stats.decrHits)},
+		{"decr_misses", load(&S.stats.decrMisses)},
+		{"cas_hits", load(&S.stats.casHits)},
+		{"cas_misses", load(&S.stats.casMisses)},
+		{"cas_badval", load(&S.stats.casBadval)},
+		{"touch_hits", load(&S.stats.touchHits)},
+		{"touch_misses", load(&S.stats.touchMisses)},
+		{"curr_items", stat(uint64(S.bigmap.Len()))},
+		{"limit_maxbytes", stat(S.capacity())},
+		{"threads", "1"},
+	}
+}
+
+func parseUint(b []byte) (uint64, bool) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, false
+	}
+	var n uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		next := n*10 + uint64(c-'0')
+		if next/10 != n {
+			return 0, false
+		}
+		n = next
+	}
+	return n, true
+}
+
+func parseInt(b []byte) (int64, bool) {
+	negative := len(b) > 0 && b[0] == '-'
+	if negative {
+		b = b[1:]
+	}
+	n, ok := parseUint(b)
+	if !ok || n > 1<<63-1 {
+		return 0, false
+	}
+	if negative {
+		return -int64(n), true
+	}
+	return int64(n), true
+}
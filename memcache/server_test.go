@@ -0,0 +1,273 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func startServer(t *testing.T) *testClient {
+	bm := bigmap.New(EntrySize(64), bigmap.Config{Shards: 4})
+	return serveMap(t, &bm)
+}
+
+// serveMap starts a server of the map and connects to it.
+func serveMap(t *testing.T, bm *bigmap.BigMap) *testClient {
+	server := NewServer(bm)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// expect sends the command and compares the reply lines.
+func (c *testClient) expect(command string, want ...string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(command)); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+	for _, line := range want {
+		got, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("%q read: %v", command, err)
+		}
+		if got != line+"\r\n" {
+			c.t.Fatalf("%q got %q, want %q", command, got, line)
+		}
+	}
+}
+
+// cas returns the cas value of the key using gets.
+func (c *testClient) cas(key string) string {
+	c.t.Helper()
+	c.conn.Write([]byte("gets " + key + "\r\n"))
+	line, _ := c.reader.ReadString('\n')
+	fields := strings.Fields(line)
+	if len(fields) != 5 {
+		c.t.Fatalf("gets %s: %q", key, line)
+	}
+	c.reader.ReadString('\n')
+	c.reader.ReadString('\n')
+	return fields[4]
+}
+
+func TestServer_Text(t *testing.T) {
+	c := startServer(t)
+	c.expect("set key 5 0 5\r\nvalue\r\n", "STORED")
+	c.expect("get key missing\r\n", "VALUE key 5 5", "value", "END")
+	c.expect("add key 0 0 1\r\nx\r\n", "NOT_STORED")
+	c.expect("replace missing 0 0 1\r\nx\r\n", "NOT_STORED")
+	c.expect("replace key 1 0 5\r\nother\r\n", "STORED")
+	c.expect("get key\r\n", "VALUE key 1 5", "other", "END")
+
+	cas := c.cas("key")
+	c.expect("cas key 0 0 3 "+cas+"\r\nnew\r\n", "STORED")
+	c.expect("cas key 0 0 3 "+cas+"\r\nold\r\n", "EXISTS")
+	c.expect("cas missing 0 0 3 1\r\nold\r\n", "NOT_FOUND")
+	if next := c.cas("key"); next == cas {
+		t.Fatalf("cas didn't change: %s", next)
+	}
+
+	c.expect("set counter 0 0 2\r\n10\r\n", "STORED")
+	c.expect("incr counter 5\r\n", "15")
+	c.expect("decr counter 20\r\n", "0")
+	c.expect("incr key 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	c.expect("incr missing 1\r\n", "NOT_FOUND")
+
+	c.expect("delete key\r\n", "DELETED")
+	c.expect("delete key\r\n", "NOT_FOUND")
+	c.expect("set quiet 0 0 1 noreply\r\nq\r\nget quiet\r\n", "VALUE quiet 0 1", "q", "END")
+	c.expect("set key 0 0 3\r\ntoolong\r\n", "CLIENT_ERROR bad data chunk")
+	c.expect("set key 0 0 1000\r\n"+strings.Repeat("x", 1000)+"\r\n", "SERVER_ERROR object too large for cache")
+	c.expect("unknown\r\n", "ERROR")
+	c.expect("flush_all\r\n", "OK")
+	c.expect("get quiet\r\n", "END")
+	c.expect("version\r\n", "VERSION "+Version)
+}
+
+func TestServer_TextExptime(t *testing.T) {
+	c := startServer(t)
+	c.expect("set key 0 1 5\r\nvalue\r\n", "STORED")
+	c.expect("set expired 0 -1 5\r\nvalue\r\n", "STORED")
+	c.expect("get expired\r\n", "END")
+	c.expect("touch key 100\r\n", "TOUCHED")
+	c.expect("touch missing 100\r\n", "NOT_FOUND")
+	absolute := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	c.expect("set absolute 0 "+absolute+" 5\r\nvalue\r\n", "STORED")
+	c.expect("set short 0 1 5\r\nvalue\r\n", "STORED")
+	time.Sleep(1100 * time.Millisecond)
+	c.expect("get key absolute short\r\n", "VALUE key 0 5", "value", "VALUE absolute 0 5", "value", "END")
+}
+
+func TestServer_Stats(t *testing.T) {
+	bm := bigmap.New(EntrySize(64), bigmap.Config{Shards: 4})
+	c := serveMap(t, &bm)
+	c.expect("set key 0 0 5\r\nvalue\r\n", "STORED")
+	c.expect("get key missing\r\n", "VALUE key 0 5", "value", "END")
+	c.conn.Write([]byte("stats\r\n"))
+	stats := map[string]string{}
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if line == "END\r\n" {
+			break
+		}
+		fields := strings.Fields(line)
+		stats[fields[1]] = fields[2]
+	}
+	if stats["get_hits"] != "1" || stats["get_misses"] != "1" || stats["curr_items"] != "1" {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+	total := bm.Stats().Total
+	if want := strconv.FormatUint((total.UsedSlots+total.FreeSlots)*bm.EntrySize(), 10); stats["limit_maxbytes"] != want {
+		t.Fatalf("limit_maxbytes is %s, want %s", stats["limit_maxbytes"], want)
+	}
+}
+
+func TestServer_StoreError(t *testing.T) {
+	keyring, err := bigmap.NewKeyring(1, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	bm := bigmap.New(EntrySize(64), bigmap.Config{Shards: 4, Keyring: keyring})
+	c := serveMap(t, &bm)
+	c.expect("set key 0 0 5\r\nvalue\r\n", "STORED")
+	keyring.Rotate(2, bytes.Repeat([]byte{2}, 32))
+	keyring.Remove(1)
+	// the stored item can't be decrypted anymore
+	c.expect("set key 0 0 5\r\nvalue\r\n", "SERVER_ERROR bigmap: unknown encryption key (1)")
+	c.expect("incr key 1\r\n", "SERVER_ERROR bigmap: unknown encryption key (1)")
+	// a value which passes the check of the data block
+	// but doesn't fit into an entry with its item header
+	large := strconv.FormatUint(bm.EntrySize(), 10) + "\r\n" + strings.Repeat("x", int(bm.EntrySize())) + "\r\n"
+	c.expect("set other 0 0 "+large, "SERVER_ERROR object too large for cache")
+
+	b := serveMap(t, &bm)
+	status, _, body := b.binaryRequest(opSet, 0, make([]byte, 8), []byte("key"), []byte("value"))
+	if status != statusInternalError || string(body) != "bigmap: unknown encryption key (1)" {
+		t.Fatalf("binary set got %x %q", status, body)
+	}
+}
+
+// binaryRequest sends a binary request and reads the response.
+func (c *testClient) binaryRequest(opcode byte, cas uint64, extras, key, value []byte) (uint16, uint64, []byte) {
+	c.t.Helper()
+	var header [headerLength]byte
+	header[0] = magicRequest
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(key)))
+	header[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:], 42)
+	binary.BigEndian.PutUint64(header[16:], cas)
+	request := append(append(append(header[:], extras...), key...), value...)
+	if _, err := c.conn.Write(request); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	if header[0] != magicResponse || header[1] != opcode || binary.BigEndian.Uint32(header[12:]) != 42 {
+		c.t.Fatalf("bad response header %v", header)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[8:]))
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	return binary.BigEndian.Uint16(header[6:]), binary.BigEndian.Uint64(header[16:]), body
+}
+
+func TestServer_Binary(t *testing.T) {
+	c := startServer(t)
+	setExtras := make([]byte, 8)
+	binary.BigEndian.PutUint32(setExtras, 7)
+	status, cas, _ := c.binaryRequest(opSet, 0, setExtras, []byte("key"), []byte("value"))
+	if status != statusOK || cas == 0 {
+		t.Fatalf("set: status %d cas %d", status, cas)
+	}
+	status, got, body := c.binaryRequest(opGet, 0, nil, []byte("key"), nil)
+	if status != statusOK || got != cas || !bytes.Equal(body, []byte("\x00\x00\x00\x07value")) {
+		t.Fatalf("get: status %d cas %d body %q", status, got, body)
+	}
+	if status, _, _ = c.binaryRequest(opGet, 0, nil, []byte("missing"), nil); status != statusKeyNotFound {
+		t.Fatalf("get missing: status %d", status)
+	}
+	if status, _, _ = c.binaryRequest(opAdd, 0, setExtras, []byte("key"), []byte("x")); status != statusItemNotStored {
+		t.Fatalf("add: status %d", status)
+	}
+	if status, _, _ = c.binaryRequest(opSet, cas+100, setExtras, []byte("key"), []byte("x")); status != statusKeyExists {
+		t.Fatalf("set with bad cas: status %d", status)
+	}
+	if status, _, _ = c.binaryRequest(opSet, cas, setExtras, []byte("key"), []byte("x")); status != statusOK {
+		t.Fatalf("set with cas: status %d", status)
+	}
+
+	incrExtras := make([]byte, 20)
+	binary.BigEndian.PutUint64(incrExtras, 2)
+	binary.BigEndian.PutUint64(incrExtras[8:], 10)
+	status, _, body = c.binaryRequest(opIncrement, 0, incrExtras, []byte("counter"), nil)
+	if status != statusOK || binary.BigEndian.Uint64(body) != 10 {
+		t.Fatalf("incr initial: status %d body %v", status, body)
+	}
+	status, _, body = c.binaryRequest(opIncrement, 0, incrExtras, []byte("counter"), nil)
+	if status != statusOK || binary.BigEndian.Uint64(body) != 12 {
+		t.Fatalf("incr: status %d body %v", status, body)
+	}
+
+	if status, _, _ = c.binaryRequest(opTouch, 0, make([]byte, 4), []byte("key"), nil); status != statusOK {
+		t.Fatalf("touch: status %d", status)
+	}
+	if status, _, _ = c.binaryRequest(opDelete, 0, nil, []byte("key"), nil); status != statusOK {
+		t.Fatalf("delete: status %d", status)
+	}
+	if status, _, _ = c.binaryRequest(opDelete, 0, nil, []byte("key"), nil); status != statusKeyNotFound {
+		t.Fatalf("delete missing: status %d", status)
+	}
+	if status, _, body = c.binaryRequest(opVersion, 0, nil, nil, nil); status != statusOK || string(body) != Version {
+		t.Fatalf("version: status %d body %q", status, body)
+	}
+	if status, _, _ = c.binaryRequest(0xfe, 0, nil, nil, nil); status != statusUnknownCommand {
+		t.Fatalf("unknown: status %d", status)
+	}
+}
+
+func TestServer_flushDelay(t *testing.T) {
+	bm := bigmap.New(EntrySize(64), bigmap.Config{Shards: 4})
+	server := NewServer(&bm)
+	server.flush(20 * time.Millisecond)
+	server.flush(time.Hour)
+	bm.Put([]byte("superseded"), []byte("value"))
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := bm.Get([]byte("superseded")); !ok {
+		t.Fatalf("superseded delayed flush removed the item")
+	}
+	server.flush(20 * time.Millisecond)
+	server.Close()
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := bm.Get([]byte("superseded")); !ok {
+		t.Fatalf("delayed flush removed the item after Close")
+	}
+}
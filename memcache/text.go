@@ -0,0 +1,358 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maxLineLength is the maximum length of a command line.
+const maxLineLength = 2048
+
+var errLineTooLong = errors.New("memcache: line too long")
+
+var (
+	noreply   = []byte("noreply")
+	crlf      = []byte("\r\n")
+	valueLine = []byte("VALUE ")
+)
+
+// serveText serves the text protocol until the connection
+// fails or the client quits.
+func (c *client) serveText() {
+	for !c.quit {
+		line, err := c.readLine()
+		if err == errLineTooLong {
+			c.writeLine("CLIENT_ERROR line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+		if err := c.text(bytes.Fields(line)); err != nil {
+			return
+		}
+		if c.reader.Buffered() == 0 {
+			if c.writer.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLine reads the next line.
+// The line is copied to be valid while reading data blocks.
+func (c *client) readLine() ([]byte, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxLineLength {
+		return nil, errLineTooLong
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.line = append(c.line[:0], bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})...)
+	return c.line, nil
+}
+
+// text executes a single command.
+// Only errors of the connection are returned.
+func (c *client) text(fields [][]byte) error {
+	if len(fields) == 0 {
+		c.writeLine("ERROR")
+		return nil
+	}
+	name, args := fields[0], fields[1:]
+	switch string(name) {
+	case "get":
+		c.textGet(args, false)
+	case "gets":
+		c.textGet(args, true)
+	case "set":
+		return c.textStore(modeSet, args)
+	case "add":
+		return c.textStore(modeAdd, args)
+	case "replace":
+		return c.textStore(modeReplace, args)
+	case "cas":
+		return c.textStore(modeCAS, args)
+	case "delete":
+		c.textDelete(args)
+	case "incr":
+		c.textIncr(args, false)
+	case "decr":
+		c.textIncr(args, true)
+	case "touch":
+		c.textTouch(args)
+	case "stats":
+		for _, stat := range c.server.statistics() {
+			c.writeLine("STAT " + stat[0] + " " + stat[1])
+		}
+		c.writeLine("END")
+	case "flush_all":
+		c.textFlush(args)
+	case "version":
+		c.writeLine("VERSION " + Version)
+	case "verbosity":
+		if !isNoreply(args) {
+			c.writeLine("OK")
+		}
+	case "quit":
+		c.quit = true
+	default:
+		c.writeLine("ERROR")
+	}
+	return nil
+}
+
+func (c *client) textGet(keys [][]byte, cas bool) {
+	if len(keys) == 0 {
+		c.writeLine("ERROR")
+		return
+	}
+	for _, key := range keys {
+		atomic.AddUint64(&c.server.stats.cmdGet, 1)
+		if len(key) > MaxKeyLength {
+			c.writeLine("CLIENT_ERROR bad command line format")
+			return
+		}
+		it, ok := c.get(key)
+		if !ok {
+			continue
+		}
+		c.writer.Write(valueLine)
+		c.writer.Write(key)
+		c.writeNumber(uint64(it.flags))
+		c.writeNumber(uint64(len(it.value)))
+		if cas {
+			c.writeNumber(it.cas)
+		}
+		c.writer.Write(crlf)
+		c.writer.Write(it.value)
+		c.writer.Write(crlf)
+	}
+	c.writeLine("END")
+}
+
+// textStore executes set, add, replace and cas:
+// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (c *client) textStore(mode storeMode, args [][]byte) error {
+	required := 4
+	if mode == modeCAS {
+		required = 5
+	}
+	if len(args) < required || len(args) > required+1 {
+		c.writeLine("ERROR")
+		return nil
+	}
+	size, ok := parseInt(args[3])
+	if !ok || size < 0 {
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return nil
+	}
+	// the data block has to be consumed before reporting other errors.
+	data, valid, err := c.readData(size)
+	if err != nil {
+		return err
+	}
+	quiet := len(args) > required && bytes.Equal(args[required], noreply)
+	key := args[0]
+	flags, flagsOK := parseUint(args[1])
+	exptime, exptimeOK := parseInt(args[2])
+	var cas uint64
+	casOK := true
+	if mode == modeCAS {
+		cas, casOK = parseUint(args[4])
+	}
+	switch {
+	case len(key) > MaxKeyLength || !flagsOK || flags > 1<<32-1 || !exptimeOK || !casOK:
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return nil
+	case !valid:
+		c.writeLine("CLIENT_ERROR bad data chunk")
+		return nil
+	case data == nil:
+		c.writeLine("SERVER_ERROR object too large for cache")
+		return nil
+	}
+	result, _ := c.store(mode, key, uint32(flags), exptime, data, cas)
+	if quiet {
+		return nil
+	}
+	switch result {
+	case statusStored:
+		c.writeLine("STORED")
+	case statusNotStored:
+		c.writeLine("NOT_STORED")
+	case statusExists:
+		c.writeLine("EXISTS")
+	case statusNotFound:
+		c.writeLine("NOT_FOUND")
+	case statusTooLarge:
+		c.writeLine("SERVER_ERROR object too large for cache")
+	case statusError:
+		c.writeLine("SERVER_ERROR " + c.err.Error())
+	}
+	return nil
+}
+
+// readData reads a data block of the size followed by \r\n.
+// Blocks which are too large for the map are discarded
+// and returned as nil.
+func (c *client) readData(size int64) ([]byte, bool, error) {
+	if uint64(size) > c.server.bigmap.EntrySize() {
+		_, err := io.CopyN(io.Discard, c.reader, size+2)
+		return nil, true, err
+	}
+	if int64(cap(c.data)) < size+2 {
+		c.data = make([]byte, size+2)
+	}
+	c.data = c.data[:size+2]
+	if _, err := io.ReadFull(c.reader, c.data); err != nil {
+		return nil, false, err
+	}
+	if !bytes.HasSuffix(c.data, crlf) {
+		// swallow the rest of an overlong block
+		err := bufio.ErrBufferFull
+		if c.data[size+1] == '\n' {
+			err = nil
+		}
+		for err == bufio.ErrBufferFull {
+			_, err = c.reader.ReadSlice('\n')
+		}
+		return nil, false, err
+	}
+	return c.data[:size], true, nil
+}
+
+// textDelete executes delete <key> [0] [noreply]
+func (c *client) textDelete(args [][]byte) {
+	if len(args) == 0 || len(args) > 3 {
+		c.writeLine("ERROR")
+		return
+	}
+	options := args[1:]
+	quiet := isNoreply(options)
+	if quiet {
+		options = options[:len(options)-1]
+	}
+	if len(options) > 1 || len(options) == 1 && !bytes.Equal(options[0], []byte("0")) {
+		c.writeLine("CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]")
+		return
+	}
+	if len(args[0]) > MaxKeyLength {
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return
+	}
+	result := c.delete(args[0], 0)
+	if quiet {
+		return
+	}
+	if result == statusNotFound {
+		c.writeLine("NOT_FOUND")
+	} else {
+		c.writeLine("DELETED")
+	}
+}
+
+// textIncr executes incr and decr <key> <value> [noreply]
+func (c *client) textIncr(args [][]byte, decr bool) {
+	if len(args) < 2 || len(args) > 3 {
+		c.writeLine("ERROR")
+		return
+	}
+	delta, ok := parseUint(args[1])
+	if !ok {
+		c.writeLine("CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+	if len(args[0]) > MaxKeyLength {
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return
+	}
+	result, value, _ := c.incr(args[0], delta, decr)
+	if isNoreply(args[2:]) {
+		return
+	}
+	switch result {
+	case statusStored:
+		c.number = strconv.AppendUint(c.number[:0], value, 10)
+		c.writer.Write(c.number)
+		c.writer.Write(crlf)
+	case statusNotFound:
+		c.writeLine("NOT_FOUND")
+	case statusNonNumeric:
+		c.writeLine("CLIENT_ERROR cannot increment or decrement non-numeric value")
+	case statusTooLarge:
+		c.writeLine("SERVER_ERROR object too large for cache")
+	case statusError:
+		c.writeLine("SERVER_ERROR " + c.err.Error())
+	}
+}
+
+// textTouch executes touch <key> <exptime> [noreply]
+func (c *client) textTouch(args [][]byte) {
+	if len(args) < 2 || len(args) > 3 {
+		c.writeLine("ERROR")
+		return
+	}
+	exptime, ok := parseInt(args[1])
+	if !ok {
+		c.writeLine("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+	if len(args[0]) > MaxKeyLength {
+		c.writeLine("CLIENT_ERROR bad command line format")
+		return
+	}
+	result := c.touch(args[0], exptime)
+	if isNoreply(args[2:]) {
+		return
+	}
+	if result == statusNotFound {
+		c.writeLine("NOT_FOUND")
+	} else {
+		c.writeLine("TOUCHED")
+	}
+}
+
+// textFlush executes flush_all [delay] [noreply]
+func (c *client) textFlush(args [][]byte) {
+	quiet := isNoreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) > 1 {
+		c.writeLine("ERROR")
+		return
+	}
+	var delay int64
+	if len(args) == 1 {
+		var ok bool
+		if delay, ok = parseInt(args[0]); !ok || delay < 0 {
+			c.writeLine("CLIENT_ERROR bad command line format")
+			return
+		}
+	}
+	c.server.flush(time.Duration(delay) * time.Second)
+	if !quiet {
+		c.writeLine("OK")
+	}
+}
+
+func (c *client) writeLine(line string) {
+	c.writer.WriteString(line)
+	c.writer.Write(crlf)
+}
+
+func (c *client) writeNumber(n uint64) {
+	c.number = strconv.AppendUint(append(c.number[:0], ' '), n, 10)
+	c.writer.Write(c.number)
+}
+
+func isNoreply(args [][]byte) bool {
+	return len(args) > 0 && bytes.Equal(args[len(args)-1], noreply)
+}
@@ -0,0 +1,184 @@
+package bigmap
+
+import "sync/atomic"
+
+// Metrics are the instrumentation counters of a shard or a map.
+// The counters only increase over the lifetime of a shard,
+// Items, Bytes, UsedBytes and FreeSlots reflect the current state.
+type Metrics struct {
+	// Hits and Misses count the lookups of Get and GetInto.
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// Puts counts stored items, Deletes removed items.
+	Puts    uint64 `json:"puts"`
+	Deletes uint64 `json:"deletes"`
+	// Evictions counts items removed by the ExpirationService.
+	Evictions uint64 `json:"evictions"`
+	// Expirations counts items removed because their ttl passed.
+	Expirations uint64 `json:"expirations"`
+	// Sweeps counts the sweeps of the sweep expiration service.
+	Sweeps uint64 `json:"sweeps"`
+	// SpinRetries counts how often readers had to wait for a writer.
+	SpinRetries uint64 `json:"spin_retries"`
+	// VerifyRetries counts optimistic reads which had to be repeated.
+	VerifyRetries uint64 `json:"verify_retries"`
+	// Grows counts how often the byte-array was grown.
+	Grows uint64 `json:"grows"`
+	// DroppedEvents counts the events lost because watchers were too slow.
+	DroppedEvents uint64 `json:"dropped_events"`
+	// Spills counts evicted items written to the disk tier,
+	// Promotions items read back from it.
+	Spills     uint64 `json:"spills"`
+	Promotions uint64 `json:"promotions"`
+	// Compactions counts the compactions of the disk tier.
+	Compactions uint64 `json:"compactions"`
+	// DiskErrors counts failed reads and writes of the disk tier.
+	DiskErrors uint64 `json:"disk_errors"`
+	// Compressed counts the values stored compressed.
+	// UncompressedBytes and CompressedBytes sum their
+	// sizes before and after compression.
+	Compressed        uint64 `json:"compressed"`
+	UncompressedBytes uint64 `json:"uncompressed_bytes"`
+	CompressedBytes   uint64 `json:"compressed_bytes"`
+	// DecodeErrors counts values which couldn't be
+	// decrypted or decompressed.
+	DecodeErrors uint64 `json:"decode_errors"`
+	// Corruptions counts corrupt items found by reads,
+	// migrations and Verify.
+	Corruptions uint64 `json:"corruptions"`
+	// Items is the amount of items.
+	Items uint64 `json:"items"`
+	// Bytes is the size of the byte-array.
+	Bytes uint64 `json:"bytes"`
+	// UsedBytes is the part of the byte-array taken by slots.
+	UsedBytes uint64 `json:"used_bytes"`
+	// FreeSlots is the amount of slots queued for reuse.
+	FreeSlots uint64 `json:"free_slots"`
+	// DiskItems is the amount of items in the disk tier.
+	DiskItems uint64 `json:"disk_items"`
+	// DiskBytes is the size of the log of the disk tier.
+	DiskBytes uint64 `json:"disk_bytes"`
+}
+
+// Add adds the metrics of other to M.
+func (M *Metrics) Add(other Metrics) {
+	M.Hits += other.Hits
+	M.Misses += other.Misses
+	M.Puts += other.Puts
+	M.Deletes += other.Deletes
+	M.Evictions += other.Evictions
+	M.Expirations += other.Expirations
+	M.Sweeps += other.Sweeps
+	M.SpinRetries += other.SpinRetries
+	M.VerifyRetries += other.VerifyRetries
+	M.Grows += other.Grows
+	M.DroppedEvents += other.DroppedEvents
+	M.Spills += other.Spills
+	M.Promotions += other.Promotions
+	M.Compactions += other.Compactions
+	M.DiskErrors += other.DiskErrors
+	M.Compressed += other.Compressed
+	M.UncompressedBytes += other.UncompressedBytes
+	M.CompressedBytes += other.CompressedBytes
+	M.DecodeErrors += other.DecodeErrors
+	M.Corruptions += other.Corruptions
+	M.Items += other.Items
+	M.Bytes += other.Bytes
+	M.UsedBytes += other.UsedBytes
+	M.FreeSlots += other.FreeSlots
+	M.DiskItems += other.DiskItems
+	M.DiskBytes += other.DiskBytes
+}
+
+// shardMetrics are updated atomically.
+// The padding keeps the counters off the cache line of the lock
+// so counting doesn't slow down optimistic readers.
+type shardMetrics struct {
+	_                 [64]byte
+	hits              uint64
+	misses            uint64
+	puts              uint64
+	deletes           uint64
+	evictions         uint64
+	expirations       uint64
+	sweeps            uint64
+	spinRetries       uint64
+	verifyRetries     uint64
+	grows             uint64
+	droppedEvents     uint64
+	spills            uint64
+	promotions        uint64
+	compactions       uint64
+	diskErrors        uint64
+	compressed        uint64
+	uncompressedBytes uint64
+	compressedBytes   uint64
+	decodeErrors      uint64
+	corruptions       uint64
+	_                 [64]byte
+}
+
+func count(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
+
+// Metrics returns a snapshot of the metrics of the shard.
+func (S *Shard) Metrics() Metrics {
+	m := &S.metrics
+	metrics := Metrics{
+		Hits:              atomic.LoadUint64(&m.hits),
+		Misses:            atomic.LoadUint64(&m.misses),
+		Puts:              atomic.LoadUint64(&m.puts),
+		Deletes:           atomic.LoadUint64(&m.deletes),
+		Evictions:         atomic.LoadUint64(&m.evictions),
+		Expirations:       atomic.LoadUint64(&m.expirations),
+		Sweeps:            atomic.LoadUint64(&m.sweeps),
+		SpinRetries:       atomic.LoadUint64(&m.spinRetries),
+		VerifyRetries:     atomic.LoadUint64(&m.verifyRetries),
+		Grows:             atomic.LoadUint64(&m.grows),
+		DroppedEvents:     atomic.LoadUint64(&m.droppedEvents),
+		Spills:            atomic.LoadUint64(&m.spills),
+		Promotions:        atomic.LoadUint64(&m.promotions),
+		Compactions:       atomic.LoadUint64(&m.compactions),
+		DiskErrors:        atomic.LoadUint64(&m.diskErrors),
+		Compressed:        atomic.LoadUint64(&m.compressed),
+		UncompressedBytes: atomic.LoadUint64(&m.uncompressedBytes),
+		CompressedBytes:   atomic.LoadUint64(&m.compressedBytes),
+		DecodeErrors:      atomic.LoadUint64(&m.decodeErrors),
+		Corruptions:       atomic.LoadUint64(&m.corruptions),
+	}
+	if S.tier != nil {
+		items, size := S.tier.stats()
+		metrics.DiskItems, metrics.DiskBytes = uint64(items), uint64(size)
+	}
+	for {
+		check := S.rlock()
+		metrics.Items = uint64(S.ptrs.Len())
+		metrics.Bytes = uint64(len(S.array))
+		metrics.UsedBytes = S.size
+		metrics.FreeSlots = uint64(S.freePtrs.Len())
+		if S.lock.RVerify(check) {
+			return metrics
+		}
+	}
+}
+
+// Metrics returns the metrics of every shard of the map.
+// The counters of shards replaced by Reshard are dropped.
+func (B *BigMap) Metrics() []Metrics {
+	shards := B.loadTable().shards
+	metrics := make([]Metrics, len(shards))
+	for i, s := range shards {
+		metrics[i] = s.Metrics()
+	}
+	return metrics
+}
+
+// TotalMetrics returns the sum of the metrics of all shards.
+func (B *BigMap) TotalMetrics() Metrics {
+	var total Metrics
+	for _, m := range B.Metrics() {
+		total.Add(m)
+	}
+	return total
+}
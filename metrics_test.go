@@ -0,0 +1,83 @@
+package bigmap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShard_Metrics(t *testing.T) {
+	shard := NewShard(64, 8, nil)
+	for i := uint64(0); i < 10; i++ {
+		shard.Put(i, []byte("value"))
+	}
+	shard.PutTTL(10, []byte("value"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	shard.Get(1)
+	shard.Get(10)
+	shard.GetInto(20, make([]byte, 8))
+	shard.Delete(2)
+	shard.Delete(2)
+	shard.Update(3, func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, UpdateOp) {
+		return val, ttl, UpdateDelete
+	})
+
+	metrics := shard.Metrics()
+	want := Metrics{
+		Hits:        1,
+		Misses:      2,
+		Puts:        11,
+		Deletes:     2,
+		Expirations: 1,
+		Items:       8,
+		UsedBytes:   11 * (8 + HeaderBytes),
+		FreeSlots:   3,
+		Bytes:       metrics.Bytes,
+		Grows:       metrics.Grows,
+	}
+	metrics.SpinRetries, metrics.VerifyRetries = 0, 0
+	if metrics != want {
+		t.Fatalf("got %+v, want %+v", metrics, want)
+	}
+	if metrics.Grows == 0 || metrics.Bytes <= 64 {
+		t.Fatalf("expected the shard to grow: %+v", metrics)
+	}
+}
+
+func TestShard_MetricsEvictions(t *testing.T) {
+	shard := NewShard(64, 8, NewSweepExpirationService(time.Nanosecond))
+	shard.Put(1, []byte("value"))
+	time.Sleep(time.Millisecond)
+	shard.Put(2, []byte("value"))
+	metrics := shard.Metrics()
+	if metrics.Evictions != 1 || metrics.Sweeps == 0 {
+		t.Fatalf("expected 1 eviction and a sweep, got %+v", metrics)
+	}
+}
+
+func TestBigMap_Stats(t *testing.T) {
+	bm := New(8, Config{Shards: 4, Capacity: 24 * 4})
+	for i := uint64(0); i < 100; i++ {
+		bm.PutUint64(i, []byte("value"))
+	}
+	for i := uint64(0); i < 10; i++ {
+		bm.DeleteUint64(i)
+	}
+	stats := bm.Stats()
+	if len(stats.Shards) != 4 {
+		t.Fatalf("expected 4 shards, got %d", len(stats.Shards))
+	}
+	total := stats.Total
+	if total.Items != 90 || total.UsedSlots != 90 || total.QueueLength != 10 || total.FreeSlots < 10 {
+		t.Fatalf("unexpected total %+v", total)
+	}
+	probes := 0
+	for _, n := range total.ProbeHistogram {
+		probes += n
+	}
+	if probes != 90 || total.LoadFactor <= 0 {
+		t.Fatalf("unexpected probes %v and load factor %v", total.ProbeHistogram, total.LoadFactor)
+	}
+	if stats.MinItems > stats.MaxItems || stats.Skew < 1 || stats.Skew > 2 {
+		t.Fatalf("unexpected distribution %d %d %v", stats.MinItems, stats.MaxItems, stats.Skew)
+	}
+}
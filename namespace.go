@@ -0,0 +1,351 @@
+package bigmap
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNamespaceLimit is returned by writes which would exceed
+// the limits of their Namespace.
+var ErrNamespaceLimit = errors.New("bigmap: namespace limit exceeded")
+
+// NamespaceConfig defines the limits of a Namespace.
+// Values which are 0 are unlimited.
+type NamespaceConfig struct {
+	// MaxItems is the maximum amount of items in the namespace.
+	//
+	// Default: 0
+	MaxItems uint64
+	// MaxBytes is the maximum size of the values of the namespace
+	// as they are stored, i.e. after compression and encryption.
+	//
+	// Default: 0
+	MaxBytes uint64
+}
+
+var _ Store = (*Namespace)(nil)
+
+// Namespace is a partition of a BigMap, e.g. for a tenant.
+// Its keys are hashed with a seed of its name, so equal keys of
+// different namespaces and of the map are different items.
+// Like all keys of the map, different keys can still collide
+// if their 64 bit hashes are equal.
+// The items and bytes of a namespace are counted and limited
+// and it can be flushed without touching other items.
+//
+// The keys of a namespace are indexed and reported to
+// watchers as the name followed by '/' and the key.
+type Namespace struct {
+	bigmap   *BigMap
+	name     string
+	seed     uint64
+	items    int64
+	bytes    int64
+	maxItems uint64
+	maxBytes uint64
+}
+
+// namespaces holds the namespaces of a BigMap by name.
+type namespaces struct {
+	lock   sync.Mutex
+	spaces map[string]*Namespace
+}
+
+// spaceEntry is the membership of an item in a namespace.
+// The size is the size of the item as counted by the namespace.
+type spaceEntry struct {
+	space *Namespace
+	size  int64
+}
+
+// release removes the item from the counts of its namespace.
+func (E spaceEntry) release() {
+	if E.space != nil {
+		atomic.AddInt64(&E.space.items, -1)
+		atomic.AddInt64(&E.space.bytes, -E.size)
+	}
+}
+
+// reserve adds the items and bytes to the counts of the namespace
+// if they don't exceed its limits.
+func (N *Namespace) reserve(items, bytes int64) error {
+	if n := atomic.AddInt64(&N.items, items); items > 0 {
+		if max := atomic.LoadUint64(&N.maxItems); max != 0 && uint64(n) > max {
+			atomic.AddInt64(&N.items, -items)
+			return fmt.Errorf("namespace put: %w (%d items)", ErrNamespaceLimit, max)
+		}
+	}
+	if n := atomic.AddInt64(&N.bytes, bytes); bytes > 0 {
+		if max := atomic.LoadUint64(&N.maxBytes); max != 0 && uint64(n) > max {
+			atomic.AddInt64(&N.bytes, -bytes)
+			atomic.AddInt64(&N.items, -items)
+			return fmt.Errorf("namespace put: %w (%d bytes)", ErrNamespaceLimit, max)
+		}
+	}
+	return nil
+}
+
+// Namespace returns the namespace of the name and creates it
+// if it doesn't exist. The limits of the config replace the
+// limits of an existing namespace, already stored items are
+// kept if they exceed the new limits.
+func (B *BigMap) Namespace(name string, config ...NamespaceConfig) *Namespace {
+	B.spaces.lock.Lock()
+	defer B.spaces.lock.Unlock()
+	N, ok := B.spaces.spaces[name]
+	if !ok {
+		N = &Namespace{bigmap: B, name: name, seed: Mix64(B.hasher.HashString(name))}
+		B.spaces.spaces[name] = N
+	}
+	if len(config) != 0 {
+		atomic.StoreUint64(&N.maxItems, config[0].MaxItems)
+		atomic.StoreUint64(&N.maxBytes, config[0].MaxBytes)
+	}
+	return N
+}
+
+// FlushNamespace removes all items of the namespace of the name
+// and returns the amount of removed items.
+func (B *BigMap) FlushNamespace(name string) int {
+	B.spaces.lock.Lock()
+	N, ok := B.spaces.spaces[name]
+	B.spaces.lock.Unlock()
+	if !ok {
+		return 0
+	}
+	return N.Flush()
+}
+
+// Name returns the name of the namespace.
+func (N *Namespace) Name() string {
+	return N.name
+}
+
+// Len returns the amount of items in the namespace.
+// Expired items which weren't removed yet are included.
+func (N *Namespace) Len() int {
+	return int(atomic.LoadInt64(&N.items))
+}
+
+// Bytes returns the size of the values of the namespace as they are stored.
+func (N *Namespace) Bytes() uint64 {
+	return uint64(atomic.LoadInt64(&N.bytes))
+}
+
+// Flush removes all items of the namespace and
+// returns the amount of removed items.
+// Reshard, Clear and Reset wait until Flush returns.
+func (N *Namespace) Flush() int {
+	B := N.bigmap
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	n := 0
+	for _, s := range B.loadTable().shards {
+		n += s.flushSpace(N)
+	}
+	return n
+}
+
+// hash returns the hash of the key in the namespace.
+// The seed is mixed before it is added, so swapping the name
+// and the key of two namespaces doesn't swap their hashes.
+func (N *Namespace) hash(key []byte) uint64 {
+	return Mix64(N.seed + N.bigmap.hasher.Hash(key))
+}
+
+// origin returns the key reported to watchers and
+// indexed if one of them needs it.
+func (N *Namespace) origin(key []byte) []byte {
+	if !N.bigmap.config.OrderedIndex && !N.bigmap.watch.wantsKeys() {
+		return nil
+	}
+	origin := make([]byte, 0, len(N.name)+1+len(key))
+	origin = append(origin, N.name...)
+	origin = append(origin, '/')
+	return append(origin, key...)
+}
+
+// Put puts an item into the namespace.
+// An error is returned if the item is to big
+// or exceeds the limits of the namespace.
+// The expired items of the namespace are removed
+// before a put fails because of the limits.
+func (N *Namespace) Put(key, val []byte) error {
+	return N.put(key, val, 0)
+}
+
+// PutTTL puts an item into the namespace like Put
+// which expires after the ttl.
+// A ttl smaller or equal to 0 never expires.
+func (N *Namespace) PutTTL(key, val []byte, ttl time.Duration) error {
+	return N.put(key, val, deadlineOf(ttl))
+}
+
+// put puts the item and retries once after removing
+// the expired items if the limits are exceeded.
+func (N *Namespace) put(key, val []byte, deadline int64) error {
+	h, origin := N.hash(key), N.origin(key)
+	err := N.bigmap.putIn(N, h, origin, val, deadline)
+	if errors.Is(err, ErrNamespaceLimit) && N.expire() != 0 {
+		err = N.bigmap.putIn(N, h, origin, val, deadline)
+	}
+	return err
+}
+
+// expire removes the expired items of the namespace
+// and returns the amount of removed items.
+func (N *Namespace) expire() int {
+	B := N.bigmap
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	n := 0
+	for _, s := range B.loadTable().shards {
+		n += s.expireSpace(N)
+	}
+	return n
+}
+
+// Get retrieves an item of the namespace.
+func (N *Namespace) Get(key []byte) ([]byte, bool) {
+	return N.bigmap.get(N.hash(key))
+}
+
+// GetInto retrieves an item of the namespace and writes it into buffer.
+func (N *Namespace) GetInto(key, buffer []byte) (uint64, bool) {
+	return N.bigmap.getInto(N.hash(key), buffer)
+}
+
+// Delete removes an item of the namespace.
+func (N *Namespace) Delete(key []byte) bool {
+	return N.bigmap.delete(N.hash(key), N.origin(key))
+}
+
+// TTL returns the remaining time to live of an item of the namespace.
+func (N *Namespace) TTL(key []byte) (time.Duration, bool) {
+	return N.bigmap.ttl(N.hash(key))
+}
+
+// Expire sets the time to live of an existing item of the namespace.
+func (N *Namespace) Expire(key []byte, ttl time.Duration) bool {
+	return N.bigmap.expireAt(N.hash(key), N.origin(key), deadlineOf(ttl))
+}
+
+// itemMeta is the state kept beside the slot of an item
+// which moves with the item between shards.
+type itemMeta struct {
+	origin []byte // the indexed key
+	space  spaceEntry
+}
+
+// meta returns the itemMeta of the item.
+// The shard must be locked.
+func (S *Shard) meta(key uint64) itemMeta {
+	return itemMeta{origin: S.indexed(key), space: S.spaces[key]}
+}
+
+// adopt keeps the itemMeta of an item moved into the shard.
+// The item stays counted by its namespace.
+// The shard must be locked.
+func (S *Shard) adopt(key uint64, meta itemMeta) {
+	S.indexKey(key, meta.origin)
+	if meta.space.space != nil {
+		if S.spaces == nil {
+			S.spaces = make(map[uint64]spaceEntry)
+		}
+		S.spaces[key] = meta.space
+	}
+}
+
+// forget removes the itemMeta of a removed item.
+// The shard must be locked.
+func (S *Shard) forget(key uint64) {
+	S.unindex(key)
+	if entry, ok := S.spaces[key]; ok {
+		delete(S.spaces, key)
+		entry.release()
+	}
+}
+
+// admit counts the item in the namespace before it is written.
+// A nil space keeps the item in its current namespace.
+// An error is returned if the item exceeds the limits of the namespace.
+// The shard must be locked.
+func (S *Shard) admit(key uint64, space *Namespace, size int64) error {
+	prev, member := S.spaces[key]
+	if space == nil {
+		space = prev.space
+	}
+	if space == nil {
+		return nil
+	}
+	items, bytes := int64(1), size
+	if member && prev.space == space {
+		items, bytes = 0, size-prev.size
+	}
+	if err := space.reserve(items, bytes); err != nil {
+		return err
+	}
+	if member && prev.space != space {
+		prev.release()
+	}
+	if S.spaces == nil {
+		S.spaces = make(map[uint64]spaceEntry)
+	}
+	S.spaces[key] = spaceEntry{space: space, size: size}
+	return nil
+}
+
+// clearSpaces releases all items of the shard from their namespaces.
+// The shard must be locked.
+func (S *Shard) clearSpaces() {
+	for _, entry := range S.spaces {
+		entry.release()
+	}
+	S.spaces = nil
+}
+
+// expireSpace removes the expired items of the namespace from the shard
+// and returns the amount of removed items.
+func (S *Shard) expireSpace(space *Namespace) int {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if S.isRetired() {
+		return 0
+	}
+	var keys []uint64
+	for key, entry := range S.spaces {
+		if entry.space == space && !S.alive(key) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		S.live(key)
+	}
+	return len(keys)
+}
+
+// flushSpace removes all items of the namespace from the shard
+// and returns the amount of removed items.
+func (S *Shard) flushSpace(space *Namespace) int {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if S.isRetired() {
+		return 0
+	}
+	var keys []uint64
+	for key, entry := range S.spaces {
+		if entry.space == space {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		S.hitExpirationService(key, ExpirationService.Remove)
+		S.notifyRemove(EventDelete, key, nil)
+		S.UnsafeDelete(key)
+		count(&S.metrics.deletes)
+	}
+	return len(keys)
+}
@@ -0,0 +1,103 @@
+package bigmap
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNamespace(t *testing.T) {
+	bm := New(16, Config{Shards: 4})
+	a, b := bm.Namespace("a"), bm.Namespace("b")
+	if bm.Namespace("a") != a {
+		t.Fatalf("Namespace returned a new handle for an existing name")
+	}
+	a.Put([]byte("key"), []byte("a"))
+	b.Put([]byte("key"), []byte("b"))
+	bm.Put([]byte("key"), []byte("map"))
+	for store, want := range map[Store]string{a: "a", b: "b", &bm: "map"} {
+		if val, ok := store.Get([]byte("key")); !ok || string(val) != want {
+			t.Fatalf("Get got %q, %v, want %q", val, ok, want)
+		}
+	}
+
+	alice, bob := bm.Namespace("alice"), bm.Namespace("bob")
+	alice.Put([]byte("bob"), []byte("alice"))
+	bob.Put([]byte("alice"), []byte("bob"))
+	if val, _ := alice.Get([]byte("bob")); string(val) != "alice" || alice.Len() != 1 {
+		t.Fatalf("swapped name and key overwrote the item, got %q", val)
+	}
+	alice.Delete([]byte("bob"))
+	bob.Delete([]byte("alice"))
+
+	a.Put([]byte("key"), []byte("aaaa"))
+	a.Put([]byte("other"), []byte("aa"))
+	if a.Len() != 2 || a.Bytes() != 6 {
+		t.Fatalf("expected 2 items of 6 bytes, got %d items of %d bytes", a.Len(), a.Bytes())
+	}
+	a.Delete([]byte("other"))
+	if a.Len() != 1 || a.Bytes() != 4 {
+		t.Fatalf("expected 1 item of 4 bytes after Delete, got %d items of %d bytes", a.Len(), a.Bytes())
+	}
+
+	for i := 0; i < 50; i++ {
+		a.Put([]byte(fmt.Sprint(i)), []byte("value"))
+	}
+	if err := bm.Reshard(3); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	if a.Len() != 51 {
+		t.Fatalf("expected 51 items after Reshard, got %d", a.Len())
+	}
+	if n := bm.FlushNamespace("a"); n != 51 || a.Len() != 0 || a.Bytes() != 0 {
+		t.Fatalf("FlushNamespace removed %d items, left %d items of %d bytes", n, a.Len(), a.Bytes())
+	}
+	if _, ok := a.Get([]byte("key")); ok {
+		t.Fatalf("item of a flushed namespace is contained")
+	}
+	if _, ok := b.Get([]byte("key")); !ok || bm.Len() != 2 {
+		t.Fatalf("FlushNamespace removed items of other namespaces")
+	}
+	bm.Clear()
+	if b.Len() != 0 {
+		t.Fatalf("expected no items after Clear, got %d", b.Len())
+	}
+}
+
+func TestNamespace_Limits(t *testing.T) {
+	bm := New(16, Config{Shards: 4})
+	ns := bm.Namespace("tenant", NamespaceConfig{MaxItems: 3, MaxBytes: 20})
+	for i := 0; i < 3; i++ {
+		if err := ns.Put([]byte(fmt.Sprint(i)), []byte("value")); err != nil {
+			t.Fatalf("Put within the limits: %v", err)
+		}
+	}
+	if err := ns.Put([]byte("3"), []byte("value")); !errors.Is(err, ErrNamespaceLimit) {
+		t.Fatalf("Put over the item limit got %v", err)
+	}
+	if err := ns.Put([]byte("0"), []byte("overwrite")); err != nil {
+		t.Fatalf("overwrite at the item limit: %v", err)
+	}
+	if err := ns.Put([]byte("1"), []byte("too large value")); !errors.Is(err, ErrNamespaceLimit) {
+		t.Fatalf("Put over the byte limit got %v", err)
+	}
+	if val, _ := ns.Get([]byte("1")); string(val) != "value" {
+		t.Fatalf("rejected Put changed the item to %q", val)
+	}
+	if ns.Len() != 3 || ns.Bytes() != 19 {
+		t.Fatalf("expected 3 items of 19 bytes, got %d items of %d bytes", ns.Len(), ns.Bytes())
+	}
+	ns.Expire([]byte("2"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if err := ns.Put([]byte("3"), []byte("value")); err != nil {
+		t.Fatalf("Put at the limits with an expired item: %v", err)
+	}
+	if ns.Len() != 3 {
+		t.Fatalf("expected the expired item to be removed, got %d items", ns.Len())
+	}
+	bm.Namespace("tenant", NamespaceConfig{})
+	if err := ns.Put([]byte("4"), []byte("value")); err != nil {
+		t.Fatalf("Put after removing the limits: %v", err)
+	}
+}
@@ -26,7 +26,7 @@ func (p *passiveExpirationService) Lock(key uint64, shard *Shard) {
 		p.accesses[key] = now
 		return
 	}
-	shard.UnsafeDelete(key)
+	shard.evict(key)
 	delete(p.accesses, key)
 }
 
@@ -40,3 +40,7 @@ func (p *passiveExpirationService) AfterAccess(key uint64, shard *Shard) {
 func (p *passiveExpirationService) Remove(key uint64, shard *Shard) {
 	delete(p.accesses, key)
 }
+
+func (p *passiveExpirationService) Clear(shard *Shard) {
+	p.accesses = make(map[uint64]int64)
+}
@@ -54,3 +54,21 @@ func (P *PointerQueue) Enqueue(ptr uint64) {
 		P.pointers = a
 	}
 }
+
+// Len returns the amount of pointers in the queue
+func (P *PointerQueue) Len() int {
+	return (P.writeIndex - P.readIndex + P.length) % P.length
+}
+
+// Clear removes all pointers from the queue
+// but keeps the allocated capacity
+func (P *PointerQueue) Clear() {
+	P.readIndex = 0
+	P.writeIndex = 0
+}
+
+// Reset removes all pointers from the queue
+// and releases the allocated capacity
+func (P *PointerQueue) Reset() {
+	*P = NewPointerQueue()
+}
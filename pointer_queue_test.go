@@ -43,3 +43,31 @@ func TestPointerQueue_Dequeue(t *testing.T) {
 		t.Fatalf("Dequeued empty Queue (%d, %t)", ptr, ok)
 	}
 }
+
+func TestPointerQueue_Clear(t *testing.T) {
+	queue := NewPointerQueue()
+	for i := uint64(0); i < 3000; i++ {
+		queue.Enqueue(i)
+	}
+	queue.Clear()
+	if ptr, ok := queue.Dequeue(); ok {
+		t.Fatalf("Dequeued cleared Queue (%d, %t)", ptr, ok)
+	}
+	queue.Enqueue(1)
+	if ptr, ok := queue.Dequeue(); ptr != 1 || !ok {
+		t.Fatalf("Dequeue after Clear got (%d, %t), want (1, true)", ptr, ok)
+	}
+}
+
+func TestPointerQueue_Len(t *testing.T) {
+	queue := NewPointerQueue()
+	for i := uint64(0); i < 300; i++ {
+		queue.Enqueue(i)
+	}
+	for i := 0; i < 100; i++ {
+		queue.Dequeue()
+	}
+	if queue.Len() != 200 {
+		t.Fatalf("expected 200 pointers, got %d", queue.Len())
+	}
+}
@@ -0,0 +1,9 @@
+//go:build race
+
+package bigmap
+
+// raceEnabled is set if the tests run with the race detector.
+// Reads of a shard are optimistic, they read the byte-array while
+// it may be written and verify the version of the lock afterwards.
+// The race detector can't see the verification and reports them.
+const raceEnabled = true
@@ -0,0 +1,220 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+// Follower applies the changes streamed by a Leader to a map.
+// The map must not be written by anyone else and
+// must use the same Hasher as the map of the leader.
+type Follower struct {
+	// ErrorLog logs the errors of the connections of Run.
+	// If nil the errors are discarded.
+	ErrorLog *log.Logger
+	// MaxValueSize is the size of the largest value accepted from
+	// the leader, larger values fail the connection.
+	//
+	// Default: the entrysize of the map, multiplied by the
+	// maximum ratio of compress/flate for compressed maps
+	MaxValueSize uint64
+
+	bm        *bigmap.BigMap
+	lock      sync.Mutex
+	epoch     uint64
+	offset    uint64
+	synced    bool
+	snapshots uint64
+}
+
+// NewFollower creates a new Follower which applies
+// the changes to the map.
+func NewFollower(bm *bigmap.BigMap) *Follower {
+	return &Follower{bm: bm}
+}
+
+// Position returns the epoch and the offset of the
+// next change expected from the leader.
+func (F *Follower) Position() (epoch, offset uint64) {
+	F.lock.Lock()
+	defer F.lock.Unlock()
+	return F.epoch, F.offset
+}
+
+// Synced returns true if the follower is connected and
+// received a complete snapshot or resumed from its offset.
+func (F *Follower) Synced() bool {
+	F.lock.Lock()
+	defer F.lock.Unlock()
+	return F.synced
+}
+
+// Run connects to the leader at the TCP address and follows it
+// until the context is done. Lost connections are reestablished
+// after the retry delay.
+// It always returns the error of the context.
+func (F *Follower) Run(ctx context.Context, addr string, retry time.Duration) error {
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			stop := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-stop:
+				}
+			}()
+			err = F.Sync(conn)
+			close(stop)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if F.ErrorLog != nil {
+			F.ErrorLog.Printf("replication: following %s: %v", addr, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// Sync follows the leader connected by conn until the connection
+// fails and closes it afterwards. It always returns a non-nil error.
+//
+// The map is cleared when the leader sends a new snapshot,
+// reads return partial results until the snapshot is complete.
+// A snapshot which is cut off is resent after reconnecting.
+// Sync must not be called concurrently.
+func (F *Follower) Sync(conn net.Conn) error {
+	defer conn.Close()
+	defer F.setSynced(false)
+	epoch, offset := F.Position()
+	if err := writeHello(conn, epoch, offset); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	var header [16]byte
+	var pending [2]uint64 // the epoch and offset of the current snapshot
+	value := make([]byte, F.bm.EntrySize())
+	structure := false
+	for {
+		op, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if structure && op != opItem && op != opPut {
+			return fmt.Errorf("replication: unexpected operation %q after structure", op)
+		}
+		switch op {
+		case opStructure:
+			structure = true
+		case opSnapshot, opResume:
+			if _, err := io.ReadFull(reader, header[:]); err != nil {
+				return err
+			}
+			epoch := binary.LittleEndian.Uint64(header[:])
+			offset := binary.LittleEndian.Uint64(header[8:])
+			F.lock.Lock()
+			if op == opResume && (epoch != F.epoch || offset != F.offset) {
+				F.lock.Unlock()
+				return fmt.Errorf("replication: resumed at %d:%d, expected %d:%d", epoch, offset, F.epoch, F.offset)
+			}
+			if op == opSnapshot {
+				// the position is kept once the snapshot is complete,
+				// a partial snapshot is resent after reconnecting.
+				pending = [2]uint64{epoch, offset}
+				epoch, offset = 0, 0
+				F.snapshots++
+				F.bm.Clear()
+			}
+			F.epoch, F.offset = epoch, offset
+			F.synced = op == opResume
+			F.lock.Unlock()
+		case opSnapshotEnd:
+			F.lock.Lock()
+			F.epoch, F.offset = pending[0], pending[1]
+			F.synced = true
+			F.lock.Unlock()
+		case opItem, opPut:
+			if _, err := io.ReadFull(reader, header[:]); err != nil {
+				return err
+			}
+			hash := binary.LittleEndian.Uint64(header[:])
+			ttl := time.Duration(binary.LittleEndian.Uint64(header[8:]))
+			size, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return err
+			}
+			if max := F.maxValueSize(); size > max {
+				return fmt.Errorf("replication: value size to long (%d > %d)", size, max)
+			}
+			if size > uint64(len(value)) {
+				// values of compressed maps can be larger than the entrysize
+				value = make([]byte, size)
+			}
+			if _, err := io.ReadFull(reader, value[:size]); err != nil {
+				return err
+			}
+			if structure {
+				err = F.bm.PutStructureHash(hash, value[:size], ttl)
+				structure = false
+			} else {
+				err = F.bm.PutHash(hash, value[:size], ttl)
+			}
+			if err != nil {
+				return err
+			}
+			if op == opPut {
+				F.advance()
+			}
+		case opDelete:
+			if _, err := io.ReadFull(reader, header[:8]); err != nil {
+				return err
+			}
+			F.bm.DeleteHash(binary.LittleEndian.Uint64(header[:]))
+			F.advance()
+		default:
+			return fmt.Errorf("replication: unknown operation %q", op)
+		}
+	}
+}
+
+// maxDeflateRatio is the largest ratio between the size of a value
+// and its compress/flate encoding.
+const maxDeflateRatio = 1032
+
+func (F *Follower) maxValueSize() uint64 {
+	if F.MaxValueSize != 0 {
+		return F.MaxValueSize
+	}
+	if F.bm.Config().Compression != 0 {
+		return F.bm.EntrySize() * maxDeflateRatio
+	}
+	return F.bm.EntrySize()
+}
+
+func (F *Follower) advance() {
+	F.lock.Lock()
+	F.offset++
+	F.lock.Unlock()
+}
+
+func (F *Follower) setSynced(synced bool) {
+	F.lock.Lock()
+	F.synced = synced
+	F.lock.Unlock()
+}
@@ -0,0 +1,332 @@
+package replication
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+// DefaultLogSize is the default amount of changes kept by a Leader.
+const DefaultLogSize = 65536
+
+// maxBatch is the amount of log entries copied at once
+// while streaming to a follower.
+const maxBatch = 256
+
+// Config configures a Leader.
+// Values which are 0 will become the default values.
+type Config struct {
+	// LogSize is the amount of changes kept for followers to resume
+	// from. Followers which fall further behind are resynced with
+	// a new snapshot.
+	//
+	// Default: 65536
+	LogSize int
+}
+
+type entry struct {
+	op        byte
+	hash      uint64
+	deadline  int64
+	value     []byte
+	structure bool
+}
+
+// Leader streams the changes of a map to followers.
+//
+// The changes are numbered by their offset in the log of the
+// current epoch. If the watcher of the map drops events the log
+// can't be replayed anymore and a new epoch is started, which
+// makes all followers resync.
+type Leader struct {
+	bm        *bigmap.BigMap
+	lock      sync.Mutex
+	changed   *sync.Cond
+	epoch     uint64
+	next      uint64
+	log       []entry
+	closed    bool
+	cancel    func()
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	snapshots uint64
+}
+
+// NewLeader creates a new Leader and starts recording the changes of
+// the map. The map must be created with Config.EventValues set.
+func NewLeader(bm *bigmap.BigMap, config ...Config) (*Leader, error) {
+	if !bm.Config().EventValues {
+		return nil, errors.New("replication: the map must be created with EventValues")
+	}
+	conf := Config{LogSize: DefaultLogSize}
+	if len(config) != 0 && config[0].LogSize > 0 {
+		conf.LogSize = config[0].LogSize
+	}
+	L := &Leader{
+		bm:        bm,
+		epoch:     nextEpoch(0),
+		log:       make([]entry, conf.LogSize),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	L.changed = sync.NewCond(&L.lock)
+	L.cancel = bm.Watch(L.record)
+	return L, nil
+}
+
+// nextEpoch returns a new epoch greater than the previous one.
+// Epochs are based on the time so a restarted leader
+// doesn't reuse the epoch of its previous run.
+func nextEpoch(prev uint64) uint64 {
+	epoch := uint64(time.Now().UnixNano())
+	if epoch <= prev {
+		epoch = prev + 1
+	}
+	return epoch
+}
+
+// Position returns the current epoch and the offset
+// of the next change.
+func (L *Leader) Position() (epoch, offset uint64) {
+	L.lock.Lock()
+	defer L.lock.Unlock()
+	return L.epoch, L.next
+}
+
+func (L *Leader) record(event bigmap.Event) {
+	L.lock.Lock()
+	defer L.lock.Unlock()
+	if L.closed {
+		return
+	}
+	defer L.changed.Broadcast()
+	if event.Type == bigmap.EventOverflow {
+		L.epoch = nextEpoch(L.epoch)
+		L.next = 0
+		return
+	}
+	e := &L.log[L.next%uint64(len(L.log))]
+	e.op = opDelete
+	e.hash = event.Hash
+	e.deadline = 0
+	e.value = e.value[:0]
+	e.structure = event.Structure
+	if event.Type == bigmap.EventPut {
+		e.op = opPut
+		e.value = append(e.value, event.Value...)
+		if event.TTL > 0 {
+			e.deadline = time.Now().Add(event.TTL).UnixNano()
+		}
+	}
+	L.next++
+}
+
+// ListenAndServe listens on the TCP address and serves
+// incoming followers.
+func (L *Leader) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return L.Serve(listener)
+}
+
+// Serve accepts followers on the listener and serves each
+// of them in a new goroutine.
+// It returns ErrLeaderClosed after Close was called.
+func (L *Leader) Serve(listener net.Listener) error {
+	if !L.track(listener, nil) {
+		listener.Close()
+		return ErrLeaderClosed
+	}
+	defer L.untrack(listener, nil)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if L.isClosed() {
+				return ErrLeaderClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go L.ServeConn(conn)
+	}
+}
+
+// ServeConn streams the changes of the map to the follower
+// connected by conn until the connection fails.
+// The follower is sent a snapshot first unless it
+// can resume from its offset in the log.
+func (L *Leader) ServeConn(conn net.Conn) error {
+	if !L.track(nil, conn) {
+		conn.Close()
+		return ErrLeaderClosed
+	}
+	defer L.untrack(nil, conn)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	epoch, offset, err := readHello(reader)
+	if err != nil {
+		return err
+	}
+	// followers don't send anything after the hello,
+	// reading detects when they disconnect.
+	var gone uint32
+	go func() {
+		io.Copy(io.Discard, reader)
+		atomic.StoreUint32(&gone, 1)
+		L.lock.Lock()
+		L.changed.Broadcast()
+		L.lock.Unlock()
+	}()
+
+	L.lock.Lock()
+	if epoch != L.epoch || offset > L.next || L.next-offset > uint64(len(L.log)) {
+		epoch, offset = L.epoch, L.next
+		L.lock.Unlock()
+		err = L.snapshot(writer, epoch, offset)
+	} else {
+		L.lock.Unlock()
+		err = writePosition(writer, opResume, epoch, offset)
+	}
+	if err != nil {
+		return err
+	}
+
+	batch := make([]entry, maxBatch)
+	for {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		L.lock.Lock()
+		for L.epoch == epoch && L.next == offset && !L.closed && atomic.LoadUint32(&gone) == 0 {
+			L.changed.Wait()
+		}
+		switch {
+		case L.closed:
+			L.lock.Unlock()
+			return ErrLeaderClosed
+		case atomic.LoadUint32(&gone) != 0:
+			L.lock.Unlock()
+			return io.EOF
+		case L.epoch != epoch:
+			L.lock.Unlock()
+			return errRestarted
+		case L.next-offset > uint64(len(L.log)):
+			L.lock.Unlock()
+			return errBehind
+		}
+		n := 0
+		for ; offset < L.next && n < len(batch); offset++ {
+			e := &L.log[offset%uint64(len(L.log))]
+			b := &batch[n]
+			b.op, b.hash, b.deadline, b.structure = e.op, e.hash, e.deadline, e.structure
+			b.value = append(b.value[:0], e.value...)
+			n++
+		}
+		L.lock.Unlock()
+		for _, e := range batch[:n] {
+			if e.op == opDelete {
+				err = writeDelete(writer, e.hash)
+			} else {
+				err = writeItem(writer, opPut, e.hash, remaining(e.deadline), e.value, e.structure)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// snapshot sends all items of the map. The changes made while the
+// snapshot is taken are logged after the offset and replayed by the
+// follower afterwards.
+func (L *Leader) snapshot(writer *bufio.Writer, epoch, offset uint64) (err error) {
+	atomic.AddUint64(&L.snapshots, 1)
+	if err := writePosition(writer, opSnapshot, epoch, offset); err != nil {
+		return err
+	}
+	L.bm.SnapshotItems(func(item bigmap.SnapshotItem) bool {
+		err = writeItem(writer, opItem, item.Hash, item.TTL, item.Value, item.Structure)
+		return err == nil
+	})
+	if err != nil {
+		return fmt.Errorf("replication snapshot: %w", err)
+	}
+	return writer.WriteByte(opSnapshotEnd)
+}
+
+// remaining returns the ttl left until the deadline.
+// Items which expired while they were logged are sent with
+// the smallest ttl, so they expire on the follower as well.
+func remaining(deadline int64) time.Duration {
+	if deadline == 0 {
+		return 0
+	}
+	ttl := time.Until(time.Unix(0, deadline))
+	if ttl <= 0 {
+		return time.Nanosecond
+	}
+	return ttl
+}
+
+// Close stops recording changes, stops all listeners
+// and disconnects all followers.
+func (L *Leader) Close() error {
+	L.cancel()
+	L.lock.Lock()
+	L.closed = true
+	L.changed.Broadcast()
+	for listener := range L.listeners {
+		listener.Close()
+	}
+	for conn := range L.conns {
+		conn.Close()
+	}
+	L.lock.Unlock()
+	L.wg.Wait()
+	return nil
+}
+
+func (L *Leader) track(listener net.Listener, conn net.Conn) bool {
+	L.lock.Lock()
+	defer L.lock.Unlock()
+	if L.closed {
+		return false
+	}
+	if listener != nil {
+		L.listeners[listener] = struct{}{}
+	}
+	if conn != nil {
+		L.conns[conn] = struct{}{}
+	}
+	L.wg.Add(1)
+	return true
+}
+
+func (L *Leader) untrack(listener net.Listener, conn net.Conn) {
+	L.lock.Lock()
+	delete(L.listeners, listener)
+	delete(L.conns, conn)
+	L.lock.Unlock()
+	L.wg.Done()
+}
+
+func (L *Leader) isClosed() bool {
+	L.lock.Lock()
+	defer L.lock.Unlock()
+	return L.closed
+}
@@ -0,0 +1,116 @@
+// Package replication copies the contents of a BigMap to follower maps
+// on other machines.
+//
+// The Leader records the changes of its map in a bounded log using
+// BigMap.Watch. A connecting Follower receives a snapshot of the map
+// followed by the changes of the log and applies them in order.
+// Followers which reconnect resume from the last applied offset of
+// the log if it is still retained or are resynced with a new snapshot.
+//
+// Items are replicated by the hash of their key, therefore followers
+// must use the same Hasher, including its seed, as the leader.
+// Clear and Reset of the leader map aren't replicated.
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// magic starts the hello of a follower.
+	magic = "BMR1"
+	// helloSize is the size of the hello of a follower:
+	// the magic, the epoch and the offset.
+	helloSize = len(magic) + 16
+)
+
+// Operations of the frames sent by the leader.
+const (
+	// opSnapshot starts a snapshot: epoch u64, offset u64.
+	opSnapshot byte = 'S'
+	// opItem is an item of a snapshot: hash u64, ttl i64, uvarint length, value.
+	opItem byte = 'I'
+	// opSnapshotEnd ends a snapshot.
+	opSnapshotEnd byte = 'E'
+	// opResume confirms the position of a follower: epoch u64, offset u64.
+	opResume byte = 'R'
+	// opPut is a logged put: hash u64, ttl i64, uvarint length, value.
+	opPut byte = 'P'
+	// opDelete is a logged removal: hash u64.
+	opDelete byte = 'D'
+	// opStructure precedes an item or a put whose value
+	// is a hash, list or set of the map.
+	opStructure byte = 'T'
+)
+
+var (
+	// ErrLeaderClosed is returned by the Leader after Close was called.
+	ErrLeaderClosed = errors.New("replication: leader closed")
+	errRestarted    = errors.New("replication: log restarted")
+	errBehind       = errors.New("replication: follower fell behind the log")
+)
+
+func writeHello(writer io.Writer, epoch, offset uint64) error {
+	var hello [helloSize]byte
+	copy(hello[:], magic)
+	binary.LittleEndian.PutUint64(hello[len(magic):], epoch)
+	binary.LittleEndian.PutUint64(hello[len(magic)+8:], offset)
+	_, err := writer.Write(hello[:])
+	return err
+}
+
+func readHello(reader io.Reader) (epoch, offset uint64, err error) {
+	var hello [helloSize]byte
+	if _, err := io.ReadFull(reader, hello[:]); err != nil {
+		return 0, 0, err
+	}
+	if string(hello[:len(magic)]) != magic {
+		return 0, 0, fmt.Errorf("replication: invalid hello %q", hello[:len(magic)])
+	}
+	epoch = binary.LittleEndian.Uint64(hello[len(magic):])
+	offset = binary.LittleEndian.Uint64(hello[len(magic)+8:])
+	return epoch, offset, nil
+}
+
+func writePosition(writer *bufio.Writer, op byte, epoch, offset uint64) error {
+	var frame [17]byte
+	frame[0] = op
+	binary.LittleEndian.PutUint64(frame[1:], epoch)
+	binary.LittleEndian.PutUint64(frame[9:], offset)
+	_, err := writer.Write(frame[:])
+	return err
+}
+
+// writeItem writes an item or a put.
+// A ttl of 0 means the item doesn't expire.
+// Structures are preceded by opStructure.
+func writeItem(writer *bufio.Writer, op byte, hash uint64, ttl time.Duration, val []byte, structure bool) error {
+	if structure {
+		if err := writer.WriteByte(opStructure); err != nil {
+			return err
+		}
+	}
+	var frame [17 + binary.MaxVarintLen64]byte
+	frame[0] = op
+	binary.LittleEndian.PutUint64(frame[1:], hash)
+	binary.LittleEndian.PutUint64(frame[9:], uint64(ttl))
+	n := 17 + binary.PutUvarint(frame[17:], uint64(len(val)))
+	if _, err := writer.Write(frame[:n]); err != nil {
+		return err
+	}
+	_, err := writer.Write(val)
+	return err
+}
+
+func writeDelete(writer *bufio.Writer, hash uint64) error {
+	var frame [9]byte
+	frame[0] = opDelete
+	binary.LittleEndian.PutUint64(frame[1:], hash)
+	_, err := writer.Write(frame[:])
+	return err
+}
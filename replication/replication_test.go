@@ -0,0 +1,265 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+func contents(bm *bigmap.BigMap) map[uint64]string {
+	items := map[uint64]string{}
+	bm.Snapshot(func(hash uint64, val []byte, ttl time.Duration) bool {
+		items[hash] = string(val)
+		return true
+	})
+	return items
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// caughtUp reports whether the leader recorded and the follower applied
+// the changes up to the offset. The position is locked, so the changes
+// can be read afterwards.
+func caughtUp(leader *Leader, follower *Follower, offset uint64) func() bool {
+	return func() bool {
+		_, recorded := leader.Position()
+		_, applied := follower.Position()
+		return follower.Synced() && recorded == offset && applied == offset
+	}
+}
+
+func follow(follower *Follower, addr string) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		follower.Run(ctx, addr, 5*time.Millisecond)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leaderMap := bigmap.New(16, bigmap.Config{Shards: 4, EventValues: true})
+	for i := 0; i < 100; i++ {
+		leaderMap.PutString(fmt.Sprintf("key-%d", i), []byte(fmt.Sprint(i)))
+	}
+	leader, err := NewLeader(&leaderMap, Config{LogSize: 16})
+	if err != nil {
+		t.Fatalf("NewLeader: %v", err)
+	}
+	defer leader.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leader.Serve(listener)
+	addr := listener.Addr().String()
+
+	followerMap := bigmap.New(16, bigmap.Config{Shards: 2})
+	follower := NewFollower(&followerMap)
+	synced := func() bool {
+		_, offset := leader.Position()
+		_, applied := follower.Position()
+		return follower.Synced() && offset == applied &&
+			reflect.DeepEqual(contents(&leaderMap), contents(&followerMap))
+	}
+
+	stop := follow(follower, addr)
+	eventually(t, "the snapshot", synced)
+	leaderMap.PutString("key-100", []byte("100"))
+	leaderMap.DeleteString("key-0")
+	leaderMap.PutTTL([]byte("ttl"), []byte("ttl"), time.Hour)
+	leaderMap.Expire([]byte("key-1"), time.Hour)
+	eventually(t, "the changes", synced)
+	if ttl, ok := followerMap.TTL([]byte("key-1")); !ok || ttl <= 0 {
+		t.Fatalf("expected the ttl to be replicated, got %v, %v", ttl, ok)
+	}
+	if _, ok := followerMap.GetString("key-0"); ok {
+		t.Fatalf("expected the delete to be replicated")
+	}
+	stop()
+
+	// the follower resumes from its offset
+	for i := 0; i < 10; i++ {
+		leaderMap.PutString(fmt.Sprintf("resume-%d", i), []byte("value"))
+	}
+	stop = follow(follower, addr)
+	eventually(t, "the resume", synced)
+	if follower.snapshots != 1 {
+		t.Fatalf("expected the follower to resume, got %d snapshots", follower.snapshots)
+	}
+	stop()
+
+	// the follower fell out of the log and is resynced
+	for i := 0; i < 20; i++ {
+		leaderMap.DeleteString(fmt.Sprintf("key-%d", 50+i))
+	}
+	stop = follow(follower, addr)
+	eventually(t, "the resync", synced)
+	if follower.snapshots != 2 {
+		t.Fatalf("expected the follower to resync, got %d snapshots", follower.snapshots)
+	}
+	stop()
+}
+
+func TestReplication_overflow(t *testing.T) {
+	leaderMap := bigmap.New(16, bigmap.Config{Shards: 1, EventValues: true, EventBuffer: 2})
+	leader, err := NewLeader(&leaderMap)
+	if err != nil {
+		t.Fatalf("NewLeader: %v", err)
+	}
+	defer leader.Close()
+	epoch, _ := leader.Position()
+	leader.lock.Lock()
+	for i := uint64(0); i < 10; i++ {
+		leaderMap.PutUint64(i, []byte("value"))
+	}
+	leader.lock.Unlock()
+	// the overflow is reported with the next event which fits
+	eventually(t, "a new epoch", func() bool {
+		leaderMap.PutUint64(10, []byte("value"))
+		restarted, _ := leader.Position()
+		return restarted != epoch
+	})
+}
+
+func TestNewLeader_values(t *testing.T) {
+	bm := bigmap.New(16)
+	if _, err := NewLeader(&bm); err == nil {
+		t.Fatalf("expected an error for a map without event values")
+	}
+}
+
+func TestReplication_compressed(t *testing.T) {
+	config := bigmap.Config{Shards: 2, EventValues: true, Compression: 6}
+	leaderMap := bigmap.New(64, config)
+	large := []byte(strings.Repeat("compressible ", 77))[:1000]
+	leaderMap.PutString("snapshot", large)
+	leader, err := NewLeader(&leaderMap)
+	if err != nil {
+		t.Fatalf("NewLeader: %v", err)
+	}
+	defer leader.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leader.Serve(listener)
+
+	followerMap := bigmap.New(64, config)
+	follower := NewFollower(&followerMap)
+	stop := follow(follower, listener.Addr().String())
+	defer stop()
+	eventually(t, "the snapshot", follower.Synced)
+	leaderMap.PutString("put", large)
+	eventually(t, "the put", caughtUp(leader, follower, 1))
+	if val, ok := followerMap.GetString("put"); !ok || !bytes.Equal(val, large) {
+		t.Fatalf("expected the large put, got %d bytes, %v", len(val), ok)
+	}
+	if val, ok := followerMap.GetString("snapshot"); !ok || !bytes.Equal(val, large) {
+		t.Fatalf("expected the large snapshot item, got %d bytes, %v", len(val), ok)
+	}
+}
+
+func TestFollower_partialSnapshot(t *testing.T) {
+	followerMap := bigmap.New(16)
+	follower := NewFollower(&followerMap)
+	leaderConn, followerConn := net.Pipe()
+	go func() {
+		defer leaderConn.Close()
+		if _, _, err := readHello(leaderConn); err != nil {
+			return
+		}
+		writer := bufio.NewWriter(leaderConn)
+		writePosition(writer, opSnapshot, 7, 3)
+		writeItem(writer, opItem, 1, 0, []byte("value"), false)
+		writer.Flush()
+		// the connection is cut before the end of the snapshot
+	}()
+	if err := follower.Sync(followerConn); err == nil {
+		t.Fatalf("expected an error of the cut connection")
+	}
+	if epoch, offset := follower.Position(); epoch != 0 || offset != 0 {
+		t.Fatalf("expected the partial snapshot to be resent, got position %d:%d", epoch, offset)
+	}
+}
+
+func TestReplication_structures(t *testing.T) {
+	leaderMap := bigmap.New(64, bigmap.Config{Shards: 2, EventValues: true})
+	leaderMap.HSet([]byte("hash"), []byte("field"), []byte("snapshot"))
+	leader, err := NewLeader(&leaderMap)
+	if err != nil {
+		t.Fatalf("NewLeader: %v", err)
+	}
+	defer leader.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go leader.Serve(listener)
+
+	followerMap := bigmap.New(64, bigmap.Config{Shards: 2})
+	follower := NewFollower(&followerMap)
+	stop := follow(follower, listener.Addr().String())
+	defer stop()
+	eventually(t, "the snapshot", follower.Synced)
+	if val, ok, err := followerMap.HGet([]byte("hash"), []byte("field")); err != nil || !ok || string(val) != "snapshot" {
+		t.Fatalf("expected the hash of the snapshot, got %q, %v, %v", val, ok, err)
+	}
+	leaderMap.LPush([]byte("list"), []byte("put"))
+	leaderMap.Expire([]byte("hash"), time.Hour)
+	eventually(t, "the changes", caughtUp(leader, follower, 2))
+	if ttl, _ := followerMap.TTL([]byte("hash")); ttl <= 0 {
+		t.Fatalf("expected the ttl of the hash to be replicated, got %v", ttl)
+	}
+	if val, ok, err := followerMap.RPop([]byte("list")); err != nil || !ok || string(val) != "put" {
+		t.Fatalf("expected the list of the put, got %q, %v, %v", val, ok, err)
+	}
+	if _, _, err := followerMap.HGet([]byte("hash"), []byte("field")); err != nil {
+		t.Fatalf("expected the expired hash to stay a structure, got %v", err)
+	}
+}
+
+func TestFollower_valueSize(t *testing.T) {
+	followerMap := bigmap.New(16)
+	follower := NewFollower(&followerMap)
+	leaderConn, followerConn := net.Pipe()
+	go func() {
+		defer leaderConn.Close()
+		if _, _, err := readHello(leaderConn); err != nil {
+			return
+		}
+		writer := bufio.NewWriter(leaderConn)
+		writePosition(writer, opSnapshot, 7, 3)
+		writer.Write([]byte{opItem})
+		writer.Write(make([]byte, 16))
+		var size [binary.MaxVarintLen64]byte
+		writer.Write(size[:binary.PutUvarint(size[:], 1<<60)])
+		writer.Flush()
+	}()
+	err := follower.Sync(followerConn)
+	if err == nil || !strings.Contains(err.Error(), "value size") {
+		t.Fatalf("expected the value size to be rejected, got %v", err)
+	}
+}
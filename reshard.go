@@ -0,0 +1,181 @@
+package bigmap
+
+import (
+	"fmt"
+	"time"
+)
+
+// shardTable is the set of shards of a BigMap.
+// While the map is resharded prev holds the shards
+// whose items are migrated into shards.
+type shardTable struct {
+	shards []*Shard
+	prev   []*Shard
+}
+
+func (T *shardTable) shardOf(hash uint64) *Shard {
+	return T.shards[hash%uint64(len(T.shards))]
+}
+
+func (T *shardTable) prevOf(hash uint64) *Shard {
+	return T.prev[hash%uint64(len(T.prev))]
+}
+
+func (B *BigMap) loadTable() *shardTable {
+	return B.table.Load().(*shardTable)
+}
+
+// Reshard changes the amount of shards of the map.
+//
+// The new shards are created upfront and the items are
+// migrated shard by shard. Reads and writes continue to be
+// served while the map is resharded, only accesses to
+// the shard which is currently migrated have to wait.
+//
+// Reshard blocks until all items are migrated.
+// Concurrent calls to Reshard are executed one after another.
+// The expiration of migrated items starts over in their new shard.
+func (B *BigMap) Reshard(shards int) error {
+	if shards <= 0 {
+		return fmt.Errorf("bigmap reshard: invalid shard count (%d)", shards)
+	}
+	B.resharder.Lock()
+	defer B.resharder.Unlock()
+	old := B.loadTable()
+	next := &shardTable{shards: B.newShards(shards)}
+	B.table.Store(&shardTable{shards: next.shards, prev: old.shards})
+	for _, s := range old.shards {
+		s.migrate(next.shardOf)
+	}
+	B.table.Store(next)
+	return nil
+}
+
+// ShardCount returns the current amount of shards.
+func (B *BigMap) ShardCount() int {
+	return len(B.loadTable().shards)
+}
+
+// write runs op on the shard of the hash.
+// op returns false if the shard was retired by a concurrent
+// reshard, the write is retried with the new table then.
+//
+// While a reshard is running the previous shard of the item
+// is locked and the item is handed over to its new shard
+// before op is run, so the migration can't overwrite it.
+// The shards of a table can only be retired after all previous
+// shards were migrated, therefore a write which found its previous
+// shard alive never has to be retried.
+func (B *BigMap) write(hash uint64, op func(shard *Shard) bool) {
+	for {
+		table := B.loadTable()
+		shard := table.shardOf(hash)
+		if table.prev != nil {
+			prev := table.prevOf(hash)
+			prev.lock.Lock()
+			if !prev.isRetired() {
+				prev.handOver(hash, shard)
+			}
+			ok := op(shard)
+			prev.lock.Unlock()
+			if ok {
+				return
+			}
+			continue
+		}
+		if op(shard) {
+			return
+		}
+	}
+}
+
+// read runs op on the shard of the hash.
+// op returns true if the item was found.
+//
+// While a reshard is running the item is read from its previous
+// shard first and from its new shard if the previous one
+// didn't contain it or was retired in the meantime.
+// If the shard was retired by a concurrent reshard the read
+// is retried with the new table.
+func (B *BigMap) read(hash uint64, op func(shard *Shard) bool) {
+	for {
+		table := B.loadTable()
+		if table.prev != nil {
+			prev := table.prevOf(hash)
+			if op(prev) && !prev.isRetired() {
+				return
+			}
+		}
+		shard := table.shardOf(hash)
+		op(shard)
+		if !shard.isRetired() {
+			return
+		}
+	}
+}
+
+func (B *BigMap) put(hash uint64, key, val []byte, deadline int64) error {
+	return B.putIn(nil, hash, key, val, deadline)
+}
+
+// putIn puts the item into the namespace like put.
+// A nil space keeps the item in its current namespace.
+func (B *BigMap) putIn(space *Namespace, hash uint64, key, val []byte, deadline int64) (err error) {
+	B.write(hash, func(shard *Shard) (ok bool) {
+		ok, err = shard.put(hash, key, val, deadline, space, false)
+		return ok
+	})
+	return err
+}
+
+// fits returns the error a put of the value would fail with
+// because of its size without writing it.
+func (B *BigMap) fits(key, val []byte) error {
+	h := B.hasher.Hash(key)
+	shard := B.loadTable().shardOf(h)
+	stored, _, err := shard.encode(h, val)
+	if err != nil {
+		return err
+	}
+	return shard.checkSize(stored)
+}
+
+func (B *BigMap) get(hash uint64) (val []byte, ok bool) {
+	B.read(hash, func(shard *Shard) bool {
+		val, ok = shard.Get(hash)
+		return ok
+	})
+	return val, ok
+}
+
+func (B *BigMap) getInto(hash uint64, buffer []byte) (size uint64, ok bool) {
+	B.read(hash, func(shard *Shard) bool {
+		size, ok = shard.GetInto(hash, buffer)
+		return ok
+	})
+	return size, ok
+}
+
+func (B *BigMap) ttl(hash uint64) (ttl time.Duration, ok bool) {
+	B.read(hash, func(shard *Shard) bool {
+		ttl, ok = shard.TTL(hash)
+		return ok
+	})
+	return ttl, ok
+}
+
+func (B *BigMap) expireAt(hash uint64, key []byte, deadline int64) (ok bool) {
+	B.write(hash, func(shard *Shard) (live bool) {
+		ok, live = shard.expireAt(hash, key, deadline)
+		return live
+	})
+	return ok
+}
+
+func (B *BigMap) delete(hash uint64, key []byte) (deleted bool) {
+	B.write(hash, func(shard *Shard) (ok bool) {
+		deleted, ok = shard.delete(hash, key)
+		return ok
+	})
+	return deleted
+}
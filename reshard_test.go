@@ -0,0 +1,98 @@
+package bigmap
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBigMap_Reshard(t *testing.T) {
+	bigmap := New(100, Config{Shards: 4})
+	keys := PopulateMap(4096, &bigmap)
+	if err := bigmap.Reshard(7); err != nil {
+		t.Fatalf("reshard: %v", err)
+	}
+	if bigmap.ShardCount() != 7 {
+		t.Fatalf("ShardCount() got %d, want %d", bigmap.ShardCount(), 7)
+	}
+	for _, key := range keys {
+		if _, ok := bigmap.Get(key); !ok {
+			t.Fatalf("Get(%q) after Reshard got !ok, want ok", key)
+		}
+	}
+	if err := bigmap.Reshard(0); err == nil {
+		t.Fatalf("Reshard(0) got nil, want err")
+	}
+}
+
+func TestBigMap_Reshard_copy(t *testing.T) {
+	bigmap := New(100, Config{Shards: 2})
+	copied := bigmap
+	bigmap.Put([]byte("key"), []byte("value"))
+	if err := bigmap.Reshard(5); err != nil {
+		t.Fatalf("reshard: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		copied.Put([]byte("copy"), []byte("value"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Put through a copy of the map hangs after Reshard")
+	}
+	if _, ok := bigmap.Get([]byte("copy")); !ok || copied.loadTable() != bigmap.loadTable() {
+		t.Fatalf("copy of the map doesn't share the resharded shards")
+	}
+}
+
+func TestBigMap_Reshard_concurrent(t *testing.T) {
+	if raceEnabled {
+		t.Skip("optimistic reads of concurrent writes are reported by the race detector")
+	}
+	bigmap := New(100, Config{Shards: 2})
+	keys := PopulateMap(4096, &bigmap)
+	var done int32
+	var failed int32
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			buff := make([]byte, 100)
+			for i := 0; atomic.LoadInt32(&done) == 0; i++ {
+				key := GenSafeKey(worker, i%512)
+				val := []byte(strconv.Itoa(i))
+				bigmap.Put(key, val)
+				size, ok := bigmap.GetInto(key, buff)
+				if !ok || string(buff[:size]) != string(val) {
+					atomic.StoreInt32(&failed, 1)
+				}
+				if _, ok := bigmap.Get(keys[i%len(keys)]); !ok {
+					atomic.StoreInt32(&failed, 1)
+				}
+				if !bigmap.Delete(key) {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}(strconv.Itoa(w))
+	}
+	for _, n := range []int{5, 16, 3, 32} {
+		if err := bigmap.Reshard(n); err != nil {
+			t.Fatalf("reshard: %v", err)
+		}
+	}
+	atomic.StoreInt32(&done, 1)
+	wg.Wait()
+	if failed != 0 {
+		t.Fatalf("concurrent access failed while resharding")
+	}
+	for _, key := range keys {
+		if _, ok := bigmap.Get(key); !ok {
+			t.Fatalf("Get(%q) after Reshard got !ok, want ok", key)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package resp
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxIdle is the default amount of idle connections
+// kept by a Client.
+const DefaultMaxIdle = 8
+
+// ErrClientClosed is returned by a Client after Close was called.
+var ErrClientClosed = errors.New("resp: client closed")
+
+// Client is a client of a RESP2 server like bigmapd.
+// It implements bigmap.Store and is safe for concurrent use,
+// the connections are pooled.
+//
+// Get, GetInto, Delete, TTL and Expire treat failed requests
+// like misses, use Do to inspect errors.
+type Client struct {
+	// Timeout limits the time of a request including dialing.
+	// A Timeout of 0 means no limit.
+	Timeout time.Duration
+	// MaxIdle is the amount of idle connections kept for reuse.
+	//
+	// Default: 8
+	MaxIdle int
+
+	addr   string
+	lock   sync.Mutex
+	idle   []*clientConn
+	closed bool
+}
+
+type clientConn struct {
+	conn   net.Conn
+	reader *Reader
+	writer *Writer
+}
+
+// NewClient creates a new Client for the server at the TCP address.
+// Connections are established on demand.
+func NewClient(addr string) *Client {
+	return &Client{addr: addr, MaxIdle: DefaultMaxIdle}
+}
+
+// Addr returns the address of the server.
+func (C *Client) Addr() string {
+	return C.addr
+}
+
+// Do sends the command and returns its reply.
+// An Error reply isn't returned as error, see Reply.Err.
+func (C *Client) Do(args ...[]byte) (Reply, error) {
+	conn, err := C.get()
+	if err != nil {
+		return Reply{}, err
+	}
+	if C.Timeout > 0 {
+		conn.conn.SetDeadline(time.Now().Add(C.Timeout))
+	}
+	conn.writer.WriteCommand(args...)
+	if err := conn.writer.Flush(); err != nil {
+		conn.conn.Close()
+		return Reply{}, err
+	}
+	reply, err := conn.reader.ReadReply()
+	if err != nil {
+		conn.conn.Close()
+		return Reply{}, err
+	}
+	C.put(conn)
+	return reply, nil
+}
+
+// Put stores the item on the server.
+func (C *Client) Put(key, val []byte) error {
+	return C.set(key, val)
+}
+
+// PutTTL stores the item on the server which expires after the ttl.
+// A ttl smaller or equal to 0 never expires.
+func (C *Client) PutTTL(key, val []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return C.set(key, val)
+	}
+	return C.set(key, val, []byte("PX"), strconv.AppendInt(nil, milliseconds(ttl), 10))
+}
+
+func (C *Client) set(key, val []byte, options ...[]byte) error {
+	args := append([][]byte{[]byte("SET"), key, val}, options...)
+	reply, err := C.Do(args...)
+	if err != nil {
+		return err
+	}
+	return reply.Err()
+}
+
+// Get retrieves the item of the key from the server.
+func (C *Client) Get(key []byte) ([]byte, bool) {
+	reply, err := C.Do([]byte("GET"), key)
+	if err != nil || reply.Type != BulkString || reply.Null {
+		return nil, false
+	}
+	return reply.Str, true
+}
+
+// GetInto retrieves the item of the key and writes it into buffer.
+// Returns the size, true if the item was contained and 0, false otherwise.
+func (C *Client) GetInto(key, buffer []byte) (uint64, bool) {
+	val, ok := C.Get(key)
+	if !ok {
+		return 0, false
+	}
+	copy(buffer, val)
+	return uint64(len(val)), true
+}
+
+// Delete removes the item of the key from the server
+// and returns true if it was contained.
+func (C *Client) Delete(key []byte) bool {
+	reply, err := C.Do([]byte("DEL"), key)
+	return err == nil && reply.Type == Integer && reply.Int > 0
+}
+
+// TTL returns the remaining time to live of the item and
+// true if it is contained.
+// A TTL of 0 means the item doesn't expire.
+func (C *Client) TTL(key []byte) (time.Duration, bool) {
+	reply, err := C.Do([]byte("PTTL"), key)
+	if err != nil || reply.Type != Integer || reply.Int == -2 {
+		return 0, false
+	}
+	if reply.Int == -1 {
+		return 0, true
+	}
+	return time.Duration(reply.Int) * time.Millisecond, true
+}
+
+// Expire sets the time to live of an existing item.
+// A ttl smaller or equal to 0 removes the expiration of the item.
+// It returns false if the item isn't contained.
+func (C *Client) Expire(key []byte, ttl time.Duration) bool {
+	if ttl <= 0 {
+		reply, err := C.Do([]byte("PERSIST"), key)
+		if err != nil || reply.Type != Integer {
+			return false
+		}
+		if reply.Int == 1 {
+			return true
+		}
+		// PERSIST doesn't count items without a ttl
+		reply, err = C.Do([]byte("EXISTS"), key)
+		return err == nil && reply.Type == Integer && reply.Int > 0
+	}
+	reply, err := C.Do([]byte("PEXPIRE"), key, strconv.AppendInt(nil, milliseconds(ttl), 10))
+	return err == nil && reply.Type == Integer && reply.Int > 0
+}
+
+// milliseconds rounds the ttl up to whole milliseconds.
+func milliseconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// Close closes the idle connections.
+// Requests after Close return ErrClientClosed.
+func (C *Client) Close() error {
+	C.lock.Lock()
+	defer C.lock.Unlock()
+	C.closed = true
+	for _, conn := range C.idle {
+		conn.conn.Close()
+	}
+	C.idle = nil
+	return nil
+}
+
+func (C *Client) get() (*clientConn, error) {
+	C.lock.Lock()
+	if C.closed {
+		C.lock.Unlock()
+		return nil, ErrClientClosed
+	}
+	if n := len(C.idle); n > 0 {
+		conn := C.idle[n-1]
+		C.idle = C.idle[:n-1]
+		C.lock.Unlock()
+		return conn, nil
+	}
+	C.lock.Unlock()
+	conn, err := net.DialTimeout("tcp", C.addr, C.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{conn: conn, reader: NewReader(conn), writer: NewWriter(conn)}, nil
+}
+
+func (C *Client) put(conn *clientConn) {
+	if C.Timeout > 0 {
+		conn.conn.SetDeadline(time.Time{})
+	}
+	C.lock.Lock()
+	defer C.lock.Unlock()
+	if C.closed || len(C.idle) >= C.MaxIdle {
+		conn.conn.Close()
+		return
+	}
+	C.idle = append(C.idle, conn)
+}
@@ -0,0 +1,70 @@
+package resp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+	"github.com/worldOneo/bigmap/storetest"
+)
+
+func TestClient(t *testing.T) {
+	bm := bigmap.New(EntrySize(64, 64), bigmap.Config{Shards: 4})
+	server := NewServer(&bm)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := NewClient(listener.Addr().String())
+	client.Timeout = time.Second
+	if err := client.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if val, ok := client.Get([]byte("key")); !ok || string(val) != "value" {
+		t.Fatalf("Get got %q, %v", val, ok)
+	}
+	if err := client.PutTTL([]byte("ttl"), []byte("value"), time.Hour); err != nil {
+		t.Fatalf("PutTTL: %v", err)
+	}
+	reply, err := client.Do([]byte("PTTL"), []byte("ttl"))
+	if err != nil || reply.Int <= 0 {
+		t.Fatalf("PTTL got %+v, %v", reply, err)
+	}
+	buffer := make([]byte, 16)
+	if size, ok := client.GetInto([]byte("key"), buffer); !ok || string(buffer[:size]) != "value" {
+		t.Fatalf("GetInto got %q, %v", buffer[:size], ok)
+	}
+	if !client.Delete([]byte("key")) || client.Delete([]byte("key")) {
+		t.Fatalf("expected exactly one delete")
+	}
+	if err := client.Put([]byte("key"), make([]byte, 200)); err == nil {
+		t.Fatalf("expected an error for a too large value")
+	}
+
+	client.Close()
+	if _, err := client.Do([]byte("PING")); err != ErrClientClosed {
+		t.Fatalf("Do after Close got %v, want %v", err, ErrClientClosed)
+	}
+}
+
+func TestClient_conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) bigmap.Store {
+		bm := bigmap.New(EntrySize(32, 64), bigmap.Config{Shards: 4})
+		server := NewServer(&bm)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		go server.Serve(listener)
+		client := NewClient(listener.Addr().String())
+		t.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+		return client
+	})
+}
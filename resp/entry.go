@@ -0,0 +1,31 @@
+package resp
+
+import (
+	"encoding/binary"
+)
+
+// Items are stored as [uvarint key length][key][value]
+// so the keys can be compared on lookups and listed by SCAN.
+
+// EntrySize returns the entrysize a BigMap requires to
+// store keys and values of the given maximum sizes.
+func EntrySize(maxKey, maxValue int) uint64 {
+	return uint64(binary.MaxVarintLen64 + maxKey + maxValue)
+}
+
+func appendEntry(dst, key, val []byte) []byte {
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(key)))
+	dst = append(dst, length[:n]...)
+	dst = append(dst, key...)
+	return append(dst, val...)
+}
+
+func splitEntry(entry []byte) ([]byte, []byte, bool) {
+	length, n := binary.Uvarint(entry)
+	if n <= 0 || uint64(len(entry)-n) < length {
+		return nil, nil, false
+	}
+	key := entry[n : n+int(length)]
+	return key, entry[n+int(length):], true
+}
@@ -0,0 +1,86 @@
+package resp
+
+// Match reports whether s matches the glob-style pattern
+// as used by the SCAN and KEYS commands of redis.
+//
+// The pattern supports '*', '?', character classes like
+// '[a-z]' or '[^abc]' and '\' to escape special characters.
+func Match(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if Match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var ok bool
+			pattern, ok = matchClass(pattern[1:], s[0])
+			if !ok {
+				return false
+			}
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the class at the start of pattern
+// and returns the pattern after the class.
+func matchClass(pattern []byte, c byte) ([]byte, bool) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		if pattern[0] == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			match = match || pattern[0] == c
+			pattern = pattern[1:]
+			continue
+		}
+		if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (lo <= c && c <= hi)
+			pattern = pattern[3:]
+			continue
+		}
+		match = match || pattern[0] == c
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return pattern, match != negate
+}
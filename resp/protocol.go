@@ -0,0 +1,298 @@
+// Package resp implements the RESP2 protocol used by redis
+// and a server which serves a BigMap over it.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// MaxBulkLength is the maximum size of a single argument
+	MaxBulkLength = 512 << 20
+	// MaxArguments is the maximum amount of arguments of a command
+	MaxArguments = 1 << 20
+)
+
+// ErrProtocol is returned if the peer doesn't speak RESP2.
+var ErrProtocol = errors.New("resp: protocol error")
+
+// Reply types of RESP2
+const (
+	SimpleString byte = '+'
+	Error        byte = '-'
+	Integer      byte = ':'
+	BulkString   byte = '$'
+	Array        byte = '*'
+)
+
+// Reply is a decoded RESP2 reply.
+type Reply struct {
+	// Type is one of SimpleString, Error, Integer, BulkString and Array.
+	Type byte
+	// Str holds the value of SimpleString, Error and BulkString replies.
+	Str []byte
+	// Int holds the value of Integer replies.
+	Int int64
+	// Array holds the elements of Array replies.
+	Array []Reply
+	// Null is true for null bulk strings and null arrays.
+	Null bool
+}
+
+// Err returns the error of an Error reply or nil.
+func (R Reply) Err() error {
+	if R.Type == Error {
+		return errors.New(string(R.Str))
+	}
+	return nil
+}
+
+// Reader reads commands and replies from a connection.
+type Reader struct {
+	reader  *bufio.Reader
+	args    [][]byte
+	offsets []int
+	buffer  []byte
+}
+
+// NewReader creates a new Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// Buffered returns the amount of bytes which can be
+// read without blocking.
+func (R *Reader) Buffered() int {
+	return R.reader.Buffered()
+}
+
+// ReadCommand reads the next command.
+// Commands are either arrays of bulk strings or inline commands.
+// The returned arguments are only valid until the next read.
+func (R *Reader) ReadCommand() ([][]byte, error) {
+	line, err := R.readLine()
+	if err != nil {
+		return nil, err
+	}
+	R.buffer = R.buffer[:0]
+	R.offsets = R.offsets[:0]
+	if len(line) == 0 || line[0] != Array {
+		for _, field := range bytes.Fields(line) {
+			R.buffer = append(R.buffer, field...)
+			R.offsets = append(R.offsets, len(R.buffer))
+		}
+		return R.arguments(), nil
+	}
+	n, err := parseInt(line[1:])
+	if err != nil || n > MaxArguments {
+		return nil, ErrProtocol
+	}
+	for i := int64(0); i < n; i++ {
+		line, err := R.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != BulkString {
+			return nil, ErrProtocol
+		}
+		if err := R.readBulk(line[1:]); err != nil {
+			return nil, err
+		}
+		R.offsets = append(R.offsets, len(R.buffer))
+	}
+	return R.arguments(), nil
+}
+
+// ReadReply reads the next reply.
+func (R *Reader) ReadReply() (Reply, error) {
+	line, err := R.readLine()
+	if err != nil {
+		return Reply{}, err
+	}
+	if len(line) == 0 {
+		return Reply{}, ErrProtocol
+	}
+	reply := Reply{Type: line[0]}
+	switch line[0] {
+	case SimpleString, Error:
+		reply.Str = append([]byte(nil), line[1:]...)
+	case Integer:
+		reply.Int, err = parseInt(line[1:])
+	case BulkString:
+		R.buffer = R.buffer[:0]
+		if bytes.Equal(line[1:], []byte("-1")) {
+			reply.Null = true
+			break
+		}
+		if err = R.readBulk(line[1:]); err == nil {
+			reply.Str = append([]byte(nil), R.buffer...)
+		}
+	case Array:
+		var n int64
+		n, err = parseInt(line[1:])
+		if n < 0 {
+			reply.Null = true
+			break
+		}
+		if err != nil || n > MaxArguments {
+			return reply, ErrProtocol
+		}
+		reply.Array = make([]Reply, n)
+		for i := range reply.Array {
+			if reply.Array[i], err = R.ReadReply(); err != nil {
+				break
+			}
+		}
+	default:
+		return reply, ErrProtocol
+	}
+	return reply, err
+}
+
+func (R *Reader) arguments() [][]byte {
+	R.args = R.args[:0]
+	start := 0
+	for _, end := range R.offsets {
+		R.args = append(R.args, R.buffer[start:end:end])
+		start = end
+	}
+	return R.args
+}
+
+func (R *Reader) readLine() ([]byte, error) {
+	line, err := R.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, ErrProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})
+	return line, nil
+}
+
+func (R *Reader) readBulk(length []byte) error {
+	n, err := parseInt(length)
+	if err != nil || n < 0 || n > MaxBulkLength {
+		return ErrProtocol
+	}
+	start := len(R.buffer)
+	for int64(cap(R.buffer)-start) < n+2 {
+		R.buffer = append(R.buffer[:cap(R.buffer)], 0)
+	}
+	R.buffer = R.buffer[:start+int(n)+2]
+	if _, err := io.ReadFull(R.reader, R.buffer[start:]); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(R.buffer, []byte("\r\n")) {
+		return ErrProtocol
+	}
+	R.buffer = R.buffer[:start+int(n)]
+	return nil
+}
+
+func parseInt(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, ErrProtocol
+	}
+	negative := b[0] == '-'
+	if negative {
+		b = b[1:]
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' || n > (1<<62)/10 {
+			return 0, ErrProtocol
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if negative {
+		n = -n
+	}
+	return n, nil
+}
+
+// Writer writes RESP2 replies and commands to a connection.
+type Writer struct {
+	writer *bufio.Writer
+	number []byte
+}
+
+// NewWriter creates a new Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: bufio.NewWriter(w)}
+}
+
+// Flush writes any buffered data to the connection.
+func (W *Writer) Flush() error {
+	return W.writer.Flush()
+}
+
+// WriteSimple writes a simple string.
+func (W *Writer) WriteSimple(s string) {
+	W.writer.WriteByte(SimpleString)
+	W.writer.WriteString(s)
+	W.writer.WriteString("\r\n")
+}
+
+// WriteError writes an error.
+func (W *Writer) WriteError(s string) {
+	W.writer.WriteByte(Error)
+	W.writer.WriteString(s)
+	W.writer.WriteString("\r\n")
+}
+
+// WriteErrorf writes a formatted error.
+func (W *Writer) WriteErrorf(format string, args ...interface{}) {
+	W.WriteError(fmt.Sprintf(format, args...))
+}
+
+// WriteInt writes an integer.
+func (W *Writer) WriteInt(n int64) {
+	W.writePrefixed(Integer, n)
+}
+
+// WriteBulk writes a bulk string.
+func (W *Writer) WriteBulk(b []byte) {
+	W.writePrefixed(BulkString, int64(len(b)))
+	W.writer.Write(b)
+	W.writer.WriteString("\r\n")
+}
+
+// WriteBulkString writes a bulk string.
+func (W *Writer) WriteBulkString(s string) {
+	W.writePrefixed(BulkString, int64(len(s)))
+	W.writer.WriteString(s)
+	W.writer.WriteString("\r\n")
+}
+
+// WriteNull writes a null bulk string.
+func (W *Writer) WriteNull() {
+	W.writer.WriteString("$-1\r\n")
+}
+
+// WriteArray writes the header of an array of n elements.
+// The elements must be written afterwards.
+func (W *Writer) WriteArray(n int) {
+	W.writePrefixed(Array, int64(n))
+}
+
+// WriteCommand writes a command as array of bulk strings.
+func (W *Writer) WriteCommand(args ...[]byte) {
+	W.WriteArray(len(args))
+	for _, arg := range args {
+		W.WriteBulk(arg)
+	}
+}
+
+func (W *Writer) writePrefixed(prefix byte, n int64) {
+	W.writer.WriteByte(prefix)
+	W.number = strconv.AppendInt(W.number[:0], n, 10)
+	W.writer.Write(W.number)
+	W.writer.WriteString("\r\n")
+}
@@ -0,0 +1,71 @@
+package resp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReader_ReadCommand(t *testing.T) {
+	reader := NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\nPING  hello\r\n"))
+	args, err := reader.ReadCommand()
+	if err != nil {
+		t.Fatalf("read command: %v", err)
+	}
+	if len(args) != 3 || string(args[0]) != "SET" || string(args[1]) != "key" || string(args[2]) != "va\r\nl" {
+		t.Fatalf("ReadCommand() got %q, want [SET key va\\r\\nl]", args)
+	}
+	args, err = reader.ReadCommand()
+	if err != nil {
+		t.Fatalf("read command: %v", err)
+	}
+	if len(args) != 2 || string(args[0]) != "PING" || string(args[1]) != "hello" {
+		t.Fatalf("ReadCommand() got %q, want [PING hello]", args)
+	}
+}
+
+func TestReader_ReadReply(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := NewWriter(&buffer)
+	writer.WriteArray(4)
+	writer.WriteSimple("OK")
+	writer.WriteInt(-12)
+	writer.WriteBulk([]byte("bulk"))
+	writer.WriteNull()
+	writer.Flush()
+	reply, err := NewReader(&buffer).ReadReply()
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if reply.Type != Array || len(reply.Array) != 4 {
+		t.Fatalf("ReadReply() got %+v, want array of 4", reply)
+	}
+	if string(reply.Array[0].Str) != "OK" || reply.Array[1].Int != -12 ||
+		string(reply.Array[2].Str) != "bulk" || !reply.Array[3].Null {
+		t.Fatalf("ReadReply() got %+v", reply.Array)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*:*:end", "a:b:end", true},
+	}
+	for _, test := range tests {
+		if got := Match([]byte(test.pattern), []byte(test.s)); got != test.want {
+			t.Errorf("Match(%q, %q) got %v, want %v", test.pattern, test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,634 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+// ErrServerClosed is returned by Serve after the server was closed.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Server serves a BigMap over the RESP2 protocol
+// so redis clients can access it.
+//
+// Keys are stored alongside their values therefore
+// the entrysize of the map must cover both.
+// See EntrySize
+type Server struct {
+	bigmap    *bigmap.BigMap
+	started   time.Time
+	commands  uint64
+	clients   int64
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer creates a new Server serving the bigmap.
+func NewServer(bm *bigmap.BigMap) *Server {
+	return &Server{
+		bigmap:    bm,
+		started:   time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves
+// incoming connections.
+func (S *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return S.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves each
+// of them in a new goroutine.
+// It returns ErrServerClosed after Close was called.
+func (S *Server) Serve(listener net.Listener) error {
+	if !S.track(listener, nil) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	defer S.untrack(listener, nil)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if S.isClosed() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go S.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection until
+// it is closed or the client quits.
+func (S *Server) ServeConn(conn net.Conn) {
+	if !S.track(nil, conn) {
+		conn.Close()
+		return
+	}
+	defer S.untrack(nil, conn)
+	defer conn.Close()
+	atomic.AddInt64(&S.clients, 1)
+	defer atomic.AddInt64(&S.clients, -1)
+
+	c := &client{
+		server: S,
+		reader: NewReader(conn),
+		writer: NewWriter(conn),
+		buffer: make([]byte, S.bigmap.EntrySize()),
+	}
+	for !c.quit {
+		args, err := c.reader.ReadCommand()
+		if err != nil {
+			if err == ErrProtocol {
+				c.writer.WriteError("ERR Protocol error")
+				c.writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		atomic.AddUint64(&S.commands, 1)
+		c.execute(args)
+		if c.reader.Buffered() == 0 {
+			if err := c.writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+	c.writer.Flush()
+}
+
+// Close stops all listeners and closes all connections.
+func (S *Server) Close() error {
+	S.lock.Lock()
+	S.closed = true
+	for listener := range S.listeners {
+		listener.Close()
+	}
+	for conn := range S.conns {
+		conn.Close()
+	}
+	S.lock.Unlock()
+	S.wg.Wait()
+	return nil
+}
+
+func (S *Server) track(listener net.Listener, conn net.Conn) bool {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if S.closed {
+		return false
+	}
+	if listener != nil {
+		S.listeners[listener] = struct{}{}
+	}
+	if conn != nil {
+		S.conns[conn] = struct{}{}
+	}
+	S.wg.Add(1)
+	return true
+}
+
+func (S *Server) untrack(listener net.Listener, conn net.Conn) {
+	S.lock.Lock()
+	delete(S.listeners, listener)
+	delete(S.conns, conn)
+	S.lock.Unlock()
+	S.wg.Done()
+}
+
+func (S *Server) isClosed() bool {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	return S.closed
+}
+
+type client struct {
+	server *Server
+	reader *Reader
+	writer *Writer
+	buffer []byte
+	entry  []byte
+	quit   bool
+}
+
+type command struct {
+	handler func(c *client, args [][]byte)
+	// arity is the amount of arguments including the command name.
+	// A negative arity is the minimum amount of arguments.
+	arity int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":     {(*client).ping, -1},
+		"echo":     {(*client).echo, 2},
+		"quit":     {(*client).quitCommand, 1},
+		"select":   {(*client).selectCommand, 2},
+		"client":   {(*client).clientCommand, -2},
+		"command":  {(*client).commandCommand, -1},
+		"get":      {(*client).get, 2},
+		"set":      {(*client).set, -3},
+		"del":      {(*client).del, -2},
+		"exists":   {(*client).exists, -2},
+		"mget":     {(*client).mget, -2},
+		"mset":     {(*client).mset, -3},
+		"incr":     {(*client).incr, 2},
+		"incrby":   {(*client).incrby, 3},
+		"decr":     {(*client).decr, 2},
+		"decrby":   {(*client).decrby, 3},
+		"expire":   {(*client).expire, 3},
+		"pexpire":  {(*client).pexpire, 3},
+		"persist":  {(*client).persist, 2},
+		"ttl":      {(*client).ttl, 2},
+		"pttl":     {(*client).pttl, 2},
+		"dbsize":   {(*client).dbsize, 1},
+		"flushdb":  {(*client).flush, -1},
+		"flushall": {(*client).flush, -1},
+		"info":     {(*client).info, -1},
+		"scan":     {(*client).scan, -2},
+	}
+}
+
+func (c *client) execute(args [][]byte) {
+	name := args[0]
+	for i, b := range name {
+		if 'A' <= b && b <= 'Z' {
+			name[i] = b + 'a' - 'A'
+		}
+	}
+	cmd, ok := commands[string(name)]
+	if !ok {
+		c.writer.WriteErrorf("ERR unknown command '%s'", name)
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		c.writer.WriteErrorf("ERR wrong number of arguments for '%s' command", name)
+		return
+	}
+	cmd.handler(c, args)
+}
+
+// lookup returns the value of the key.
+// The value is only valid until the next lookup.
+func (c *client) lookup(key []byte) ([]byte, bool) {
+	size, ok := c.server.bigmap.GetInto(key, c.buffer)
+	for ok && size > uint64(len(c.buffer)) {
+		// compressed items can be larger than the entrysize
+		c.buffer = make([]byte, size)
+		size, ok = c.server.bigmap.GetInto(key, c.buffer)
+	}
+	if !ok {
+		return nil, false
+	}
+	entryKey, val, ok := splitEntry(c.buffer[:size])
+	if !ok || !bytes.Equal(entryKey, key) {
+		return nil, false
+	}
+	return val, true
+}
+
+// update runs fn on the value of the key while it is locked.
+// Items of colliding keys are treated as missing.
+func (c *client) update(key []byte, fn func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, bigmap.UpdateOp)) error {
+	return c.server.bigmap.Update(key, func(entry []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, bigmap.UpdateOp) {
+		var val []byte
+		if ok {
+			var entryKey []byte
+			entryKey, val, ok = splitEntry(entry)
+			ok = ok && bytes.Equal(entryKey, key)
+		}
+		if !ok {
+			val, ttl = nil, 0
+		}
+		val, ttl, op := fn(val, ttl, ok)
+		if op == bigmap.UpdatePut {
+			c.entry = appendEntry(c.entry[:0], key, val)
+			return c.entry, ttl, op
+		}
+		return entry, ttl, op
+	})
+}
+
+func (c *client) put(key, val []byte, ttl time.Duration) error {
+	c.entry = appendEntry(c.entry[:0], key, val)
+	return c.server.bigmap.PutTTL(key, c.entry, ttl)
+}
+
+func (c *client) writeErr(err error) {
+	c.writer.WriteErrorf("ERR %v", err)
+}
+
+func (c *client) ping(args [][]byte) {
+	if len(args) > 1 {
+		c.writer.WriteBulk(args[1])
+		return
+	}
+	c.writer.WriteSimple("PONG")
+}
+
+func (c *client) echo(args [][]byte) {
+	c.writer.WriteBulk(args[1])
+}
+
+func (c *client) quitCommand(args [][]byte) {
+	c.writer.WriteSimple("OK")
+	c.quit = true
+}
+
+func (c *client) selectCommand(args [][]byte) {
+	if string(args[1]) != "0" {
+		c.writer.WriteError("ERR DB index is out of range")
+		return
+	}
+	c.writer.WriteSimple("OK")
+}
+
+func (c *client) clientCommand(args [][]byte) {
+	c.writer.WriteSimple("OK")
+}
+
+func (c *client) commandCommand(args [][]byte) {
+	c.writer.WriteArray(0)
+}
+
+func (c *client) get(args [][]byte) {
+	val, ok := c.lookup(args[1])
+	if !ok {
+		c.writer.WriteNull()
+		return
+	}
+	c.writer.WriteBulk(val)
+}
+
+func (c *client) set(args [][]byte) {
+	key, val := args[1], args[2]
+	var ttl time.Duration
+	nx, xx := false, false
+	for i := 3; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		switch option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 == len(args) {
+				c.writer.WriteError("ERR syntax error")
+				return
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil || n <= 0 {
+				c.writer.WriteError("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * time.Millisecond
+			if option == "EX" {
+				ttl = time.Duration(n) * time.Second
+			}
+		default:
+			c.writer.WriteError("ERR syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		c.writer.WriteError("ERR syntax error")
+		return
+	}
+	if !nx && !xx {
+		if err := c.put(key, val, ttl); err != nil {
+			c.writeErr(err)
+			return
+		}
+		c.writer.WriteSimple("OK")
+		return
+	}
+	stored := false
+	err := c.update(key, func(_ []byte, _ time.Duration, ok bool) ([]byte, time.Duration, bigmap.UpdateOp) {
+		if ok != xx {
+			return nil, 0, bigmap.UpdateKeep
+		}
+		stored = true
+		return val, ttl, bigmap.UpdatePut
+	})
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	if !stored {
+		c.writer.WriteNull()
+		return
+	}
+	c.writer.WriteSimple("OK")
+}
+
+func (c *client) del(args [][]byte) {
+	deleted := int64(0)
+	for _, key := range args[1:] {
+		c.update(key, func(_ []byte, _ time.Duration, ok bool) ([]byte, time.Duration, bigmap.UpdateOp) {
+			if !ok {
+				return nil, 0, bigmap.UpdateKeep
+			}
+			deleted++
+			return nil, 0, bigmap.UpdateDelete
+		})
+	}
+	c.writer.WriteInt(deleted)
+}
+
+func (c *client) exists(args [][]byte) {
+	found := int64(0)
+	for _, key := range args[1:] {
+		if _, ok := c.lookup(key); ok {
+			found++
+		}
+	}
+	c.writer.WriteInt(found)
+}
+
+func (c *client) mget(args [][]byte) {
+	c.writer.WriteArray(len(args) - 1)
+	for _, key := range args[1:] {
+		c.get([][]byte{nil, key})
+	}
+}
+
+func (c *client) mset(args [][]byte) {
+	if len(args)%2 != 1 {
+		c.writer.WriteError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	for i := 1; i < len(args); i += 2 {
+		if err := c.put(args[i], args[i+1], 0); err != nil {
+			c.writeErr(err)
+			return
+		}
+	}
+	c.writer.WriteSimple("OK")
+}
+
+func (c *client) incr(args [][]byte) {
+	c.incrBy(args[1], 1)
+}
+
+func (c *client) decr(args [][]byte) {
+	c.incrBy(args[1], -1)
+}
+
+func (c *client) incrby(args [][]byte) {
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	c.incrBy(args[1], n)
+}
+
+func (c *client) decrby(args [][]byte) {
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil || n == -n && n != 0 {
+		c.writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	c.incrBy(args[1], -n)
+}
+
+func (c *client) incrBy(key []byte, by int64) {
+	var result int64
+	var failure error
+	err := c.update(key, func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, bigmap.UpdateOp) {
+		current := int64(0)
+		if ok {
+			n, err := strconv.ParseInt(string(val), 10, 64)
+			if err != nil {
+				failure = errors.New("ERR value is not an integer or out of range")
+				return nil, 0, bigmap.UpdateKeep
+			}
+			current = n
+		}
+		if (by > 0 && current > (1<<63-1)-by) || (by < 0 && current < (-1<<63)-by) {
+			failure = errors.New("ERR increment or decrement would overflow")
+			return nil, 0, bigmap.UpdateKeep
+		}
+		result = current + by
+		return strconv.AppendInt(val[:0:0], result, 10), ttl, bigmap.UpdatePut
+	})
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	if failure != nil {
+		c.writer.WriteError(failure.Error())
+		return
+	}
+	c.writer.WriteInt(result)
+}
+
+func (c *client) expire(args [][]byte) {
+	c.expireWith(args, time.Second)
+}
+
+func (c *client) pexpire(args [][]byte) {
+	c.expireWith(args, time.Millisecond)
+}
+
+func (c *client) expireWith(args [][]byte, unit time.Duration) {
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	c.setTTL(args[1], time.Duration(n)*unit, true)
+}
+
+func (c *client) persist(args [][]byte) {
+	c.setTTL(args[1], 0, false)
+}
+
+func (c *client) setTTL(key []byte, ttl time.Duration, expire bool) {
+	changed := int64(0)
+	err := c.update(key, func(val []byte, current time.Duration, ok bool) ([]byte, time.Duration, bigmap.UpdateOp) {
+		if !ok || (!expire && current == 0) {
+			return nil, 0, bigmap.UpdateKeep
+		}
+		changed = 1
+		if expire && ttl <= 0 {
+			return nil, 0, bigmap.UpdateDelete
+		}
+		return val, ttl, bigmap.UpdatePut
+	})
+	if err != nil {
+		c.writeErr(err)
+		return
+	}
+	c.writer.WriteInt(changed)
+}
+
+func (c *client) ttl(args [][]byte) {
+	c.ttlIn(args[1], time.Second)
+}
+
+func (c *client) pttl(args [][]byte) {
+	c.ttlIn(args[1], time.Millisecond)
+}
+
+func (c *client) ttlIn(key []byte, unit time.Duration) {
+	if _, ok := c.lookup(key); !ok {
+		c.writer.WriteInt(-2)
+		return
+	}
+	ttl, ok := c.server.bigmap.TTL(key)
+	if !ok {
+		c.writer.WriteInt(-2)
+		return
+	}
+	if ttl == 0 {
+		c.writer.WriteInt(-1)
+		return
+	}
+	c.writer.WriteInt(int64((ttl + unit/2) / unit))
+}
+
+func (c *client) dbsize(args [][]byte) {
+	c.writer.WriteInt(int64(c.server.bigmap.Len()))
+}
+
+func (c *client) flush(args [][]byte) {
+	c.server.bigmap.Clear()
+	c.writer.WriteSimple("OK")
+}
+
+func (c *client) info(args [][]byte) {
+	S := c.server
+	var info strings.Builder
+	fmt.Fprintf(&info, "# Server\r\n")
+	fmt.Fprintf(&info, "redis_version:7.0.0\r\n")
+	fmt.Fprintf(&info, "redis_mode:standalone\r\n")
+	fmt.Fprintf(&info, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(&info, "uptime_in_seconds:%d\r\n", int64(time.Since(S.started)/time.Second))
+	fmt.Fprintf(&info, "\r\n# Clients\r\n")
+	fmt.Fprintf(&info, "connected_clients:%d\r\n", atomic.LoadInt64(&S.clients))
+	fmt.Fprintf(&info, "\r\n# Stats\r\n")
+	fmt.Fprintf(&info, "total_commands_processed:%d\r\n", atomic.LoadUint64(&S.commands))
+	fmt.Fprintf(&info, "\r\n# Bigmap\r\n")
+	fmt.Fprintf(&info, "shards:%d\r\n", S.bigmap.ShardCount())
+	fmt.Fprintf(&info, "entrysize:%d\r\n", S.bigmap.EntrySize())
+	fmt.Fprintf(&info, "\r\n# Keyspace\r\n")
+	fmt.Fprintf(&info, "db0:keys=%d,expires=0,avg_ttl=0\r\n", S.bigmap.Len())
+	c.writer.WriteBulkString(info.String())
+}
+
+func (c *client) scan(args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.writer.WriteError("ERR invalid cursor")
+		return
+	}
+	var pattern []byte
+	count := 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			c.writer.WriteError("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 1 {
+				c.writer.WriteError("ERR syntax error")
+				return
+			}
+		default:
+			c.writer.WriteError("ERR syntax error")
+			return
+		}
+	}
+	var keys [][]byte
+	for {
+		cursor = c.server.bigmap.Scan(cursor, func(_ uint64, entry []byte) {
+			key, _, ok := splitEntry(entry)
+			if ok && (pattern == nil || Match(pattern, key)) {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+		})
+		if cursor == 0 || len(keys) >= count {
+			break
+		}
+	}
+	c.writer.WriteArray(2)
+	c.writer.WriteBulkString(strconv.FormatUint(cursor, 10))
+	c.writer.WriteArray(len(keys))
+	for _, key := range keys {
+		c.writer.WriteBulk(key)
+	}
+}
@@ -0,0 +1,121 @@
+package resp
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/worldOneo/bigmap"
+)
+
+type testClient struct {
+	t      *testing.T
+	reader *Reader
+	writer *Writer
+}
+
+func startServer(t *testing.T) *testClient {
+	bm := bigmap.New(EntrySize(64, 64), bigmap.Config{Shards: 4})
+	server := NewServer(&bm)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return &testClient{t: t, reader: NewReader(conn), writer: NewWriter(conn)}
+}
+
+func (c *testClient) do(args ...string) Reply {
+	c.t.Helper()
+	command := make([][]byte, len(args))
+	for i, arg := range args {
+		command[i] = []byte(arg)
+	}
+	c.writer.WriteCommand(command...)
+	if err := c.writer.Flush(); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+	reply, err := c.reader.ReadReply()
+	if err != nil {
+		c.t.Fatalf("read reply: %v", err)
+	}
+	return reply
+}
+
+func (c *testClient) expect(want string, args ...string) {
+	c.t.Helper()
+	reply := c.do(args...)
+	got := string(reply.Str)
+	switch {
+	case reply.Null:
+		got = "(nil)"
+	case reply.Type == Integer:
+		got = strconv.FormatInt(reply.Int, 10)
+	case reply.Type == Error:
+		got = "ERR"
+	}
+	if got != want {
+		c.t.Fatalf("%q got %q, want %q", args, got, want)
+	}
+}
+
+func TestServer(t *testing.T) {
+	c := startServer(t)
+	c.expect("PONG", "PING")
+	c.expect("OK", "SET", "key", "value")
+	c.expect("value", "GET", "key")
+	c.expect("(nil)", "GET", "missing")
+	c.expect("(nil)", "SET", "key", "other", "NX")
+	c.expect("OK", "SET", "new", "other", "NX")
+	c.expect("2", "EXISTS", "key", "new", "missing")
+	c.expect("2", "DEL", "key", "new", "missing")
+	c.expect("0", "EXISTS", "key")
+	c.expect("1", "INCR", "counter")
+	c.expect("11", "INCRBY", "counter", "10")
+	c.expect("ERR", "INCR", "counter", "extra")
+	c.expect("OK", "SET", "text", "abc")
+	c.expect("ERR", "INCR", "text")
+	c.expect("-1", "TTL", "counter")
+	c.expect("1", "EXPIRE", "counter", "100")
+	c.expect("100", "TTL", "counter")
+	c.expect("-2", "TTL", "missing")
+	c.expect("OK", "SET", "timed", "value", "PX", "100000")
+	c.expect("100", "TTL", "timed")
+	c.expect("OK", "MSET", "a", "1", "b", "2")
+	reply := c.do("MGET", "a", "missing", "b")
+	if len(reply.Array) != 3 || string(reply.Array[0].Str) != "1" || !reply.Array[1].Null || string(reply.Array[2].Str) != "2" {
+		t.Fatalf("MGET got %+v", reply.Array)
+	}
+	c.expect("5", "DBSIZE")
+	c.expect("ERR", "UNKNOWN")
+}
+
+func TestServer_SCAN(t *testing.T) {
+	c := startServer(t)
+	for i := 0; i < 100; i++ {
+		c.expect("OK", "SET", "key:"+strconv.Itoa(i), "value")
+	}
+	c.expect("OK", "SET", "other", "value")
+	keys := map[string]bool{}
+	cursor := "0"
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "key:*", "COUNT", "20")
+		cursor = string(reply.Array[0].Str)
+		for _, key := range reply.Array[1].Array {
+			keys[string(key.Str)] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(keys) != 100 || keys["other"] {
+		t.Fatalf("SCAN got %d keys, want 100", len(keys))
+	}
+	c.expect("OK", "FLUSHDB")
+	c.expect("0", "DBSIZE")
+}
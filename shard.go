@@ -2,8 +2,11 @@ package bigmap
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"runtime"
+	"sync/atomic"
+	"time"
 
 	commoncollections "github.com/worldOneo/CommonCollections"
 	"github.com/worldOneo/bigmap/intmap"
@@ -11,6 +14,9 @@ import (
 
 const maxSpins = 16
 
+// ErrValueSize is returned if a value doesn't fit into an entry.
+var ErrValueSize = errors.New("bigmap: value size to long")
+
 type spinner uint8
 
 func (spin *spinner) spin() {
@@ -30,13 +36,27 @@ func (spin *spinner) spin() {
 // A shard locks itself while Put/Delete
 // and RLocks itself while Get
 type Shard struct {
-	lock      commoncollections.OptLock
-	ptrs      intmap.IntMap
-	freePtrs  PointerQueue
-	size      uint64
-	entrysize uint64
-	array     []byte
-	expSrv    ExpirationService
+	lock        commoncollections.OptLock
+	ptrs        intmap.IntMap
+	freePtrs    PointerQueue
+	size        uint64
+	capacity    uint64
+	entrysize   uint64
+	header      uint64 // HeaderBytes plus the lifetime and the checksum if enabled
+	grace       int64
+	lifetimes   bool
+	checksums   bool
+	compression int
+	keyring     *Keyring
+	array       []byte
+	expSrv      ExpirationService
+	retired     uint32
+	events      atomic.Value // *eventRing
+	loads       loadGroup
+	tier        *diskTier
+	index       *keyIndex
+	spaces      map[uint64]spaceEntry // the namespaces of the items
+	metrics     shardMetrics
 }
 
 // NewShard initializes a new shard.
@@ -52,7 +72,9 @@ func NewShard(capacity, entrysize uint64, expSrv ExpirationService) *Shard {
 		ptrs:      intmap.New(),
 		freePtrs:  NewPointerQueue(),
 		size:      0,
+		capacity:  capacity,
 		entrysize: entrysize,
+		header:    HeaderBytes,
 		array:     make([]byte, capacity),
 		expSrv:    expSrv,
 	}
@@ -61,35 +83,173 @@ func NewShard(capacity, entrysize uint64, expSrv ExpirationService) *Shard {
 
 // Put adds or overwrites an item in(to) the shards internal byte-array.
 func (S *Shard) Put(key uint64, val []byte) error {
-	dataLength := uint64(len(val))
-	if dataLength > S.entrysize {
-		_lval := dataLength
-		maxSize := S.entrysize
-		return fmt.Errorf("shard put: value size to long (%d > %d)", _lval, maxSize)
+	_, err := S.put(key, nil, val, 0, nil, false)
+	return err
+}
+
+// PutTTL adds or overwrites an item like Put
+// which expires after the ttl.
+// A ttl smaller or equal to 0 never expires.
+func (S *Shard) PutTTL(key uint64, val []byte, ttl time.Duration) error {
+	_, err := S.put(key, nil, val, deadlineOf(ttl), nil, false)
+	return err
+}
+
+// put adds or overwrites an item like Put.
+// The origin is the original key of the item, if known,
+// and is passed on to watchers.
+// The item is counted by the space, a nil space keeps
+// the item in its current namespace.
+// The structure flag marks values holding a data structure.
+// It returns false if the shard was retired by a reshard
+// and the item wasn't written.
+func (S *Shard) put(key uint64, origin, val []byte, deadline int64, space *Namespace, structure bool) (bool, error) {
+	stored, flags, err := S.encode(key, val)
+	if err != nil {
+		return true, err
+	}
+	if structure {
+		flags |= structureFlag
+	}
+	if err := S.checkSize(stored); err != nil {
+		return true, err
 	}
 	S.hitExpirationService(key, ExpirationService.BeforeLock)
+	S.lock.Lock()
+	if S.isRetired() {
+		S.lock.Unlock()
+		return false, nil
+	}
 	defer func() {
 		S.hitExpirationService(key, ExpirationService.Access)
 		S.lock.Unlock()
 		S.hitExpirationService(key, ExpirationService.AfterAccess)
 	}()
+	S.hitExpirationService(key, ExpirationService.Lock)
+	if err := S.admit(key, space, int64(len(stored))); err != nil {
+		return true, err
+	}
+	S.write(key, stored, flags, deadline, lifetimeOf(deadline))
+	S.indexKey(key, origin)
+	count(&S.metrics.puts)
+	S.notify(EventPut, key, origin, val, deadline, structure)
+	return true, nil
+}
+
+// insert adds an item moved by a reshard as it was stored
+// with its itemMeta.
+// Moved items are neither counted nor reported to watchers.
+func (S *Shard) insert(key uint64, meta itemMeta, val []byte, flags uint64, deadline, lifetime int64) {
+	S.hitExpirationService(key, ExpirationService.BeforeLock)
 	S.lock.Lock()
+	defer func() {
+		S.hitExpirationService(key, ExpirationService.Access)
+		S.lock.Unlock()
+		S.hitExpirationService(key, ExpirationService.AfterAccess)
+	}()
 	S.hitExpirationService(key, ExpirationService.Lock)
+	S.write(key, val, flags, deadline, lifetime)
+	S.adopt(key, meta)
+}
+
+func (S *Shard) checkSize(val []byte) error {
+	dataLength := uint64(len(val))
+	if dataLength > S.entrysize {
+		_lval := dataLength
+		maxSize := S.entrysize
+		return fmt.Errorf("shard put: %w (%d > %d)", ErrValueSize, _lval, maxSize)
+	}
+	return nil
+}
+
+// write stores the item in the byte-array without locking the shard.
+// The flags are stored in the length word.
+// The lifetime is the ttl the item was stored with.
+func (S *Shard) write(key uint64, val []byte, flags uint64, deadline, lifetime int64) {
+	if S.tier != nil {
+		S.tier.remove(key)
+	}
 	ptr, ok := S.ptrs.Get(key)
 	if !ok {
 		ptr, ok = S.freePtrs.Dequeue()
 		if !ok {
 			ptr = S.size
-			S.sizeCheck(S.entrysize + LengthBytes)
+			S.sizeCheck(S.entrysize + S.header)
+			S.size += S.header
+			S.size += S.entrysize
 		}
 		S.ptrs.Put(key, ptr)
 	}
-	dataIndex := ptr + LengthBytes
-	binary.LittleEndian.PutUint64(S.array[ptr:dataIndex], dataLength)
+	dataLength := uint64(len(val))
+	dataIndex := ptr + S.header
+	binary.LittleEndian.PutUint64(S.array[ptr:], dataLength|flags)
+	binary.LittleEndian.PutUint64(S.array[ptr+LengthBytes:], uint64(deadline))
+	if S.lifetimes {
+		binary.LittleEndian.PutUint64(S.array[ptr+HeaderBytes:], uint64(lifetime))
+	}
 	copy(S.array[dataIndex:dataIndex+dataLength], val)
-	S.size += LengthBytes
-	S.size += S.entrysize
-	return nil
+	S.stamp(key, ptr)
+}
+
+// slot returns the data and deadline of the item at ptr as stored.
+// The returned slice points into the byte-array.
+// Corrupt lengths are cut to the entrysize, see check.
+func (S *Shard) slot(ptr uint64) ([]byte, int64) {
+	dataIndex := ptr + S.header
+	dataLength := binary.LittleEndian.Uint64(S.array[ptr:]) &^ slotFlags
+	if dataLength > S.entrysize {
+		dataLength = S.entrysize
+	}
+	deadline := int64(binary.LittleEndian.Uint64(S.array[ptr+LengthBytes:]))
+	return S.array[dataIndex : dataIndex+dataLength], deadline
+}
+
+// flags returns the flags of the item at ptr.
+func (S *Shard) flags(ptr uint64) uint64 {
+	return binary.LittleEndian.Uint64(S.array[ptr:]) & slotFlags
+}
+
+// value returns the decoded data and deadline of the item at ptr.
+// Values which are neither compressed nor encrypted
+// point into the byte-array.
+// ErrCorrupt is returned if the item fails check.
+func (S *Shard) value(key, ptr uint64) ([]byte, int64, error) {
+	if err := S.check(key, ptr); err != nil {
+		return nil, 0, err
+	}
+	val, deadline := S.slot(ptr)
+	val, err := S.decode(nil, key, val, S.flags(ptr))
+	return val, deadline, err
+}
+
+// lifetime returns the ttl the item at ptr was stored with
+// or 0 if lifetimes aren't kept.
+func (S *Shard) lifetime(ptr uint64) int64 {
+	if !S.lifetimes {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(S.array[ptr+HeaderBytes:]))
+}
+
+// verify verifies an optimistic read and counts failed verifications.
+func (S *Shard) verify(check uint32) bool {
+	if S.lock.RVerify(check) {
+		return true
+	}
+	count(&S.metrics.verifyRetries)
+	return false
+}
+
+func (S *Shard) rlock() uint32 {
+	spin := spinner(0)
+	for {
+		check, ok := S.lock.RLock()
+		if ok {
+			return check
+		}
+		count(&S.metrics.spinRetries)
+		spin.spin()
+	}
 }
 
 // Get retrieves an item from the shards internal byte-array.
@@ -97,76 +257,433 @@ func (S *Shard) Put(key uint64, val []byte) error {
 // and a boolean if the items was contained if the boolean
 // is false the slice will be nil.
 func (S *Shard) Get(key uint64) ([]byte, bool) {
+	val, ok, err := S.fetch(key)
+	return val, ok && err == nil
+}
+
+// fetch retrieves an item like Get and returns
+// the error if the item can't be decoded.
+func (S *Shard) fetch(key uint64) ([]byte, bool, error) {
+	val, flags, ok := S.copySlot(key, nil)
+	if !ok {
+		return nil, false, nil
+	}
+	val, err := S.decode(nil, key, val, flags)
+	if err != nil {
+		return nil, true, err
+	}
+	if val == nil {
+		val = []byte{}
+	}
+	return val, true, nil
+}
+
+// GetInto retrieves an item from the shards internal byte-array
+// and writes it into buffer.
+// It returns the size, true if the item was contained and 0, false otherwise.
+// If the size is larger than the buffer only len(buffer) bytes are
+// written, the caller can retry with a buffer of the returned size.
+func (S *Shard) GetInto(key uint64, buffer []byte) (uint64, bool) {
+	val, flags, ok := S.copySlot(key, buffer[:0:len(buffer)])
+	if !ok {
+		return 0, false
+	}
+	if flags != 0 {
+		buffers := scratches.Get().(*scratch)
+		defer scratches.Put(buffers)
+		decoded, err := S.decode(buffers.val[:0], key, val, flags)
+		buffers.val = decoded[:0]
+		if err != nil {
+			return 0, false
+		}
+		val = decoded
+	}
+	if len(val) > len(buffer) || flags != 0 {
+		copy(buffer, val)
+	}
+	return uint64(len(val)), true
+}
+
+// copySlot appends the data of the item as stored to dst and returns
+// it with the flags of the slot. Items of the disk tier are promoted.
+// Corrupt items are returned with the corruptFlag.
+func (S *Shard) copySlot(key uint64, dst []byte) ([]byte, uint64, bool) {
 	S.hitExpirationService(key, ExpirationService.BeforeLock)
 	defer func() {
 		S.hitExpirationService(key, ExpirationService.AfterAccess)
 	}()
+	promoted := false
 	for {
-		spin := spinner(0)
-		var check uint32
-		var ok bool
-		for {
-			check, ok = S.lock.RLock()
-			if ok {
-				break
-			}
-			spin.spin()
-		}
+		check := S.rlock()
 		S.hitExpirationService(key, ExpirationService.Lock)
 		ptr, ok := S.ptrs.Get(key)
 		if !ok {
-			return nil, false
+			if S.verify(check) {
+				if !promoted && S.promote(key) {
+					promoted = true
+					continue
+				}
+				count(&S.metrics.misses)
+				return dst, 0, false
+			}
+			continue
+		}
+		array := S.array
+		dataIndex := ptr + S.header
+		if dataIndex > uint64(len(array)) {
+			continue // shard was reset
 		}
-		dataIndex := ptr + LengthBytes
-		dataLength := binary.LittleEndian.Uint64(S.array[ptr:])
-		if !S.lock.RVerify(check) {
-			continue // avoid allocation
+		word := binary.LittleEndian.Uint64(array[ptr:])
+		dataLength := word &^ slotFlags
+		deadline := int64(binary.LittleEndian.Uint64(array[ptr+LengthBytes:]))
+		var sum uint32
+		if S.checksums {
+			sum = binary.LittleEndian.Uint32(array[ptr+S.header-ChecksumBytes:])
+		}
+		if !S.verify(check) {
+			continue
 		}
-		dst := make([]byte, dataLength)
-		copy(dst, S.array[dataIndex:dataIndex+dataLength])
-		if S.lock.RVerify(check) {
-			return dst, true
+		if dataLength > S.entrysize || dataIndex+dataLength > uint64(len(array)) {
+			count(&S.metrics.corruptions)
+			return dst, corruptFlag, true
+		}
+		if S.expired(deadline) {
+			S.expire(key)
+			count(&S.metrics.misses)
+			return dst, 0, false
+		}
+		val := append(dst, array[dataIndex:dataIndex+dataLength]...)
+		if S.verify(check) {
+			if S.checksums && checksum(key, word, deadline, val[len(dst):]) != sum {
+				count(&S.metrics.corruptions)
+				return dst, corruptFlag, true
+			}
+			count(&S.metrics.hits)
+			return val, word & slotFlags, true
 		}
 		runtime.Gosched()
 	}
 }
 
-// GetInto retrieves an item from the shards internal byte-array
-// and writes it into buffer.
-// It returns the size, true if the item was contained and 0, false otherwise.
-func (S *Shard) GetInto(key uint64, buffer []byte) (uint64, bool) {
-	// sync with Get
-	S.hitExpirationService(key, ExpirationService.BeforeLock)
-	defer func() {
-		S.hitExpirationService(key, ExpirationService.AfterAccess)
-	}()
+// TTL returns the remaining time to live of an item
+// and true if the item is contained.
+// A TTL of 0 means the item doesn't expire.
+func (S *Shard) TTL(key uint64) (time.Duration, bool) {
+	promoted := false
 	for {
-		spin := spinner(0)
-		var check uint32
-		var ok bool
-		for {
-			check, ok = S.lock.RLock()
-			if ok {
-				break
+		check := S.rlock()
+		ptr, ok := S.ptrs.Get(key)
+		if !ok {
+			if S.verify(check) {
+				if !promoted && S.promote(key) {
+					promoted = true
+					continue
+				}
+				return 0, false
 			}
-			spin.spin()
+			continue
 		}
-		S.hitExpirationService(key, ExpirationService.Lock)
+		array := S.array
+		if ptr+S.header > uint64(len(array)) {
+			continue // shard was reset
+		}
+		deadline := int64(binary.LittleEndian.Uint64(array[ptr+LengthBytes:]))
+		if !S.verify(check) {
+			continue
+		}
+		if S.expired(deadline) {
+			S.expire(key)
+			return 0, false
+		}
+		return ttlOf(deadline), true
+	}
+}
+
+// refreshDue reports whether the item has to be reloaded because
+// it is stale or passed the fraction of its lifetime.
+// The second return value is false if the item isn't contained.
+func (S *Shard) refreshDue(key uint64, fraction float64) (bool, bool) {
+	for {
+		check := S.rlock()
 		ptr, ok := S.ptrs.Get(key)
 		if !ok {
-			return 0, false
+			if S.verify(check) {
+				return false, false
+			}
+			continue
+		}
+		array := S.array
+		if ptr+S.header > uint64(len(array)) {
+			continue // shard was reset
 		}
-		dataIndex := ptr + LengthBytes
-		dataLength := binary.LittleEndian.Uint64(S.array[ptr:])
-		if !S.lock.RVerify(check) {
+		deadline := int64(binary.LittleEndian.Uint64(array[ptr+LengthBytes:]))
+		lifetime := S.lifetime(ptr)
+		if !S.verify(check) {
 			continue
 		}
-		copy(buffer, S.array[dataIndex:dataIndex+dataLength])
-		if S.lock.RVerify(check) {
-			return dataLength, true
+		if deadline == 0 {
+			return false, true
 		}
-		runtime.Gosched()
+		refreshAt := deadline - int64(float64(lifetime)*(1-fraction))
+		return time.Now().UnixNano() >= refreshAt, true
+	}
+}
+
+// Expire sets the time to live of an existing item.
+// A ttl smaller or equal to 0 removes the expiration of the item.
+// It returns false if the item isn't contained.
+func (S *Shard) Expire(key uint64, ttl time.Duration) bool {
+	ok, _ := S.expireAt(key, nil, deadlineOf(ttl))
+	return ok
+}
+
+// expireAt sets the deadline of an item like Expire.
+// The second return value is false if the shard was
+// retired by a reshard and nothing was changed.
+func (S *Shard) expireAt(key uint64, origin []byte, deadline int64) (bool, bool) {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if S.isRetired() {
+		return false, false
 	}
+	ptr, ok := S.live(key)
+	if ok && S.check(key, ptr) != nil {
+		return false, true
+	}
+	if ok {
+		binary.LittleEndian.PutUint64(S.array[ptr+LengthBytes:], uint64(deadline))
+		if S.lifetimes {
+			binary.LittleEndian.PutUint64(S.array[ptr+HeaderBytes:], uint64(lifetimeOf(deadline)))
+		}
+		S.stamp(key, ptr)
+		if S.ring() != nil {
+			val, _, _ := S.value(key, ptr)
+			S.notify(EventPut, key, origin, val, deadline, S.flags(ptr)&structureFlag != 0)
+		}
+	}
+	return ok, true
+}
+
+// UpdateOp is the operation performed by Update
+// after the UpdateFunc returned.
+type UpdateOp uint8
+
+const (
+	// UpdateKeep leaves the item unchanged.
+	UpdateKeep UpdateOp = iota
+	// UpdatePut stores the returned value and ttl.
+	UpdatePut
+	// UpdateDelete removes the item.
+	UpdateDelete
+)
+
+// UpdateFunc computes the new value of an item.
+// It is called with the current value, its remaining time to live
+// and true if the item is contained.
+// The value points into the shard and must not be retained, but it
+// may be modified and returned.
+// It returns the new value, its time to live and the UpdateOp to perform.
+type UpdateFunc func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, UpdateOp)
+
+// Update atomically reads and modifies an item.
+// The shard is locked while fn is called therefore fn
+// must not access the shard.
+// An error is returned if the new value is to big,
+// the item is left unchanged in this case.
+func (S *Shard) Update(key uint64, fn UpdateFunc) error {
+	_, err := S.update(key, nil, fn, false)
+	return err
+}
+
+// update modifies an item like Update.
+// A structured update only modifies data structures, ErrWrongType
+// is returned for other items, and stores the value as a structure.
+// It returns false if the shard was retired by a reshard
+// and fn wasn't called.
+func (S *Shard) update(key uint64, origin []byte, fn UpdateFunc, structured bool) (bool, error) {
+	S.hitExpirationService(key, ExpirationService.BeforeLock)
+	S.lock.Lock()
+	if S.isRetired() {
+		S.lock.Unlock()
+		return false, nil
+	}
+	defer func() {
+		S.hitExpirationService(key, ExpirationService.Access)
+		S.lock.Unlock()
+		S.hitExpirationService(key, ExpirationService.AfterAccess)
+	}()
+	S.hitExpirationService(key, ExpirationService.Lock)
+	var current []byte
+	var ttl time.Duration
+	ptr, ok := S.live(key)
+	if ok {
+		if structured && S.flags(ptr)&structureFlag == 0 {
+			return true, ErrWrongType
+		}
+		var deadline int64
+		var err error
+		if current, deadline, err = S.value(key, ptr); err != nil {
+			return true, err
+		}
+		ttl = ttlOf(deadline)
+	}
+	val, ttl, op := fn(current, ttl, ok)
+	switch op {
+	case UpdatePut:
+		stored, flags, err := S.encode(key, val)
+		if err != nil {
+			return true, err
+		}
+		if err := S.checkSize(stored); err != nil {
+			return true, err
+		}
+		if err := S.admit(key, nil, int64(len(stored))); err != nil {
+			return true, err
+		}
+		if structured {
+			flags |= structureFlag
+		}
+		deadline := deadlineOf(ttl)
+		S.write(key, stored, flags, deadline, lifetimeOf(deadline))
+		S.indexKey(key, origin)
+		count(&S.metrics.puts)
+		S.notify(EventPut, key, origin, val, deadline, structured)
+	case UpdateDelete:
+		if ok {
+			S.hitExpirationService(key, ExpirationService.Remove)
+			S.notifyRemove(EventDelete, key, origin)
+			S.UnsafeDelete(key)
+			count(&S.metrics.deletes)
+		}
+	}
+	return true, nil
+}
+
+// live returns the pointer of the item if it is contained and
+// not expired. Expired items are removed and items of the
+// disk tier are promoted.
+// The shard must be locked.
+func (S *Shard) live(key uint64) (uint64, bool) {
+	ptr, ok := S.ptrs.Get(key)
+	if !ok {
+		return S.unsafePromote(key)
+	}
+	if _, deadline := S.slot(ptr); S.expired(deadline) {
+		S.hitExpirationService(key, ExpirationService.Remove)
+		S.notifyRemove(EventExpire, key, nil)
+		S.UnsafeDelete(key)
+		count(&S.metrics.expirations)
+		return 0, false
+	}
+	return ptr, true
+}
+
+// expire removes the item if it is expired.
+func (S *Shard) expire(key uint64) {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if !S.isRetired() {
+		S.live(key)
+	}
+}
+
+// Range calls fn for every item in the shard until fn returns false.
+// The shard is locked while ranging over it therefore
+// fn must not access the shard.
+// The value must not be retained.
+func (S *Shard) Range(fn func(key uint64, val []byte) bool) {
+	S.rangeItems(fn)
+}
+
+func (S *Shard) rangeItems(fn func(key uint64, val []byte) bool) bool {
+	return S.rangeSlots(func(key uint64, val []byte, _ int64, _ uint64) bool {
+		return fn(key, val)
+	})
+}
+
+// rangeSlots calls fn for every live item with its deadline
+// and its flags other than the encoding.
+// It returns false if fn stopped the iteration.
+func (S *Shard) rangeSlots(fn func(key uint64, val []byte, deadline int64, flags uint64) bool) bool {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if S.isRetired() {
+		return true
+	}
+	more := true
+	S.ptrs.Range(func(key, ptr uint64) bool {
+		val, deadline, err := S.value(key, ptr)
+		if err == nil && !S.expired(deadline) {
+			more = fn(key, val, deadline, S.flags(ptr)&structureFlag)
+		}
+		return more
+	})
+	if more && S.tier != nil {
+		more = S.tier.rangeRecords(func(key uint64, val []byte, record diskRecord) bool {
+			if S.expired(record.deadline) || S.checkRecord(key, val, record) != nil {
+				return true
+			}
+			val, err := S.decode(nil, key, val, record.flags)
+			if err != nil {
+				return true
+			}
+			return fn(key, val, record.deadline, record.flags&structureFlag)
+		})
+	}
+	return more
+}
+
+// Len returns the amount of items in the shard.
+// Expired items which weren't removed yet
+// and items of the disk tier are included.
+func (S *Shard) Len() int {
+	disk := 0
+	if S.tier != nil {
+		disk, _ = S.tier.stats()
+	}
+	for {
+		check := S.rlock()
+		size := S.ptrs.Len()
+		if S.verify(check) {
+			return size + disk
+		}
+	}
+}
+
+func deadlineOf(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().UnixNano() + int64(ttl)
+}
+
+func ttlOf(deadline int64) time.Duration {
+	if deadline == 0 {
+		return 0
+	}
+	ttl := time.Duration(deadline - time.Now().UnixNano())
+	if ttl <= 0 {
+		return time.Nanosecond
+	}
+	return ttl
+}
+
+func expired(deadline int64) bool {
+	return deadline != 0 && time.Now().UnixNano() >= deadline
+}
+
+// expired reports whether an item with the deadline has to be removed.
+// Items are kept for the grace period after their deadline
+// to be served stale while they are reloaded.
+func (S *Shard) expired(deadline int64) bool {
+	return deadline != 0 && time.Now().UnixNano() >= deadline+S.grace
+}
+
+func lifetimeOf(deadline int64) int64 {
+	if deadline == 0 {
+		return 0
+	}
+	return deadline - time.Now().UnixNano()
 }
 
 // Delete removes an item from the shard.
@@ -176,15 +693,42 @@ func (S *Shard) GetInto(key uint64, buffer []byte) (uint64, bool) {
 // nor of the shard.
 // It only enables the space to be reused.
 func (S *Shard) Delete(key uint64) bool {
+	ok, _ := S.delete(key, nil)
+	return ok
+}
+
+// delete removes an item like Delete.
+// The second return value is false if the shard was
+// retired by a reshard and nothing was deleted.
+func (S *Shard) delete(key uint64, origin []byte) (bool, bool) {
 	S.lock.Lock()
 	defer S.lock.Unlock()
+	if S.isRetired() {
+		return false, false
+	}
 	S.hitExpirationService(key, ExpirationService.Remove)
-	return S.UnsafeDelete(key)
+	S.notifyRemove(EventDelete, key, origin)
+	deleted := S.UnsafeDelete(key)
+	if deleted {
+		count(&S.metrics.deletes)
+	}
+	return deleted, true
 }
 
 // UnsafeDelete deletes an object without locking the shard.
 // If no manual locking is provided data races may occur.
 func (S *Shard) UnsafeDelete(key uint64) bool {
+	S.forget(key)
+	ok := S.free(key)
+	if S.tier != nil && S.tier.remove(key) {
+		ok = true
+	}
+	return ok
+}
+
+// free releases the slot of the item.
+// The shard must be locked.
+func (S *Shard) free(key uint64) bool {
 	ptr, ok := S.ptrs.Delete(key)
 	if ok {
 		S.freePtrs.Enqueue(ptr)
@@ -192,6 +736,96 @@ func (S *Shard) UnsafeDelete(key uint64) bool {
 	return ok
 }
 
+// Clear removes all items from the shard.
+// The byte-array and the internal structures keep their
+// allocated capacity to be reused by following puts.
+func (S *Shard) Clear() {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	S.ptrs.Clear()
+	S.freePtrs.Clear()
+	S.size = 0
+	if S.tier != nil {
+		S.tier.clear()
+	}
+	if S.index != nil {
+		S.index.clear()
+	}
+	S.clearSpaces()
+	S.clearExpirationService()
+}
+
+// Reset removes all items from the shard and
+// releases its memory by shrinking the byte-array
+// back to the initial capacity of the shard.
+func (S *Shard) Reset() {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	S.ptrs.Reset()
+	S.freePtrs.Reset()
+	S.size = 0
+	S.array = make([]byte, S.capacity)
+	if S.tier != nil {
+		S.tier.clear()
+	}
+	if S.index != nil {
+		S.index.clear()
+	}
+	S.clearSpaces()
+	S.clearExpirationService()
+}
+
+// migrate moves all items of the shard into the shards
+// returned by target and retires the shard afterwards.
+// Corrupt items are dropped.
+// The shard stays locked for the whole migration.
+func (S *Shard) migrate(target func(key uint64) *Shard) {
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	S.ptrs.Range(func(key, ptr uint64) bool {
+		val, deadline := S.slot(ptr)
+		if !S.expired(deadline) && S.check(key, ptr) == nil {
+			target(key).insert(key, S.meta(key), val, S.flags(ptr), deadline, S.lifetime(ptr))
+		} else {
+			S.forget(key)
+		}
+		return true
+	})
+	if S.tier != nil {
+		S.tier.rangeRecords(func(key uint64, val []byte, record diskRecord) bool {
+			if !S.expired(record.deadline) && S.checkRecord(key, val, record) == nil {
+				target(key).spill(key, S.meta(key), val, record)
+			} else {
+				S.forget(key)
+			}
+			return true
+		})
+		S.tier.close()
+	}
+	atomic.StoreUint32(&S.retired, 1)
+}
+
+// handOver moves a single item into the shard.
+// Corrupt items are dropped.
+// S must be locked and not retired.
+func (S *Shard) handOver(key uint64, shard *Shard) {
+	ptr, ok := S.live(key)
+	if !ok {
+		return
+	}
+	if S.check(key, ptr) == nil {
+		val, deadline := S.slot(ptr)
+		shard.insert(key, S.meta(key), val, S.flags(ptr), deadline, S.lifetime(ptr))
+		delete(S.spaces, key) // the item stays counted by its namespace
+	}
+	S.hitExpirationService(key, ExpirationService.Remove)
+	S.UnsafeDelete(key)
+}
+
+func (S *Shard) isRetired() bool {
+	return atomic.LoadUint32(&S.retired) != 0
+}
+
 func (S *Shard) sizeCheck(add uint64) {
 	l := uint64(len(S.array))
 	for l < S.size+add {
@@ -199,6 +833,35 @@ func (S *Shard) sizeCheck(add uint64) {
 		b := make([]byte, l)
 		copy(b, S.array)
 		S.array = b
+		count(&S.metrics.grows)
+	}
+}
+
+// evict removes an item on behalf of the ExpirationService.
+// With a disk tier the item is spilled to disk instead.
+// The shard must be locked.
+func (S *Shard) evict(key uint64) {
+	if S.tier != nil {
+		if ptr, ok := S.ptrs.Get(key); ok {
+			val, deadline := S.slot(ptr)
+			if !S.expired(deadline) && S.check(key, ptr) == nil {
+				record := diskRecord{
+					deadline: deadline,
+					lifetime: S.lifetime(ptr),
+					flags:    S.flags(ptr),
+					checksum: S.storedChecksum(ptr),
+				}
+				if S.tier.spill(key, val, record) == nil {
+					S.free(key)
+					return
+				}
+				count(&S.metrics.diskErrors)
+			}
+		}
+	}
+	S.notifyRemove(EventEvict, key, nil)
+	if S.UnsafeDelete(key) {
+		count(&S.metrics.evictions)
 	}
 }
 
@@ -207,3 +870,9 @@ func (S *Shard) hitExpirationService(key uint64, hit func(ExpirationService, uin
 		hit(S.expSrv, key, S)
 	}
 }
+
+func (S *Shard) clearExpirationService() {
+	if clearer, ok := S.expSrv.(expirationClearer); ok {
+		clearer.Clear(S)
+	}
+}
@@ -2,6 +2,7 @@ package bigmap
 
 import (
 	"testing"
+	"time"
 )
 
 func GenShardKeys(n int) []uint64 {
@@ -136,3 +137,130 @@ func TestShard_Put_insertToBig(t *testing.T) {
 		t.Fatal("To big insert got nil, want err")
 	}
 }
+
+func TestShard_Clear(t *testing.T) {
+	keys := GenShardKeys(4096)
+	shard := NewShard(1024, 100, Expires(time.Hour, ExpirationPolicySweep)(0))
+	for _, key := range keys {
+		shard.Put(key, GenVal())
+	}
+	grown := len(shard.array)
+	shard.Clear()
+	for _, key := range keys {
+		if _, ok := shard.Get(key); ok {
+			t.Fatalf("Get after Clear got ok, want !ok")
+		}
+	}
+	if len(shard.array) != grown {
+		t.Fatalf("Clear changed capacity got %d, want %d", len(shard.array), grown)
+	}
+	for _, key := range keys {
+		shard.Put(key, GenVal())
+	}
+	if len(shard.array) != grown {
+		t.Fatalf("Put after Clear grew shard got %d, want %d", len(shard.array), grown)
+	}
+}
+
+// plainExpiration is an ExpirationService without Clear.
+type plainExpiration struct{}
+
+func (plainExpiration) BeforeLock(key uint64, shard *Shard)  {}
+func (plainExpiration) Lock(key uint64, shard *Shard)        {}
+func (plainExpiration) Access(key uint64, shard *Shard)      {}
+func (plainExpiration) AfterAccess(key uint64, shard *Shard) {}
+func (plainExpiration) Remove(key uint64, shard *Shard)      {}
+
+func TestShard_Clear_withoutClearer(t *testing.T) {
+	shard := NewShard(1024, 100, plainExpiration{})
+	shard.Put(1, GenVal())
+	shard.Clear()
+	shard.Put(1, GenVal())
+	shard.Reset()
+	if _, ok := shard.Get(1); ok {
+		t.Fatalf("Get after Reset got ok, want !ok")
+	}
+}
+
+func TestShard_Reset(t *testing.T) {
+	keys := GenShardKeys(4096)
+	shard := NewShard(1024, 100, nil)
+	for _, key := range keys {
+		shard.Put(key, GenVal())
+	}
+	shard.Reset()
+	if len(shard.array) != 1024 {
+		t.Fatalf("Reset capacity got %d, want %d", len(shard.array), 1024)
+	}
+	for _, key := range keys {
+		if _, ok := shard.Get(key); ok {
+			t.Fatalf("Get after Reset got ok, want !ok")
+		}
+	}
+	if err := shard.Put(keys[0], GenVal()); err != nil {
+		t.Fatalf("shard put: %v", err)
+	}
+	if _, ok := shard.Get(keys[0]); !ok {
+		t.Fatalf("Get after Reset and Put got !ok, want ok")
+	}
+}
+
+func TestShard_PutTTL(t *testing.T) {
+	shard := NewShard(1024, 100, nil)
+	shard.PutTTL(1, GenVal(), time.Millisecond*50)
+	shard.Put(2, GenVal())
+	if ttl, ok := shard.TTL(1); !ok || ttl <= 0 || ttl > time.Millisecond*50 {
+		t.Fatalf("TTL got %v,%v, want (0, 50ms],true", ttl, ok)
+	}
+	if ttl, ok := shard.TTL(2); !ok || ttl != 0 {
+		t.Fatalf("TTL got %v,%v, want 0,true", ttl, ok)
+	}
+	time.Sleep(time.Millisecond * 60)
+	if _, ok := shard.Get(1); ok {
+		t.Fatalf("Get of expired item got ok, want !ok")
+	}
+	if shard.Len() != 1 {
+		t.Fatalf("Len got %d, want 1", shard.Len())
+	}
+	if !shard.Expire(2, time.Hour) {
+		t.Fatalf("Expire got false, want true")
+	}
+	if ttl, _ := shard.TTL(2); ttl <= 0 {
+		t.Fatalf("TTL after Expire got %v, want > 0", ttl)
+	}
+}
+
+func TestShard_Update(t *testing.T) {
+	shard := NewShard(1024, 8, nil)
+	incr := func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, UpdateOp) {
+		if !ok {
+			return []byte{1}, time.Hour, UpdatePut
+		}
+		val[0]++
+		return val, ttl, UpdatePut
+	}
+	for i := 0; i < 3; i++ {
+		if err := shard.Update(1, incr); err != nil {
+			t.Fatalf("shard update: %v", err)
+		}
+	}
+	val, ok := shard.Get(1)
+	if !ok || val[0] != 3 {
+		t.Fatalf("Get after Update got %v,%v, want [3],true", val, ok)
+	}
+	if ttl, _ := shard.TTL(1); ttl <= 0 {
+		t.Fatalf("TTL after Update got %v, want > 0", ttl)
+	}
+	err := shard.Update(1, func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, UpdateOp) {
+		return make([]byte, 9), 0, UpdatePut
+	})
+	if err == nil {
+		t.Fatalf("To big update got nil, want err")
+	}
+	shard.Update(1, func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, UpdateOp) {
+		return nil, 0, UpdateDelete
+	})
+	if _, ok := shard.Get(1); ok {
+		t.Fatalf("Get after delete Update got ok, want !ok")
+	}
+}
@@ -0,0 +1,116 @@
+package bigmap
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// ProbeBuckets is the amount of buckets of the probe length histograms.
+// The last bucket counts all probe lengths of ProbeBuckets-1 or more.
+const ProbeBuckets = 16
+
+// ShardStats describes the memory layout of a shard.
+type ShardStats struct {
+	// Items is the amount of items in the shard.
+	Items int
+	// ArrayLength is the size of the byte-array.
+	ArrayLength uint64
+	// UsedSlots are the slots holding items.
+	UsedSlots uint64
+	// FreeSlots are the slots which can be used without growing,
+	// queued slots and the not yet used rest of the byte-array.
+	FreeSlots uint64
+	// QueueLength is the length of the PointerQueue of freed slots.
+	QueueLength int
+	// LoadFactor is the load factor of the IntMap of the shard.
+	LoadFactor float64
+	// ProbeHistogram counts the keys of the IntMap by probe length.
+	ProbeHistogram [ProbeBuckets]int
+	// SpinRetries and VerifyRetries count the lock contention.
+	SpinRetries   uint64
+	VerifyRetries uint64
+}
+
+// Stats is a snapshot of the layout of all shards of a map.
+type Stats struct {
+	// Shards are the stats of each shard.
+	Shards []ShardStats
+	// Total sums the stats of all shards.
+	// The LoadFactor is the mean load factor.
+	Total ShardStats
+	// MinItems and MaxItems are the item counts of the
+	// emptiest and the fullest shard.
+	MinItems int
+	MaxItems int
+	// Skew is the item count of the fullest shard divided by
+	// the mean item count. 1 means the items are spread evenly.
+	Skew float64
+	// StdDev is the standard deviation of the item counts.
+	StdDev float64
+}
+
+// Stats returns a snapshot of the layout of the shard.
+func (S *Shard) Stats() ShardStats {
+	slotSize := S.entrysize + S.header
+	for {
+		check := S.rlock()
+		stats := ShardStats{
+			Items:       S.ptrs.Len(),
+			ArrayLength: uint64(len(S.array)),
+			QueueLength: S.freePtrs.Len(),
+			LoadFactor:  S.ptrs.LoadFactor(),
+		}
+		slots := S.size / slotSize
+		stats.UsedSlots = slots - uint64(stats.QueueLength)
+		stats.FreeSlots = uint64(stats.QueueLength) + (stats.ArrayLength-S.size)/slotSize
+		S.ptrs.ProbeHistogram(stats.ProbeHistogram[:])
+		if S.verify(check) && stats.UsedSlots <= slots {
+			stats.SpinRetries = atomic.LoadUint64(&S.metrics.spinRetries)
+			stats.VerifyRetries = atomic.LoadUint64(&S.metrics.verifyRetries)
+			return stats
+		}
+	}
+}
+
+// Stats returns a snapshot of the layout of all shards.
+// The shards are inspected one after another, so the
+// snapshot isn't atomic across shards.
+func (B *BigMap) Stats() Stats {
+	shards := B.loadTable().shards
+	stats := Stats{Shards: make([]ShardStats, len(shards))}
+	for i, s := range shards {
+		shard := s.Stats()
+		stats.Shards[i] = shard
+		if i == 0 || shard.Items < stats.MinItems {
+			stats.MinItems = shard.Items
+		}
+		if shard.Items > stats.MaxItems {
+			stats.MaxItems = shard.Items
+		}
+		total := &stats.Total
+		total.Items += shard.Items
+		total.ArrayLength += shard.ArrayLength
+		total.UsedSlots += shard.UsedSlots
+		total.FreeSlots += shard.FreeSlots
+		total.QueueLength += shard.QueueLength
+		total.LoadFactor += shard.LoadFactor
+		total.SpinRetries += shard.SpinRetries
+		total.VerifyRetries += shard.VerifyRetries
+		for probes, n := range shard.ProbeHistogram {
+			total.ProbeHistogram[probes] += n
+		}
+	}
+	n := float64(len(shards))
+	stats.Total.LoadFactor /= n
+	mean := float64(stats.Total.Items) / n
+	if mean > 0 {
+		stats.Skew = float64(stats.MaxItems) / mean
+	}
+	var variance float64
+	for _, shard := range stats.Shards {
+		diff := float64(shard.Items) - mean
+		variance += diff * diff
+	}
+	stats.StdDev = math.Sqrt(variance / n)
+	return stats
+}
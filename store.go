@@ -0,0 +1,102 @@
+package bigmap
+
+import (
+	"time"
+)
+
+// Store is the set of operations shared by a BigMap, a ShardStore
+// and remote clients like resp.Client and cluster.Cluster.
+// Code written against a Store can switch between them
+// and be tested with a fake.
+//
+// The conformance of an implementation can be tested
+// with the storetest package.
+type Store interface {
+	// Put stores the item of the key.
+	Put(key, val []byte) error
+	// PutTTL stores the item like Put which expires after the ttl.
+	// A ttl smaller or equal to 0 never expires.
+	PutTTL(key, val []byte, ttl time.Duration) error
+	// Get returns the item of the key and true
+	// or nil and false if the item isn't contained.
+	Get(key []byte) ([]byte, bool)
+	// GetInto writes the item of the key into buffer and returns
+	// its size and true or 0 and false if the item isn't contained.
+	GetInto(key, buffer []byte) (uint64, bool)
+	// Delete removes the item of the key and returns
+	// true if it was contained.
+	Delete(key []byte) bool
+	// TTL returns the remaining time to live of the item and true
+	// if it is contained. A TTL of 0 means the item doesn't expire.
+	TTL(key []byte) (time.Duration, bool)
+	// Expire sets the time to live of an existing item.
+	// A ttl smaller or equal to 0 removes the expiration of the item.
+	// It returns false if the item isn't contained.
+	Expire(key []byte, ttl time.Duration) bool
+}
+
+var (
+	_ Store = (*BigMap)(nil)
+	_ Store = (*ShardStore)(nil)
+)
+
+// ShardStore is a Store of a single Shard
+// whose keys are hashed with a Hasher.
+type ShardStore struct {
+	shard  *Shard
+	hasher Hasher
+}
+
+// NewShardStore creates a new ShardStore of the shard.
+// A nil hasher hashes the keys with FNV64.
+func NewShardStore(shard *Shard, hasher Hasher) *ShardStore {
+	if hasher == nil {
+		hasher = FNVHasher{}
+	}
+	return &ShardStore{shard: shard, hasher: hasher}
+}
+
+// Shard returns the shard of the store.
+func (S *ShardStore) Shard() *Shard {
+	return S.shard
+}
+
+// Put stores the item in the shard.
+func (S *ShardStore) Put(key, val []byte) error {
+	_, err := S.shard.put(S.hasher.Hash(key), key, val, 0, nil, false)
+	return err
+}
+
+// PutTTL stores the item in the shard which expires after the ttl.
+// A ttl smaller or equal to 0 never expires.
+func (S *ShardStore) PutTTL(key, val []byte, ttl time.Duration) error {
+	_, err := S.shard.put(S.hasher.Hash(key), key, val, deadlineOf(ttl), nil, false)
+	return err
+}
+
+// Get retrieves the item of the key from the shard.
+func (S *ShardStore) Get(key []byte) ([]byte, bool) {
+	return S.shard.Get(S.hasher.Hash(key))
+}
+
+// GetInto retrieves the item of the key and writes it into buffer.
+func (S *ShardStore) GetInto(key, buffer []byte) (uint64, bool) {
+	return S.shard.GetInto(S.hasher.Hash(key), buffer)
+}
+
+// Delete removes the item of the key from the shard.
+func (S *ShardStore) Delete(key []byte) bool {
+	deleted, _ := S.shard.delete(S.hasher.Hash(key), key)
+	return deleted
+}
+
+// TTL returns the remaining time to live of the item.
+func (S *ShardStore) TTL(key []byte) (time.Duration, bool) {
+	return S.shard.TTL(S.hasher.Hash(key))
+}
+
+// Expire sets the time to live of an existing item.
+func (S *ShardStore) Expire(key []byte, ttl time.Duration) bool {
+	ok, _ := S.shard.expireAt(S.hasher.Hash(key), key, deadlineOf(ttl))
+	return ok
+}
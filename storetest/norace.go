@@ -0,0 +1,6 @@
+//go:build !race
+
+package storetest
+
+// raceEnabled is set if the tests run with the race detector.
+const raceEnabled = false
@@ -0,0 +1,9 @@
+//go:build race
+
+package storetest
+
+// raceEnabled is set if the tests run with the race detector.
+// Reads of a BigMap are optimistic, they read the shards while they
+// may be written and verify the version of their lock afterwards.
+// The race detector can't see the verification and reports them.
+const raceEnabled = true
@@ -0,0 +1,218 @@
+// Package storetest implements a conformance test suite
+// for implementations of bigmap.Store.
+//
+// The suite stores keys of up to 32 bytes and values of up to
+// 64 bytes and relies on ttls with a resolution of a millisecond.
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/worldOneo/bigmap"
+)
+
+// Run runs the conformance tests as subtests of t.
+// Every subtest gets an empty store from newStore.
+func Run(t *testing.T, newStore func(t *testing.T) bigmap.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store bigmap.Store)
+	}{
+		{"PutGet", testPutGet},
+		{"GetInto", testGetInto},
+		{"Delete", testDelete},
+		{"Keys", testKeys},
+		{"TTL", testTTL},
+		{"Expire", testExpire},
+		{"Expiration", testExpiration},
+		{"Concurrent", testConcurrent},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore(t))
+		})
+	}
+}
+
+func put(t *testing.T, store bigmap.Store, key, val string) {
+	t.Helper()
+	if err := store.Put([]byte(key), []byte(val)); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func expect(t *testing.T, store bigmap.Store, key, want string) {
+	t.Helper()
+	val, ok := store.Get([]byte(key))
+	if !ok || string(val) != want {
+		t.Fatalf("Get(%q) got %q, %v, want %q", key, val, ok, want)
+	}
+}
+
+func expectMissing(t *testing.T, store bigmap.Store, key string) {
+	t.Helper()
+	if val, ok := store.Get([]byte(key)); ok {
+		t.Fatalf("Get(%q) got %q, want a miss", key, val)
+	}
+}
+
+func testPutGet(t *testing.T, store bigmap.Store) {
+	expectMissing(t, store, "key")
+	put(t, store, "key", "value")
+	expect(t, store, "key", "value")
+	put(t, store, "key", "other")
+	expect(t, store, "key", "other")
+	put(t, store, "empty", "")
+	expect(t, store, "empty", "")
+	long := string(bytes.Repeat([]byte{'v'}, 64))
+	put(t, store, "long", long)
+	expect(t, store, "long", long)
+}
+
+func testGetInto(t *testing.T, store bigmap.Store) {
+	buffer := make([]byte, 64)
+	if size, ok := store.GetInto([]byte("key"), buffer); ok || size != 0 {
+		t.Fatalf("GetInto got %d, %v, want a miss", size, ok)
+	}
+	put(t, store, "key", "value")
+	size, ok := store.GetInto([]byte("key"), buffer)
+	if !ok || string(buffer[:size]) != "value" {
+		t.Fatalf("GetInto got %q, %v, want %q", buffer[:size], ok, "value")
+	}
+}
+
+func testDelete(t *testing.T, store bigmap.Store) {
+	if store.Delete([]byte("key")) {
+		t.Fatalf("Delete of a missing key returned true")
+	}
+	put(t, store, "key", "value")
+	put(t, store, "other", "value")
+	if !store.Delete([]byte("key")) {
+		t.Fatalf("Delete returned false")
+	}
+	expectMissing(t, store, "key")
+	expect(t, store, "other", "value")
+	if store.Delete([]byte("key")) {
+		t.Fatalf("second Delete returned true")
+	}
+}
+
+func testKeys(t *testing.T, store bigmap.Store) {
+	binary := string([]byte{0, 1, 2, 0xff, '\r', '\n'})
+	put(t, store, binary, "binary")
+	for i := 0; i < 1000; i++ {
+		put(t, store, fmt.Sprintf("key-%d", i), fmt.Sprint(i))
+	}
+	expect(t, store, binary, "binary")
+	for i := 0; i < 1000; i++ {
+		expect(t, store, fmt.Sprintf("key-%d", i), fmt.Sprint(i))
+	}
+}
+
+func testTTL(t *testing.T, store bigmap.Store) {
+	if _, ok := store.TTL([]byte("key")); ok {
+		t.Fatalf("TTL of a missing key returned true")
+	}
+	put(t, store, "key", "value")
+	if ttl, ok := store.TTL([]byte("key")); !ok || ttl != 0 {
+		t.Fatalf("TTL got %v, %v, want 0, true", ttl, ok)
+	}
+	if err := store.PutTTL([]byte("ttl"), []byte("value"), time.Hour); err != nil {
+		t.Fatalf("PutTTL: %v", err)
+	}
+	if ttl, ok := store.TTL([]byte("ttl")); !ok || ttl <= time.Hour-time.Minute || ttl > time.Hour {
+		t.Fatalf("TTL got %v, %v, want about an hour", ttl, ok)
+	}
+	if err := store.PutTTL([]byte("ttl"), []byte("value"), 0); err != nil {
+		t.Fatalf("PutTTL: %v", err)
+	}
+	if ttl, ok := store.TTL([]byte("ttl")); !ok || ttl != 0 {
+		t.Fatalf("TTL after PutTTL without ttl got %v, %v, want 0, true", ttl, ok)
+	}
+}
+
+func testExpire(t *testing.T, store bigmap.Store) {
+	if store.Expire([]byte("key"), time.Hour) {
+		t.Fatalf("Expire of a missing key returned true")
+	}
+	put(t, store, "key", "value")
+	if !store.Expire([]byte("key"), time.Hour) {
+		t.Fatalf("Expire returned false")
+	}
+	if ttl, ok := store.TTL([]byte("key")); !ok || ttl <= time.Hour-time.Minute {
+		t.Fatalf("TTL got %v, %v, want about an hour", ttl, ok)
+	}
+	if !store.Expire([]byte("key"), 0) {
+		t.Fatalf("Expire removing the ttl returned false")
+	}
+	if ttl, ok := store.TTL([]byte("key")); !ok || ttl != 0 {
+		t.Fatalf("TTL got %v, %v, want 0, true", ttl, ok)
+	}
+	if !store.Expire([]byte("key"), 0) {
+		t.Fatalf("Expire of an item without ttl returned false")
+	}
+	expect(t, store, "key", "value")
+}
+
+func testExpiration(t *testing.T, store bigmap.Store) {
+	if err := store.PutTTL([]byte("key"), []byte("value"), 10*time.Millisecond); err != nil {
+		t.Fatalf("PutTTL: %v", err)
+	}
+	put(t, store, "other", "value")
+	if !store.Expire([]byte("other"), 10*time.Millisecond) {
+		t.Fatalf("Expire returned false")
+	}
+	time.Sleep(50 * time.Millisecond)
+	expectMissing(t, store, "key")
+	expectMissing(t, store, "other")
+	if _, ok := store.TTL([]byte("key")); ok {
+		t.Fatalf("TTL of an expired item returned true")
+	}
+}
+
+func testConcurrent(t *testing.T, store bigmap.Store) {
+	if raceEnabled {
+		t.Skip("optimistic reads of concurrent writes are reported by the race detector")
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(fmt.Sprintf("worker-%d-%d", worker, i))
+				if err := store.Put(key, key); err != nil {
+					errs <- err
+					return
+				}
+				if val, ok := store.Get(key); !ok || !bytes.Equal(val, key) {
+					errs <- fmt.Errorf("Get(%q) got %q, %v", key, val, ok)
+					return
+				}
+				if i%2 == 0 && !store.Delete(key) {
+					errs <- fmt.Errorf("Delete(%q) returned false", key)
+					return
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("worker-3-%d", i)
+		if i%2 == 0 {
+			expectMissing(t, store, key)
+		} else {
+			expect(t, store, key, key)
+		}
+	}
+}
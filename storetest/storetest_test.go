@@ -0,0 +1,43 @@
+package storetest
+
+import (
+	"testing"
+
+	"github.com/worldOneo/bigmap"
+)
+
+func TestBigMap(t *testing.T) {
+	Run(t, func(t *testing.T) bigmap.Store {
+		bm := bigmap.New(64, bigmap.Config{Shards: 4})
+		return &bm
+	})
+}
+
+func TestShardStore(t *testing.T) {
+	Run(t, func(t *testing.T) bigmap.Store {
+		return bigmap.NewShardStore(bigmap.NewShard(1024, 64, nil), nil)
+	})
+}
+
+func TestNamespace(t *testing.T) {
+	Run(t, func(t *testing.T) bigmap.Store {
+		bm := bigmap.New(64, bigmap.Config{Shards: 4})
+		return bm.Namespace("tenant")
+	})
+}
+
+type discard struct{}
+
+func (discard) Write(batch []bigmap.BackingWrite) error { return nil }
+
+func TestBackedMap(t *testing.T) {
+	for _, mode := range []bigmap.WriteMode{bigmap.WriteThrough, bigmap.WriteBehind} {
+		mode := mode
+		Run(t, func(t *testing.T) bigmap.Store {
+			bm := bigmap.New(64, bigmap.Config{Shards: 4})
+			backed := bigmap.NewBackedMap(&bm, discard{}, bigmap.BackingConfig{Mode: mode})
+			t.Cleanup(func() { backed.Close() })
+			return backed
+		})
+	}
+}
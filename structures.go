@@ -0,0 +1,307 @@
+package bigmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	// structureFlag marks values holding a data structure
+	// in the length word of a slot.
+	structureFlag uint64 = 1 << 60
+	// The first byte of a structure is its type followed by
+	// its entries which are prefixed with their uvarint length.
+	hashStructure byte = 'h'
+	listStructure byte = 'l'
+	setStructure  byte = 's'
+)
+
+// ErrWrongType is returned by operations on data structures if the
+// item of the key isn't a structure of the type of the operation.
+var ErrWrongType = errors.New("bigmap: operation against an item of another type")
+
+// errUnchanged is returned by the functions passed to mutate
+// if the structure isn't modified, so it isn't stored again.
+var errUnchanged = errors.New("bigmap: structure unchanged")
+
+// entries calls fn for every entry of the structure with its offset
+// until fn returns false. ErrCorrupt is returned for invalid entries.
+func entries(body []byte, fn func(entry []byte, offset int) bool) error {
+	for i := 0; i < len(body); {
+		n, size := binary.Uvarint(body[i:])
+		if size <= 0 || n > uint64(len(body)-i-size) {
+			return ErrCorrupt
+		}
+		offset := i
+		i += size
+		if !fn(body[i:i+int(n)], offset) {
+			return nil
+		}
+		i += int(n)
+	}
+	return nil
+}
+
+// pairs calls fn for every field and value of a hash until fn returns false.
+func pairs(body []byte, fn func(field, val []byte) bool) error {
+	var field []byte
+	odd := false
+	more := true
+	err := entries(body, func(entry []byte, _ int) bool {
+		if !odd {
+			field, odd = entry, true
+			return true
+		}
+		odd = false
+		more = fn(field, entry)
+		return more
+	})
+	if err == nil && odd && more {
+		return ErrCorrupt
+	}
+	return err
+}
+
+// appendEntry appends the entry with its length to dst.
+func appendEntry(dst, entry []byte) []byte {
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(entry)))
+	return append(append(dst, length[:n]...), entry...)
+}
+
+// contains reports whether the structure contains the entry.
+func contains(body, member []byte) (bool, error) {
+	found := false
+	err := entries(body, func(entry []byte, _ int) bool {
+		found = bytes.Equal(entry, member)
+		return !found
+	})
+	return found, err
+}
+
+// mutate modifies the structure of the key under the lock of its shard.
+// fn is called with the entries of the structure, nil if it isn't
+// contained, and returns its new entries. A structure without entries
+// is removed. The ttl of the structure is kept.
+// If fn returns errUnchanged the structure is kept as it is.
+func (B *BigMap) mutate(key []byte, typ byte, fn func(body []byte) ([]byte, error)) (err error) {
+	h := B.hasher.Hash(key)
+	update := func(val []byte, ttl time.Duration, ok bool) ([]byte, time.Duration, UpdateOp) {
+		var body []byte
+		if ok {
+			if len(val) == 0 || val[0] != typ {
+				err = ErrWrongType
+				return nil, 0, UpdateKeep
+			}
+			body = val[1:]
+		}
+		next, fnErr := fn(body)
+		switch {
+		case fnErr == errUnchanged:
+			return nil, 0, UpdateKeep
+		case fnErr != nil:
+			err = fnErr
+			return nil, 0, UpdateKeep
+		case len(next) == 0 && ok:
+			return nil, 0, UpdateDelete
+		case len(next) == 0:
+			return nil, 0, UpdateKeep
+		}
+		stored := make([]byte, 0, 1+len(next))
+		return append(append(stored, typ), next...), ttl, UpdatePut
+	}
+	B.write(h, func(shard *Shard) (ok bool) {
+		var updateErr error
+		ok, updateErr = shard.update(h, key, update, true)
+		if updateErr != nil {
+			err = updateErr
+		}
+		return ok
+	})
+	return err
+}
+
+// inspect calls fn with the entries of the structure of the key
+// and returns false if the key isn't contained.
+func (B *BigMap) inspect(key []byte, typ byte, fn func(body []byte) error) (bool, error) {
+	var err error
+	ok, viewErr := B.view(B.hasher.Hash(key), func(val []byte, flags uint64) {
+		if flags&structureFlag == 0 || len(val) == 0 || val[0] != typ {
+			err = ErrWrongType
+			return
+		}
+		err = fn(val[1:])
+	})
+	if viewErr != nil {
+		return ok, viewErr
+	}
+	return ok, err
+}
+
+// HSet sets the field of the hash of the key to the value
+// and returns true if the field was added.
+// The hash is created if the key isn't contained.
+//
+// Hashes, lists and sets are stored in a single slot and modified
+// under the lock of their shard, so their encoding has to fit
+// into the entrysize. Get returns their encoding.
+func (B *BigMap) HSet(key, field, val []byte) (added bool, err error) {
+	err = B.mutate(key, hashStructure, func(body []byte) ([]byte, error) {
+		next := make([]byte, 0, len(body)+len(field)+len(val)+2*binary.MaxVarintLen64)
+		added = true
+		err := pairs(body, func(f, v []byte) bool {
+			if bytes.Equal(f, field) {
+				added = false
+				v = val
+			}
+			next = appendEntry(appendEntry(next, f), v)
+			return true
+		})
+		if added {
+			next = appendEntry(appendEntry(next, field), val)
+		}
+		return next, err
+	})
+	return added && err == nil, err
+}
+
+// HGet returns the value of the field of the hash of the key
+// and true or nil and false if the field isn't contained.
+func (B *BigMap) HGet(key, field []byte) (val []byte, ok bool, err error) {
+	_, err = B.inspect(key, hashStructure, func(body []byte) error {
+		return pairs(body, func(f, v []byte) bool {
+			if bytes.Equal(f, field) {
+				val, ok = append([]byte{}, v...), true
+			}
+			return !ok
+		})
+	})
+	return val, ok, err
+}
+
+// HDel removes the field from the hash of the key and returns
+// true if it was contained. An empty hash is removed.
+func (B *BigMap) HDel(key, field []byte) (deleted bool, err error) {
+	err = B.mutate(key, hashStructure, func(body []byte) ([]byte, error) {
+		next := make([]byte, 0, len(body))
+		err := pairs(body, func(f, v []byte) bool {
+			if bytes.Equal(f, field) {
+				deleted = true
+			} else {
+				next = appendEntry(appendEntry(next, f), v)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			return nil, errUnchanged
+		}
+		return next, nil
+	})
+	return deleted && err == nil, err
+}
+
+// LPush inserts the values at the head of the list of the key
+// one after another and returns the length of the list.
+// The list is created if the key isn't contained.
+func (B *BigMap) LPush(key []byte, vals ...[]byte) (length int, err error) {
+	err = B.mutate(key, listStructure, func(body []byte) ([]byte, error) {
+		length = len(vals)
+		if err := entries(body, func([]byte, int) bool {
+			length++
+			return true
+		}); err != nil {
+			return nil, err
+		}
+		var next []byte
+		for i := len(vals) - 1; i >= 0; i-- {
+			next = appendEntry(next, vals[i])
+		}
+		return append(next, body...), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// RPop removes and returns the last value of the list of the key
+// and true or nil and false if the list isn't contained.
+// An empty list is removed.
+func (B *BigMap) RPop(key []byte) (val []byte, ok bool, err error) {
+	err = B.mutate(key, listStructure, func(body []byte) ([]byte, error) {
+		last := -1
+		if err := entries(body, func(entry []byte, offset int) bool {
+			val, last = entry, offset
+			return true
+		}); err != nil || last < 0 {
+			return body, err
+		}
+		val, ok = append([]byte{}, val...), true
+		return body[:last], nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return val, ok, nil
+}
+
+// SAdd adds the members to the set of the key and
+// returns the amount of members which weren't contained.
+// The set is created if the key isn't contained.
+func (B *BigMap) SAdd(key []byte, members ...[]byte) (added int, err error) {
+	err = B.mutate(key, setStructure, func(body []byte) ([]byte, error) {
+		added = 0
+		next := append([]byte(nil), body...)
+		for _, member := range members {
+			found, err := contains(next, member)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				next = appendEntry(next, member)
+				added++
+			}
+		}
+		if added == 0 {
+			return nil, errUnchanged
+		}
+		return next, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// SIsMember reports whether the member is in the set of the key.
+func (B *BigMap) SIsMember(key, member []byte) (found bool, err error) {
+	_, err = B.inspect(key, setStructure, func(body []byte) error {
+		found, err = contains(body, member)
+		return err
+	})
+	return found, err
+}
+
+// PutStructureHash puts a hash, list or set under the hash of its key
+// as reported by Event.Structure or SnapshotItem.Structure,
+// e.g. to copy it into another map.
+// ErrCorrupt is returned if the value isn't the encoding of a structure.
+// A ttl smaller or equal to 0 never expires.
+func (B *BigMap) PutStructureHash(hash uint64, val []byte, ttl time.Duration) (err error) {
+	if len(val) < 2 || (val[0] != hashStructure && val[0] != listStructure && val[0] != setStructure) {
+		return ErrCorrupt
+	}
+	if err := entries(val[1:], func([]byte, int) bool { return true }); err != nil {
+		return err
+	}
+	B.write(hash, func(shard *Shard) (ok bool) {
+		ok, err = shard.put(hash, nil, val, deadlineOf(ttl), nil, true)
+		return ok
+	})
+	return err
+}
@@ -0,0 +1,129 @@
+package bigmap
+
+import (
+	"compress/flate"
+	"testing"
+	"time"
+)
+
+func TestBigMap_Hash(t *testing.T) {
+	bm := New(64, Config{Shards: 2})
+	if added, err := bm.HSet([]byte("user"), []byte("name"), []byte("alice")); !added || err != nil {
+		t.Fatalf("HSet of a new field got %v, %v", added, err)
+	}
+	bm.HSet([]byte("user"), []byte("age"), []byte("30"))
+	if added, _ := bm.HSet([]byte("user"), []byte("name"), []byte("bob")); added {
+		t.Fatalf("HSet of an existing field returned true")
+	}
+	if val, ok, err := bm.HGet([]byte("user"), []byte("name")); !ok || err != nil || string(val) != "bob" {
+		t.Fatalf("HGet got %q, %v, %v", val, ok, err)
+	}
+	if _, ok, _ := bm.HGet([]byte("user"), []byte("missing")); ok {
+		t.Fatalf("HGet of a missing field returned true")
+	}
+	puts := bm.TotalMetrics().Puts
+	if deleted, err := bm.HDel([]byte("user"), []byte("missing")); deleted || err != nil {
+		t.Fatalf("HDel of a missing field got %v, %v", deleted, err)
+	}
+	if bm.TotalMetrics().Puts != puts {
+		t.Fatalf("HDel of a missing field stored the hash")
+	}
+	for _, field := range []string{"name", "age"} {
+		if deleted, err := bm.HDel([]byte("user"), []byte(field)); !deleted || err != nil {
+			t.Fatalf("HDel(%q) got %v, %v", field, deleted, err)
+		}
+	}
+	if _, ok := bm.Get([]byte("user")); ok {
+		t.Fatalf("empty hash wasn't removed")
+	}
+	if _, err := bm.HSet([]byte("user"), []byte("bio"), make([]byte, 64)); err == nil {
+		t.Fatalf("expected an error for a hash larger than the entrysize")
+	}
+}
+
+func TestBigMap_List(t *testing.T) {
+	bm := New(64, Config{Shards: 2, Compression: flate.BestSpeed})
+	bm.PutTTL([]byte("plain"), []byte("value"), time.Minute)
+	if n, err := bm.LPush([]byte("list"), []byte("a"), []byte("b")); n != 2 || err != nil {
+		t.Fatalf("LPush got %d, %v", n, err)
+	}
+	if n, _ := bm.LPush([]byte("list"), []byte("c")); n != 3 {
+		t.Fatalf("LPush got length %d, want 3", n)
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if val, ok, err := bm.RPop([]byte("list")); !ok || err != nil || string(val) != want {
+			t.Fatalf("RPop got %q, %v, %v, want %q", val, ok, err, want)
+		}
+	}
+	if _, ok, _ := bm.RPop([]byte("list")); ok {
+		t.Fatalf("RPop of an empty list returned true")
+	}
+	if _, err := bm.LPush([]byte("plain"), []byte("a")); err != ErrWrongType {
+		t.Fatalf("LPush to a plain value got %v", err)
+	}
+	if val, _ := bm.Get([]byte("plain")); string(val) != "value" {
+		t.Fatalf("LPush to a plain value changed it to %q", val)
+	}
+}
+
+func TestBigMap_Set(t *testing.T) {
+	bm := New(64, Config{Shards: 2, Checksums: true})
+	if added, err := bm.SAdd([]byte("set"), []byte("a"), []byte("b"), []byte("a")); added != 2 || err != nil {
+		t.Fatalf("SAdd got %d, %v", added, err)
+	}
+	bm.Expire([]byte("set"), time.Minute)
+	if added, _ := bm.SAdd([]byte("set"), []byte("b"), []byte("c")); added != 1 {
+		t.Fatalf("SAdd got %d added members, want 1", added)
+	}
+	if ttl, _ := bm.TTL([]byte("set")); ttl == 0 {
+		t.Fatalf("SAdd removed the ttl of the set")
+	}
+	puts := bm.TotalMetrics().Puts
+	if added, err := bm.SAdd([]byte("set"), []byte("a"), []byte("c")); added != 0 || err != nil {
+		t.Fatalf("SAdd of existing members got %d, %v", added, err)
+	}
+	if bm.TotalMetrics().Puts != puts {
+		t.Fatalf("SAdd of existing members stored the set")
+	}
+	if err := bm.Reshard(3); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	for member, want := range map[string]bool{"a": true, "c": true, "d": false} {
+		if found, err := bm.SIsMember([]byte("set"), []byte(member)); found != want || err != nil {
+			t.Fatalf("SIsMember(%q) got %v, %v", member, found, err)
+		}
+	}
+	if _, err := bm.HSet([]byte("set"), []byte("field"), nil); err != ErrWrongType {
+		t.Fatalf("HSet to a set got %v", err)
+	}
+	bm.Put([]byte("plain"), []byte("s"))
+	if _, err := bm.SIsMember([]byte("plain"), []byte("s")); err != ErrWrongType {
+		t.Fatalf("SIsMember of a plain value got %v", err)
+	}
+}
+
+func TestBigMap_PutStructureHash(t *testing.T) {
+	bm := New(64, Config{Shards: 2})
+	bm.SAdd([]byte("set"), []byte("member"))
+	bm.PutString("plain", []byte("value"))
+	copied := New(64, Config{Shards: 2})
+	bm.SnapshotItems(func(item SnapshotItem) bool {
+		if item.Structure {
+			if err := copied.PutStructureHash(item.Hash, item.Value, item.TTL); err != nil {
+				t.Fatalf("PutStructureHash: %v", err)
+			}
+		} else {
+			copied.PutHash(item.Hash, item.Value, item.TTL)
+		}
+		return true
+	})
+	if found, err := copied.SIsMember([]byte("set"), []byte("member")); !found || err != nil {
+		t.Fatalf("expected the copied set, got %v, %v", found, err)
+	}
+	if _, err := copied.SIsMember([]byte("plain"), []byte("value")); err != ErrWrongType {
+		t.Fatalf("expected the copied plain value, got %v", err)
+	}
+	if err := copied.PutStructureHash(1, []byte("value"), 0); err != ErrCorrupt {
+		t.Fatalf("PutStructureHash of a plain value got %v", err)
+	}
+}
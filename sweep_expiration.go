@@ -33,11 +33,12 @@ func (p *sweepExpirationService) Lock(key uint64, shard *Shard) {
 	}
 	for itemKey, itemAccessed := range p.accesses {
 		if now-itemAccessed > p.Expires {
-			shard.UnsafeDelete(itemKey)
+			shard.evict(itemKey)
 			delete(p.accesses, itemKey)
 		}
 	}
 	p.lastCheck = now
+	count(&shard.metrics.sweeps)
 }
 
 func (p *sweepExpirationService) Access(key uint64, shard *Shard) {
@@ -50,3 +51,7 @@ func (p *sweepExpirationService) AfterAccess(key uint64, shard *Shard) {
 func (p *sweepExpirationService) Remove(key uint64, shard *Shard) {
 	delete(p.accesses, key)
 }
+
+func (p *sweepExpirationService) Clear(shard *Shard) {
+	p.accesses = make(map[uint64]int64)
+}
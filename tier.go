@@ -0,0 +1,396 @@
+package bigmap
+
+import (
+	"os"
+	"sync"
+)
+
+const (
+	// DefaultOverflowGarbage is the default fraction of garbage
+	// in the log of a disk tier which triggers a compaction.
+	DefaultOverflowGarbage = 0.5
+	// minCompactionBytes is the size a log has to reach
+	// before it is compacted.
+	minCompactionBytes = 64 << 10
+)
+
+// diskTier is the on-disk overflow of a shard.
+// Values evicted from the shard are appended to a log file and
+// located by an in-memory index. Overwritten and removed values
+// stay in the log as garbage until it is compacted in the background.
+//
+// The log only lives as long as the map, it isn't reloaded on start.
+//
+// Evictions spill and reads promote while their shard is write locked,
+// so the shard waits for the write or read of the log file. Only the
+// compaction runs without the lock of the shard. The log is meant to
+// be placed on a local disk, e.g. an SSD, not a network file system.
+type diskTier struct {
+	lock       sync.Mutex
+	dir        string
+	garbage    float64
+	file       *os.File
+	size       int64
+	live       int64
+	index      map[uint64]diskRecord
+	generation uint64
+	compacting bool
+	closed     bool
+	metrics    *shardMetrics
+}
+
+// diskRecord locates a value in the log.
+type diskRecord struct {
+	offset   int64
+	length   int64
+	deadline int64
+	lifetime int64
+	flags    uint64
+	checksum uint32
+}
+
+func newDiskTier(dir string, garbage float64, metrics *shardMetrics) *diskTier {
+	return &diskTier{
+		dir:     dir,
+		garbage: garbage,
+		index:   make(map[uint64]diskRecord),
+		metrics: metrics,
+	}
+}
+
+// spill appends the value to the log and indexes it with the
+// deadline, lifetime and flags of the record.
+// The log file is created by the first spill.
+func (T *diskTier) spill(key uint64, val []byte, record diskRecord) error {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	if T.closed {
+		return os.ErrClosed
+	}
+	if T.file == nil {
+		file, err := os.CreateTemp(T.dir, "bigmap-*.log")
+		if err != nil {
+			return err
+		}
+		T.file = file
+	}
+	if _, err := T.file.WriteAt(val, T.size); err != nil {
+		return err
+	}
+	T.drop(key)
+	record.offset, record.length = T.size, int64(len(val))
+	T.index[key] = record
+	T.size += int64(len(val))
+	T.live += int64(len(val))
+	count(&T.metrics.spills)
+	return nil
+}
+
+// take reads the value of the key and removes it from the tier.
+// Values which can't be read are kept.
+func (T *diskTier) take(key uint64) ([]byte, diskRecord, bool) {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	val, record, ok := T.load(key)
+	if ok {
+		T.drop(key)
+	}
+	return val, record, ok
+}
+
+// read reads the value of the key without removing it.
+func (T *diskTier) read(key uint64) ([]byte, diskRecord, bool) {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	return T.load(key)
+}
+
+// load reads the value of the key.
+// The tier must be locked.
+func (T *diskTier) load(key uint64) ([]byte, diskRecord, bool) {
+	record, ok := T.index[key]
+	if !ok {
+		return nil, record, false
+	}
+	val := make([]byte, record.length)
+	if _, err := T.file.ReadAt(val, record.offset); err != nil {
+		count(&T.metrics.diskErrors)
+		return nil, record, false
+	}
+	return val, record, true
+}
+
+// lookup returns the record of the key.
+func (T *diskTier) lookup(key uint64) (diskRecord, bool) {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	record, ok := T.index[key]
+	return record, ok
+}
+
+// contains reports whether the key is in the tier.
+func (T *diskTier) contains(key uint64) bool {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	_, ok := T.index[key]
+	return ok
+}
+
+// remove removes the key from the tier.
+func (T *diskTier) remove(key uint64) bool {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	return T.drop(key)
+}
+
+// drop removes the key from the index and compacts
+// the log if it holds too much garbage.
+// The tier must be locked.
+func (T *diskTier) drop(key uint64) bool {
+	record, ok := T.index[key]
+	if !ok {
+		return false
+	}
+	delete(T.index, key)
+	T.live -= record.length
+	if !T.compacting && T.size >= minCompactionBytes &&
+		float64(T.size-T.live) > T.garbage*float64(T.size) {
+		T.compacting = true
+		go T.compact()
+	}
+	return true
+}
+
+// rangeRecords calls fn for every value in the tier until fn returns false.
+// Values which can't be read are skipped.
+func (T *diskTier) rangeRecords(fn func(key uint64, val []byte, record diskRecord) bool) bool {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	var val []byte
+	for key, record := range T.index {
+		val = resize(val, record.length)
+		if _, err := T.file.ReadAt(val, record.offset); err != nil {
+			count(&T.metrics.diskErrors)
+			continue
+		}
+		if !fn(key, val, record) {
+			return false
+		}
+	}
+	return true
+}
+
+// stats returns the amount of values and the size of the log.
+func (T *diskTier) stats() (int, int64) {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	return len(T.index), T.size
+}
+
+// clear removes all values and truncates the log.
+func (T *diskTier) clear() {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	T.index = make(map[uint64]diskRecord)
+	T.size, T.live = 0, 0
+	T.generation++
+	if T.file != nil {
+		T.file.Truncate(0)
+	}
+}
+
+// close removes the log file.
+// Following spills fail.
+func (T *diskTier) close() error {
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	T.closed = true
+	T.index = make(map[uint64]diskRecord)
+	T.size, T.live = 0, 0
+	if T.file == nil {
+		return nil
+	}
+	file := T.file
+	T.file = nil
+	err := file.Close()
+	if rmErr := os.Remove(file.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// compact copies the live values into a new log and replaces the old one.
+// The values are copied without holding the lock, only the values
+// spilled while copying are copied again after the tier is locked.
+func (T *diskTier) compact() {
+	T.lock.Lock()
+	old, generation := T.file, T.generation
+	offsets := make(map[uint64]int64, len(T.index))
+	records := make([]diskRecord, 0, len(T.index))
+	keys := make([]uint64, 0, len(T.index))
+	for key, record := range T.index {
+		offsets[key] = record.offset
+		records = append(records, record)
+		keys = append(keys, key)
+	}
+	T.lock.Unlock()
+
+	file, err := os.CreateTemp(T.dir, "bigmap-*.log")
+	if err != nil {
+		T.abortCompaction(nil)
+		return
+	}
+	moved := make(map[uint64]diskRecord, len(records))
+	var size int64
+	var val []byte
+	for i, record := range records {
+		val = resize(val, record.length)
+		if _, err := old.ReadAt(val, record.offset); err != nil {
+			T.abortCompaction(file)
+			return
+		}
+		if _, err := file.WriteAt(val, size); err != nil {
+			T.abortCompaction(file)
+			return
+		}
+		record.offset = size
+		moved[keys[i]] = record
+		size += record.length
+	}
+
+	T.lock.Lock()
+	defer T.lock.Unlock()
+	if T.closed || T.generation != generation {
+		// the tier was closed or cleared while copying
+		T.compacting = false
+		file.Close()
+		os.Remove(file.Name())
+		return
+	}
+	index := make(map[uint64]diskRecord, len(T.index))
+	for key, record := range T.index {
+		if offset, ok := offsets[key]; ok && offset == record.offset {
+			index[key] = moved[key]
+			continue
+		}
+		// spilled while copying
+		val = resize(val, record.length)
+		if _, err := old.ReadAt(val, record.offset); err != nil {
+			count(&T.metrics.diskErrors)
+			continue
+		}
+		if _, err := file.WriteAt(val, size); err != nil {
+			T.compacting = false
+			file.Close()
+			os.Remove(file.Name())
+			count(&T.metrics.diskErrors)
+			return
+		}
+		record.offset = size
+		index[key] = record
+		size += record.length
+	}
+	T.file, T.index, T.size, T.live = file, index, size, size
+	T.compacting = false
+	old.Close()
+	os.Remove(old.Name())
+	count(&T.metrics.compactions)
+}
+
+// abortCompaction removes the partial log of a failed compaction.
+func (T *diskTier) abortCompaction(file *os.File) {
+	if file != nil {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	count(&T.metrics.diskErrors)
+	T.lock.Lock()
+	T.compacting = false
+	T.lock.Unlock()
+}
+
+// resize returns a buffer of the length reusing buffer if possible.
+func resize(buffer []byte, length int64) []byte {
+	if int64(cap(buffer)) < length {
+		return make([]byte, length)
+	}
+	return buffer[:length]
+}
+
+// promote moves the item of the key from the disk tier into the shard.
+// It returns false if the item isn't contained in the tier.
+func (S *Shard) promote(key uint64) bool {
+	if S.tier == nil || !S.tier.contains(key) {
+		return false
+	}
+	S.lock.Lock()
+	defer S.lock.Unlock()
+	if S.isRetired() {
+		return false
+	}
+	_, ok := S.live(key)
+	if ok {
+		S.hitExpirationService(key, ExpirationService.Access)
+	}
+	return ok
+}
+
+// unsafePromote moves the item like promote and returns its pointer.
+// Expired and corrupt items are dropped.
+// The shard must be locked.
+func (S *Shard) unsafePromote(key uint64) (uint64, bool) {
+	if S.tier == nil {
+		return 0, false
+	}
+	if record, ok := S.tier.lookup(key); ok && S.expired(record.deadline) {
+		S.notifyRemove(EventExpire, key, nil)
+		S.UnsafeDelete(key)
+		count(&S.metrics.expirations)
+		return 0, false
+	}
+	val, record, ok := S.tier.take(key)
+	if !ok {
+		return 0, false
+	}
+	if S.checkRecord(key, val, record) != nil {
+		S.forget(key)
+		return 0, false
+	}
+	S.write(key, val, record.flags, record.deadline, record.lifetime)
+	count(&S.metrics.promotions)
+	return S.ptrs.Get(key)
+}
+
+// diskValue returns the decoded value of the item in the disk tier
+// or nil if it can't be read.
+// The shard must be locked.
+func (S *Shard) diskValue(key uint64) []byte {
+	val, record, ok := S.tier.read(key)
+	if !ok || S.checkRecord(key, val, record) != nil {
+		return nil
+	}
+	val, err := S.decode(nil, key, val, record.flags)
+	if err != nil {
+		return nil
+	}
+	return val
+}
+
+// spill stores an item of a migrated shard in the disk tier.
+// The checksum of the record is computed for the shard.
+// The shard is locked to adopt the itemMeta of the item.
+func (S *Shard) spill(key uint64, meta itemMeta, val []byte, record diskRecord) {
+	if S.checksums {
+		record.checksum = checksum(key, uint64(len(val))|record.flags, record.deadline, val)
+	}
+	if err := S.tier.spill(key, val, record); err != nil {
+		meta.space.release()
+		count(&S.metrics.diskErrors)
+		return
+	}
+	if meta.origin != nil || meta.space.space != nil {
+		S.lock.Lock()
+		S.adopt(key, meta)
+		S.lock.Unlock()
+	}
+}
@@ -0,0 +1,198 @@
+package bigmap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTieredMap(t *testing.T, shards int) (BigMap, string) {
+	dir := t.TempDir()
+	bm := New(1024, Config{
+		Shards:            shards,
+		ExpirationFactory: Expires(50*time.Millisecond, ExpirationPolicySweep),
+		OverflowDir:       dir,
+	})
+	t.Cleanup(func() { bm.Close() })
+	return bm, dir
+}
+
+// spillAll waits until the sweep expiration service
+// can evict every item and triggers it in every shard.
+func spillAll(bm *BigMap) {
+	time.Sleep(70 * time.Millisecond)
+	for _, s := range bm.loadTable().shards {
+		s.Get(0)
+	}
+}
+
+func tierValue(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 1000)
+}
+
+func TestBigMap_Overflow(t *testing.T) {
+	bm, _ := newTieredMap(t, 2)
+	for i := 0; i < 100; i++ {
+		bm.Put([]byte(fmt.Sprint(i)), tierValue(i))
+	}
+	spillAll(&bm)
+	metrics := bm.TotalMetrics()
+	if metrics.Spills != 100 || metrics.DiskItems != 100 || metrics.Items != 0 {
+		t.Fatalf("expected all items to be spilled, got %+v", metrics)
+	}
+	if bm.Len() != 100 {
+		t.Fatalf("Len got %d, want 100", bm.Len())
+	}
+	for i := 0; i < 50; i++ {
+		if val, ok := bm.Get([]byte(fmt.Sprint(i))); !ok || !bytes.Equal(val, tierValue(i)) {
+			t.Fatalf("Get of spilled item %d got %d bytes, %v", i, len(val), ok)
+		}
+	}
+	metrics = bm.TotalMetrics()
+	if metrics.Promotions != 50 || metrics.DiskItems != 50 || metrics.Items != 50 {
+		t.Fatalf("expected the read items to be promoted, got %+v", metrics)
+	}
+
+	if !bm.Delete([]byte("60")) {
+		t.Fatalf("Delete of a spilled item returned false")
+	}
+	if _, ok := bm.Get([]byte("60")); ok {
+		t.Fatalf("deleted item was promoted")
+	}
+	bm.Put([]byte("61"), []byte("new"))
+	if val, _ := bm.Get([]byte("61")); string(val) != "new" {
+		t.Fatalf("Get after overwriting a spilled item got %d bytes", len(val))
+	}
+	if ttl, ok := bm.TTL([]byte("62")); !ok || ttl != 0 {
+		t.Fatalf("TTL of a spilled item got %v, %v", ttl, ok)
+	}
+
+	bm.PutTTL([]byte("ttl"), []byte("value"), 100*time.Millisecond)
+	spillAll(&bm)
+	if metrics := bm.TotalMetrics(); metrics.DiskItems != 100 {
+		t.Fatalf("expected the item with a ttl to be spilled, got %d items on disk", metrics.DiskItems)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := bm.Get([]byte("ttl")); ok {
+		t.Fatalf("expired item was promoted")
+	}
+}
+
+func TestBigMap_OverflowCompaction(t *testing.T) {
+	bm, dir := newTieredMap(t, 1)
+	for i := 0; i < 200; i++ {
+		bm.Put([]byte(fmt.Sprint(i)), tierValue(i))
+	}
+	spillAll(&bm)
+	for i := 0; i < 150; i++ {
+		bm.Get([]byte(fmt.Sprint(i)))
+	}
+	tier := bm.loadTable().shards[0].tier
+	compacting := func() bool {
+		tier.lock.Lock()
+		defer tier.lock.Unlock()
+		return tier.compacting
+	}
+	deadline := time.Now().Add(time.Second)
+	for bm.TotalMetrics().Compactions == 0 || compacting() {
+		if time.Now().After(deadline) {
+			t.Fatalf("log wasn't compacted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// reads which raced the background compaction may have left
+	// garbage, a second compaction leaves exactly the live items
+	tier.lock.Lock()
+	tier.compacting = true
+	tier.lock.Unlock()
+	tier.compact()
+	if metrics := bm.TotalMetrics(); metrics.DiskBytes != 50*1000 || metrics.DiskItems != 50 {
+		t.Fatalf("expected the 50 unread items without garbage, got %d items in %d bytes", metrics.DiskItems, metrics.DiskBytes)
+	}
+	for i := 150; i < 200; i++ {
+		if val, ok := bm.Get([]byte(fmt.Sprint(i))); !ok || !bytes.Equal(val, tierValue(i)) {
+			t.Fatalf("Get after compaction of item %d got %d bytes, %v", i, len(val), ok)
+		}
+	}
+
+	if err := bm.Reshard(3); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	spillAll(&bm)
+	if err := bm.Reshard(1); err != nil {
+		t.Fatalf("Reshard: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		if val, ok := bm.Get([]byte(fmt.Sprint(i))); !ok || !bytes.Equal(val, tierValue(i)) {
+			t.Fatalf("Get after reshard of item %d got %d bytes, %v", i, len(val), ok)
+		}
+	}
+	spillAll(&bm)
+	if err := bm.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("Close left %d files", len(files))
+	}
+}
+
+func TestDiskTier_takeFailed(t *testing.T) {
+	tier := newDiskTier(t.TempDir(), DefaultOverflowGarbage, &shardMetrics{})
+	defer tier.close()
+	if err := tier.spill(1, []byte("value"), diskRecord{}); err != nil {
+		t.Fatalf("spill: %v", err)
+	}
+	file := tier.file
+	tier.file.Close()
+	if _, _, ok := tier.take(1); ok {
+		t.Fatalf("take of a closed log succeeded")
+	}
+	if !tier.contains(1) {
+		t.Fatalf("failed take removed the value from the tier")
+	}
+	tier.file, _ = os.Open(file.Name())
+	if val, _, ok := tier.take(1); !ok || string(val) != "value" || tier.contains(1) {
+		t.Fatalf("take got %q, %v", val, ok)
+	}
+}
+
+func TestBigMap_OverflowWatch(t *testing.T) {
+	dir := t.TempDir()
+	bm := New(1024, Config{
+		Shards:            1,
+		ExpirationFactory: Expires(50*time.Millisecond, ExpirationPolicySweep),
+		OverflowDir:       dir,
+		EventKeys:         true,
+		EventValues:       true,
+	})
+	defer bm.Close()
+	bm.Put([]byte("spilled"), tierValue(1))
+	spillAll(&bm)
+	if metrics := bm.TotalMetrics(); metrics.DiskItems != 1 {
+		t.Fatalf("expected the item to be spilled, got %d items on disk", metrics.DiskItems)
+	}
+	events := &recorder{}
+	cancel := bm.Watch(events.record)
+	defer cancel()
+	bm.Delete([]byte("spilled"))
+	got := events.wait(t, 1)
+	if got[0].Type != EventDelete || string(got[0].Key) != "spilled" || !bytes.Equal(got[0].Value, tierValue(1)) {
+		t.Fatalf("expected the delete of the spilled item with its value, got %v of %q", got[0].Type, got[0].Key)
+	}
+
+	bm.PutTTL([]byte("expiring"), tierValue(2), 100*time.Millisecond)
+	spillAll(&bm)
+	if metrics := bm.TotalMetrics(); metrics.DiskItems != 1 {
+		t.Fatalf("expected the expiring item to be spilled, got %d items on disk", metrics.DiskItems)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := bm.Get([]byte("expiring")); ok {
+		t.Fatalf("Get of an expired spilled item got ok")
+	}
+	got = events.wait(t, 3)
+	if got[2].Type != EventExpire || !bytes.Equal(got[2].Value, tierValue(2)) {
+		t.Fatalf("expected the expiration of the spilled item with its value, got %v", got[2].Type)
+	}
+}
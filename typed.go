@@ -0,0 +1,114 @@
+package bigmap
+
+import (
+	"sync"
+)
+
+// KeyEncoder encodes keys of a TypedMap into bytes.
+type KeyEncoder[K any] interface {
+	// AppendKey appends the encoded key to dst
+	// and returns the extended slice.
+	AppendKey(dst []byte, key K) []byte
+}
+
+// Codec encodes and decodes values of a TypedMap.
+type Codec[V any] interface {
+	// Append appends the encoded value to dst
+	// and returns the extended slice.
+	Append(dst []byte, val V) ([]byte, error)
+	// Decode decodes src into val.
+	// The src slice is only valid for the duration of
+	// the call and must not be retained.
+	Decode(src []byte, val *V) error
+}
+
+// TypedMap is a BigMap which encodes its keys and values
+// with a KeyEncoder and a Codec.
+//
+// The buffers used to encode and decode items are pooled
+// therefore Put and GetInto don't allocate if the
+// KeyEncoder and Codec don't.
+type TypedMap[K, V any] struct {
+	bigmap  *BigMap
+	keys    KeyEncoder[K]
+	values  Codec[V]
+	buffers sync.Pool
+}
+
+type typedBuffer struct {
+	key []byte
+	val []byte
+}
+
+// NewTypedMap creates a new TypedMap storing its items in the bigmap.
+func NewTypedMap[K, V any](bigmap *BigMap, keys KeyEncoder[K], values Codec[V]) *TypedMap[K, V] {
+	entrysize := bigmap.EntrySize()
+	return &TypedMap[K, V]{
+		bigmap: bigmap,
+		keys:   keys,
+		values: values,
+		buffers: sync.Pool{
+			New: func() interface{} {
+				return &typedBuffer{
+					key: make([]byte, 0, 64),
+					val: make([]byte, entrysize),
+				}
+			},
+		},
+	}
+}
+
+// Map returns the underlying BigMap.
+func (T *TypedMap[K, V]) Map() *BigMap {
+	return T.bigmap
+}
+
+// Put encodes the key and value and puts them into the map.
+// An error is returned if the value couldn't be encoded
+// or the encoded value is to big.
+func (T *TypedMap[K, V]) Put(key K, val V) error {
+	buf := T.buffers.Get().(*typedBuffer)
+	defer T.buffers.Put(buf)
+	buf.key = T.keys.AppendKey(buf.key[:0], key)
+	encoded, err := T.values.Append(buf.val[:0], val)
+	if err != nil {
+		return err
+	}
+	return T.bigmap.Put(buf.key, encoded)
+}
+
+// Get retrieves and decodes the value for the key.
+// It returns the value, true if the item was contained
+// and the error of the Codec if decoding failed.
+func (T *TypedMap[K, V]) Get(key K) (V, bool, error) {
+	var val V
+	ok, err := T.GetInto(key, &val)
+	return val, ok, err
+}
+
+// GetInto retrieves the value for the key and decodes it into val.
+// It returns true if the item was contained and the error
+// of the Codec if decoding failed.
+func (T *TypedMap[K, V]) GetInto(key K, val *V) (bool, error) {
+	buf := T.buffers.Get().(*typedBuffer)
+	defer T.buffers.Put(buf)
+	buf.key = T.keys.AppendKey(buf.key[:0], key)
+	size, ok := T.bigmap.GetInto(buf.key, buf.val)
+	for ok && size > uint64(len(buf.val)) {
+		// compressed items can be larger than the entrysize
+		buf.val = make([]byte, size)
+		size, ok = T.bigmap.GetInto(buf.key, buf.val)
+	}
+	if !ok {
+		return false, nil
+	}
+	return true, T.values.Decode(buf.val[:size], val)
+}
+
+// Delete removes the item for the key from the map.
+func (T *TypedMap[K, V]) Delete(key K) bool {
+	buf := T.buffers.Get().(*typedBuffer)
+	defer T.buffers.Put(buf)
+	buf.key = T.keys.AppendKey(buf.key[:0], key)
+	return T.bigmap.Delete(buf.key)
+}
@@ -0,0 +1,79 @@
+package bigmap
+
+import (
+	"testing"
+)
+
+type typedPoint struct {
+	X, Y int32
+	Z    float64
+}
+
+func BenchmarkTypedMap_GetInto(b *testing.B) {
+	bigmap := New(IntegerBytes)
+	typed := NewTypedMap[uint64, uint64](&bigmap, IntegerKeys[uint64]{}, IntegerCodec[uint64]{})
+	for i := uint64(0); i < uint64(b.N); i++ {
+		typed.Put(i, i)
+	}
+	var val uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := uint64(0); i < uint64(b.N); i++ {
+		typed.GetInto(i, &val)
+	}
+}
+
+func TestTypedMap(t *testing.T) {
+	bigmap := New(100)
+	typed := NewTypedMap[string, string](&bigmap, StringKeys{}, StringCodec{})
+	for i := 0; i < 1024; i++ {
+		if err := typed.Put(string(GenKey(i)), string(GenSafeKey("val", i))); err != nil {
+			t.Fatalf("typed put: %v", err)
+		}
+	}
+	for i := 0; i < 1024; i++ {
+		val, ok, err := typed.Get(string(GenKey(i)))
+		if !ok || err != nil || val != string(GenSafeKey("val", i)) {
+			t.Fatalf("TypedMap.Get() got = %q,%v,%v, want %q,true,nil", val, ok, err, GenSafeKey("val", i))
+		}
+	}
+	if !typed.Delete(string(GenKey(0))) {
+		t.Fatalf("delete expected")
+	}
+	if _, ok, _ := typed.Get(string(GenKey(0))); ok {
+		t.Fatalf("TypedMap.Get() after Delete got ok, want !ok")
+	}
+}
+
+func testCodec[V comparable](t *testing.T, codec Codec[V], val V) {
+	bigmap := New(256)
+	typed := NewTypedMap[int, V](&bigmap, IntegerKeys[int]{}, codec)
+	if err := typed.Put(-1, val); err != nil {
+		t.Fatalf("typed put: %v", err)
+	}
+	got, ok, err := typed.Get(-1)
+	if !ok || err != nil || got != val {
+		t.Fatalf("TypedMap.Get() got = %v,%v,%v, want %v,true,nil", got, ok, err, val)
+	}
+}
+
+func TestTypedMap_codecs(t *testing.T) {
+	point := typedPoint{X: 1, Y: -2, Z: 3.5}
+	testCodec[int64](t, IntegerCodec[int64]{}, -1234)
+	testCodec[typedPoint](t, BinaryCodec[typedPoint]{}, point)
+	testCodec[typedPoint](t, GobCodec[typedPoint]{}, point)
+	testCodec[typedPoint](t, JSONCodec[typedPoint]{}, point)
+}
+
+func TestTypedMap_GetInto_allocations(t *testing.T) {
+	bigmap := New(IntegerBytes)
+	typed := NewTypedMap[uint64, uint64](&bigmap, IntegerKeys[uint64]{}, IntegerCodec[uint64]{})
+	typed.Put(1, 2)
+	var val uint64
+	allocs := testing.AllocsPerRun(100, func() {
+		typed.GetInto(1, &val)
+	})
+	if allocs != 0 || val != 2 {
+		t.Fatalf("TypedMap.GetInto() got %v allocs and %d, want 0 allocs and 2", allocs, val)
+	}
+}
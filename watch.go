@@ -0,0 +1,393 @@
+package bigmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEventBuffer is the default amount of events
+// buffered per shard for watchers.
+const DefaultEventBuffer = 1024
+
+// EventType is the kind of change reported by an Event.
+type EventType uint8
+
+const (
+	// EventPut is emitted when an item is stored.
+	EventPut EventType = iota + 1
+	// EventDelete is emitted when an item is deleted.
+	EventDelete
+	// EventExpire is emitted when an item is removed
+	// because its ttl passed.
+	EventExpire
+	// EventEvict is emitted when an item is removed
+	// by the ExpirationService.
+	EventEvict
+	// EventOverflow is delivered in place of the events of a shard
+	// which were dropped because its buffer was full.
+	// Hash, Key and Value are empty.
+	EventOverflow
+)
+
+func (E EventType) String() string {
+	switch E {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	case EventOverflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
+// Event is a change of the map reported to watchers.
+type Event struct {
+	Type EventType
+	// Hash is the hash of the key of the item.
+	Hash uint64
+	// Key is the key of the item if Config.EventKeys is set and
+	// the change was made using a byte or string key.
+	// Expirations and evictions don't know the key.
+	Key []byte
+	// Value is the stored value for puts or the removed value
+	// otherwise if Config.EventValues is set.
+	Value []byte
+	// TTL is the remaining time to live of the stored item for puts,
+	// 0 if the item doesn't expire.
+	TTL time.Duration
+	// Structure is set for puts of hashes, lists and sets.
+	// Their value is restored by BigMap.PutStructureHash.
+	Structure bool
+}
+
+// Watch calls fn for every change of the map until cancel is called.
+//
+// Changes are recorded into per-shard ring buffers while the shard
+// is locked and delivered by a single goroutine afterwards, so fn
+// never blocks the map. The changes of a shard are delivered in order.
+// If fn can't keep up the ring buffers overflow and the
+// events are dropped, see Metrics.DroppedEvents. An EventOverflow
+// is delivered in their place once the buffer has room again.
+//
+// Key and Value of the event must not be retained after fn returns.
+// Changing the ttl with Expire is reported as EventPut.
+// Migrations of Reshard as well as Clear and Reset aren't reported.
+func (B *BigMap) Watch(fn func(Event)) (cancel func()) {
+	W := B.watch
+	W.lock.Lock()
+	defer W.lock.Unlock()
+	sub := &subscriber{fn: fn}
+	W.subs = append(W.subs, sub)
+	if len(W.subs) == 1 {
+		B.startWatching()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			W.lock.Lock()
+			defer W.lock.Unlock()
+			for i, registered := range W.subs {
+				if registered == sub {
+					W.subs = append(W.subs[:i:i], W.subs[i+1:]...)
+					break
+				}
+			}
+			if len(W.subs) == 0 {
+				B.stopWatching()
+			}
+		})
+	}
+}
+
+type subscriber struct {
+	fn func(Event)
+}
+
+// watchHub dispatches the events of all shards of a map.
+type watchHub struct {
+	lock     sync.Mutex
+	subs     []*subscriber
+	ringLock sync.Mutex
+	rings    []*eventRing
+	active   uint32
+	keys     bool
+	wake     chan struct{}
+	done     chan struct{}
+	// dispatching is held by the running dispatcher so a
+	// restarted dispatcher waits for the previous one to exit.
+	dispatching sync.Mutex
+}
+
+func newWatchHub(config Config) *watchHub {
+	return &watchHub{
+		keys: config.EventKeys,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// origin returns the key to be reported to watchers.
+// The string is only converted if a watcher needs it.
+func (W *watchHub) origin(key string) []byte {
+	if W.wantsKeys() {
+		return []byte(key)
+	}
+	return nil
+}
+
+// wantsKeys reports whether a watcher needs the keys of events.
+func (W *watchHub) wantsKeys() bool {
+	return W.keys && atomic.LoadUint32(&W.active) != 0
+}
+
+// startWatching installs the ring buffers and starts the dispatcher.
+// W.lock must be held.
+func (B *BigMap) startWatching() {
+	W := B.watch
+	B.resharder.Lock()
+	atomic.StoreUint32(&W.active, 1)
+	table := B.loadTable()
+	for _, shards := range [][]*Shard{table.shards, table.prev} {
+		for _, s := range shards {
+			B.installRing(s)
+		}
+	}
+	B.resharder.Unlock()
+	W.done = make(chan struct{})
+	go W.dispatch(W.done)
+}
+
+// stopWatching removes the ring buffers and stops the dispatcher.
+// Pending events are dropped.
+// W.lock must be held.
+func (B *BigMap) stopWatching() {
+	W := B.watch
+	B.resharder.Lock()
+	atomic.StoreUint32(&W.active, 0)
+	W.ringLock.Lock()
+	for _, ring := range W.rings {
+		ring.shard.events.Store((*eventRing)(nil))
+	}
+	W.rings = nil
+	W.ringLock.Unlock()
+	B.resharder.Unlock()
+	close(W.done)
+}
+
+// installRing adds a ring buffer to the shard if the map is watched.
+// B.resharder must be held.
+func (B *BigMap) installRing(shard *Shard) {
+	W := B.watch
+	if atomic.LoadUint32(&W.active) == 0 {
+		return
+	}
+	size := B.config.EventBuffer
+	if size <= 0 {
+		size = DefaultEventBuffer
+	}
+	ring := &eventRing{
+		shard:  shard,
+		slots:  make([]eventSlot, size),
+		keys:   B.config.EventKeys,
+		values: B.config.EventValues,
+		wake:   W.wake,
+	}
+	shard.events.Store(ring)
+	W.ringLock.Lock()
+	W.rings = append(W.rings, ring)
+	W.ringLock.Unlock()
+}
+
+func (W *watchHub) dispatch(done chan struct{}) {
+	W.dispatching.Lock()
+	defer W.dispatching.Unlock()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if W.drain() {
+			continue
+		}
+		select {
+		case <-done:
+			return
+		case <-W.wake:
+		}
+	}
+}
+
+// drain delivers the pending events of all rings
+// and returns true if any event was delivered.
+func (W *watchHub) drain() bool {
+	W.lock.Lock()
+	subs := W.subs
+	W.lock.Unlock()
+	W.ringLock.Lock()
+	rings := W.rings
+	W.ringLock.Unlock()
+	delivered := false
+	for _, ring := range rings {
+		head := atomic.LoadUint64(&ring.head)
+		tail := atomic.LoadUint64(&ring.tail)
+		for ; head < tail; head++ {
+			event := ring.slots[head%uint64(len(ring.slots))].event()
+			for _, sub := range subs {
+				sub.fn(event)
+			}
+			atomic.StoreUint64(&ring.head, head+1)
+			delivered = true
+		}
+		if ring.shard.isRetired() {
+			W.removeRing(ring)
+		}
+	}
+	return delivered
+}
+
+// removeRing drops the ring of a retired shard once it was drained.
+func (W *watchHub) removeRing(ring *eventRing) {
+	if atomic.LoadUint64(&ring.head) != atomic.LoadUint64(&ring.tail) {
+		return
+	}
+	W.ringLock.Lock()
+	defer W.ringLock.Unlock()
+	for i, registered := range W.rings {
+		if registered == ring {
+			W.rings = append(W.rings[:i:i], W.rings[i+1:]...)
+			return
+		}
+	}
+}
+
+// eventRing is a bounded buffer of the events of a shard.
+// Events are pushed while the shard is locked and
+// consumed by the dispatcher only.
+type eventRing struct {
+	// lock serializes producers which don't hold the shard lock
+	// exclusively, like evictions of the ExpirationService.
+	lock   sync.Mutex
+	shard  *Shard
+	slots  []eventSlot
+	head   uint64
+	tail   uint64
+	keys   bool
+	values bool
+	wake   chan struct{}
+	// overflowed is set when events were dropped and
+	// an EventOverflow has to be pushed before the next event.
+	overflowed bool
+}
+
+type eventSlot struct {
+	typ       EventType
+	hash      uint64
+	deadline  int64
+	key       []byte
+	value     []byte
+	hasKey    bool
+	hasValue  bool
+	structure bool
+}
+
+func (E *eventSlot) event() Event {
+	event := Event{Type: E.typ, Hash: E.hash, TTL: ttlOf(E.deadline), Structure: E.structure}
+	if E.hasKey {
+		event.Key = E.key
+	}
+	if E.hasValue {
+		event.Value = E.value
+	}
+	return event
+}
+
+// push records an event or drops it if the ring is full.
+// The slot buffers are reused, so pushing doesn't allocate
+// once the ring was filled.
+func (R *eventRing) push(typ EventType, hash uint64, key, value []byte, deadline int64, structure bool) bool {
+	R.lock.Lock()
+	tail := R.tail
+	needed := uint64(1)
+	if R.overflowed {
+		needed++
+	}
+	if tail-atomic.LoadUint64(&R.head)+needed > uint64(len(R.slots)) {
+		R.overflowed = true
+		R.lock.Unlock()
+		return false
+	}
+	if R.overflowed {
+		marker := &R.slots[tail%uint64(len(R.slots))]
+		marker.typ = EventOverflow
+		marker.hash = 0
+		marker.deadline = 0
+		marker.hasKey = false
+		marker.hasValue = false
+		marker.structure = false
+		R.overflowed = false
+		tail++
+	}
+	slot := &R.slots[tail%uint64(len(R.slots))]
+	slot.typ = typ
+	slot.hash = hash
+	slot.deadline = deadline
+	slot.structure = structure
+	slot.hasKey = R.keys && key != nil
+	if slot.hasKey {
+		slot.key = append(slot.key[:0], key...)
+	}
+	slot.hasValue = R.values && value != nil
+	if slot.hasValue {
+		slot.value = append(slot.value[:0], value...)
+	}
+	atomic.StoreUint64(&R.tail, tail+1)
+	R.lock.Unlock()
+	select {
+	case R.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (S *Shard) ring() *eventRing {
+	ring, _ := S.events.Load().(*eventRing)
+	return ring
+}
+
+// notify reports a change of the item to watchers.
+// The structure flag marks values holding a data structure.
+func (S *Shard) notify(typ EventType, key uint64, origin, val []byte, deadline int64, structure bool) {
+	if ring := S.ring(); ring != nil && !ring.push(typ, key, origin, val, deadline, structure) {
+		count(&S.metrics.droppedEvents)
+	}
+}
+
+// notifyRemove reports the removal of the item to watchers.
+// It must be called before the item is deleted.
+func (S *Shard) notifyRemove(typ EventType, key uint64, origin []byte) {
+	ring := S.ring()
+	if ring == nil {
+		return
+	}
+	var val []byte
+	if ptr, ok := S.ptrs.Get(key); ok {
+		if ring.values {
+			val, _, _ = S.value(key, ptr)
+		}
+	} else if S.tier != nil && S.tier.contains(key) {
+		if ring.values {
+			val = S.diskValue(key)
+		}
+	} else {
+		return
+	}
+	if !ring.push(typ, key, origin, val, 0, false) {
+		count(&S.metrics.droppedEvents)
+	}
+}
@@ -0,0 +1,147 @@
+package bigmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func (R *recorder) record(event Event) {
+	R.lock.Lock()
+	defer R.lock.Unlock()
+	event.Key = append([]byte(nil), event.Key...)
+	event.Value = append([]byte(nil), event.Value...)
+	R.events = append(R.events, event)
+}
+
+func (R *recorder) wait(t *testing.T, n int) []Event {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		R.lock.Lock()
+		if len(R.events) >= n {
+			events := R.events
+			R.lock.Unlock()
+			return events
+		}
+		R.lock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d events, got %v", n, R.events)
+	return nil
+}
+
+func TestBigMap_Watch(t *testing.T) {
+	bm := New(8, Config{Shards: 4, EventKeys: true, EventValues: true})
+	bm.Put([]byte("before"), []byte("value"))
+	events := &recorder{}
+	cancel := bm.Watch(events.record)
+
+	bm.Put([]byte("key"), []byte("value"))
+	bm.PutString("string", []byte("other"))
+	bm.PutUint64(1, []byte("int"))
+	bm.Delete([]byte("key"))
+	bm.PutTTL([]byte("ttl"), []byte("short"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	bm.Get([]byte("ttl"))
+
+	got := events.wait(t, 6)
+	want := map[string]Event{
+		"key":    {Type: EventPut, Value: []byte("value")},
+		"string": {Type: EventPut, Value: []byte("other")},
+		"ttl":    {Type: EventPut, Value: []byte("short")},
+	}
+	var deletes, expirations, ints int
+	for _, event := range got {
+		switch {
+		case event.Type == EventDelete && string(event.Key) == "key" && string(event.Value) == "value":
+			deletes++
+		case event.Type == EventExpire && event.Hash == bm.hasher.Hash([]byte("ttl")) && len(event.Key) == 0:
+			expirations++
+		case event.Type == EventPut && event.Hash == Mix64(1) && len(event.Key) == 0:
+			ints++
+		case event.Type == EventPut:
+			w, ok := want[string(event.Key)]
+			if !ok || string(w.Value) != string(event.Value) {
+				t.Fatalf("unexpected event %v", event)
+			}
+		}
+	}
+	if deletes != 1 || expirations != 1 || ints != 1 {
+		t.Fatalf("unexpected events %v", got)
+	}
+
+	cancel()
+	bm.Put([]byte("after"), []byte("value"))
+	time.Sleep(10 * time.Millisecond)
+	if n := len(events.wait(t, 6)); n != 6 {
+		t.Fatalf("expected no events after cancel, got %d", n)
+	}
+}
+
+func TestBigMap_WatchDrops(t *testing.T) {
+	bm := New(8, Config{Shards: 1, EventBuffer: 4})
+	block := make(chan struct{})
+	events := &recorder{}
+	cancel := bm.Watch(func(event Event) {
+		<-block
+		events.record(event)
+	})
+	defer cancel()
+	for i := uint64(0); i < 100; i++ {
+		bm.PutUint64(i, []byte("value"))
+	}
+	close(block)
+	// the first event may have been taken before the ring filled up
+	got := len(events.wait(t, 4))
+	dropped := bm.TotalMetrics().DroppedEvents
+	if uint64(got)+dropped != 100 || dropped < 95 {
+		t.Fatalf("expected 100 events with drops, got %d and %d dropped", got, dropped)
+	}
+
+	bm.PutUint64(100, []byte("value"))
+	tail := events.wait(t, got+2)[got:]
+	if len(tail) != 2 || tail[0].Type != EventOverflow || tail[1].Hash != Mix64(100) {
+		t.Fatalf("expected an overflow before the next event, got %v", tail)
+	}
+}
+
+func TestBigMap_WatchTTL(t *testing.T) {
+	bm := New(8, Config{Shards: 1, EventValues: true})
+	events := &recorder{}
+	cancel := bm.Watch(events.record)
+	defer cancel()
+	bm.PutTTL([]byte("key"), []byte("value"), time.Hour)
+	bm.Expire([]byte("key"), 2*time.Hour)
+	got := events.wait(t, 2)
+	if got[0].TTL <= 0 || got[0].TTL > time.Hour {
+		t.Fatalf("expected a ttl of an hour, got %v", got[0].TTL)
+	}
+	if got[1].Type != EventPut || got[1].TTL <= time.Hour || string(got[1].Value) != "value" {
+		t.Fatalf("expected expire to be reported as put, got %v", got[1])
+	}
+}
+
+func TestBigMap_WatchReshard(t *testing.T) {
+	bm := New(8, Config{Shards: 2})
+	events := &recorder{}
+	cancel := bm.Watch(events.record)
+	defer cancel()
+	for i := uint64(0); i < 10; i++ {
+		bm.PutUint64(i, []byte("value"))
+	}
+	bm.Reshard(5)
+	for i := uint64(0); i < 10; i++ {
+		bm.DeleteUint64(i)
+	}
+	got := events.wait(t, 20)
+	time.Sleep(10 * time.Millisecond)
+	if len(got) != 20 {
+		t.Fatalf("migrations must not be reported, got %d events", len(got))
+	}
+}